@@ -1,7 +1,9 @@
 package errors
 
 type CommandError struct {
-	Unauthorized    bool
+	Unauthorized bool
+	// nothing is sent back e.g. to someone who isn't a member talking in a group chat
+	Silent          bool
 	ResponseMessage string
 	ChatId          int64
 }
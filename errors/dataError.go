@@ -0,0 +1,13 @@
+package errors
+
+const (
+	DATA_ERROR_TYPE_CONFLICT int = iota
+)
+
+type DataError struct {
+	Type int
+}
+
+func (e *DataError) Error() string {
+	return "Data error"
+}
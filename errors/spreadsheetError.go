@@ -0,0 +1,20 @@
+package errors
+
+const (
+	// the cell no longer holds what we expected e.g. it was edited after the change being undone
+	SPREADSHEET_ERROR_TYPE_CHANGED int = iota
+	// a category still has amounts in it so deleting it needs confirming
+	SPREADSHEET_ERROR_TYPE_NOT_EMPTY int = iota
+	// there is no tab for the month asked for, the cell is the tab name looked for e.g. Mar 2026
+	SPREADSHEET_ERROR_TYPE_NO_SHEET int = iota
+)
+
+type SpreadsheetError struct {
+	Type int
+	// where it happened e.g. Sheet1!E5
+	Cell string
+}
+
+func (e *SpreadsheetError) Error() string {
+	return "Spreadsheet error"
+}
@@ -0,0 +1,870 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"telegram-spreadsheet-editor/utils"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	CALLBACK_PREFIX_UPDATE  string = "UPDATE"
+	CALLBACK_PREFIX_REFUND  string = "REFUND"
+	CALLBACK_PREFIX_READ    string = "READ"
+	CALLBACK_PREFIX_DETAILS string = "DETAILS"
+	CALLBACK_PREFIX_REMOVE  string = "REMOVE"
+	CALLBACK_PREFIX_RENAME  string = "RENAME"
+	CALLBACK_PREFIX_DELETE  string = "DELETE"
+)
+
+const (
+	DEFAULT_HISTORY_COUNT int    = 10
+	HISTORY_TIME_FORMAT   string = "2 Jan 15:04"
+)
+
+// Registers every command the bot understands, HELP lists them in this order
+func (r *DataHandler) RegisterCommands() {
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_PING,
+		Keywords: []string{"ping"},
+		Help:     "Pong.",
+		Handler:  r.handlePing,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_LIST,
+		Keywords: []string{"list", "l"},
+		// for a month e.g. "list march"
+		Inline:  true,
+		Help:    "Lists all cost and earnings categories and their totals. Add a month for another one e.g. LIST MARCH",
+		Handler: r.handleList,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_BUDGET,
+		Keywords: []string{"budget", "budgets"},
+		Inline:   true,
+		Help:     "Lists spend against budget for every cost category that has one. Add a month for another one e.g. BUDGET MARCH",
+		Handler:  r.handleBudget,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:           model.COMMAND_TYPE_UPDATE,
+		Role:           model.ROLE_EDITOR,
+		Keywords:       []string{"update", "u"},
+		Inline:         true,
+		TakesAmount:    true,
+		CallbackPrefix: CALLBACK_PREFIX_UPDATE,
+		FromCallback: func(command *model.Command, category string, earning bool) {
+			// earnings share the update flow
+			command.Type = model.COMMAND_TYPE_UPDATE_CATEGORY_CHOSEN
+			command.UpdateData = &model.UpdateData{
+				Category: &category,
+				Earning:  earning,
+			}
+		},
+		Help:    "Add a cost to a category. Or in one go e.g. U GROCERIES 12.50, anything after the amount is a note",
+		Handler: r.handleUpdate,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:        model.COMMAND_TYPE_EARN,
+		Role:        model.ROLE_EDITOR,
+		Keywords:    []string{"earn", "e", "add earning"},
+		Inline:      true,
+		TakesAmount: true,
+		Prepare: func(command *model.Command) {
+			command.UpdateData.Earning = true
+		},
+		Help:    "Add an earning to a category. Or in one go e.g. E SALARY 1000",
+		Handler: r.handleUpdate,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:        model.COMMAND_TYPE_REFUND,
+		Role:        model.ROLE_EDITOR,
+		Keywords:    []string{"refund"},
+		Inline:      true,
+		TakesAmount: true,
+		Prepare: func(command *model.Command) {
+			command.UpdateData.Refund = true
+		},
+		CallbackPrefix: CALLBACK_PREFIX_REFUND,
+		FromCallback: func(command *model.Command, category string, earning bool) {
+			command.Type = model.COMMAND_TYPE_UPDATE_CATEGORY_CHOSEN
+			command.UpdateData = &model.UpdateData{
+				Category: &category,
+				Refund:   true,
+			}
+		},
+		Help:    "Take a refund off a cost category. Or in one go e.g. REFUND GROCERIES 4.99",
+		Handler: r.handleUpdate,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:    model.COMMAND_TYPE_UPDATE_CATEGORY_CHOSEN,
+		Role:    model.ROLE_EDITOR,
+		Handler: r.handleUpdateCategoryChosen,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:    model.COMMAND_TYPE_NUMERICAL_AMOUNT,
+		Role:    model.ROLE_EDITOR,
+		Handler: r.handleAmount,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:           model.COMMAND_TYPE_READ,
+		Keywords:       []string{"read", "r"},
+		Inline:         true,
+		CallbackPrefix: CALLBACK_PREFIX_READ,
+		FromCallback: func(command *model.Command, category string, earning bool) {
+			command.Type = model.COMMAND_TYPE_READ_CATEGORY_CHOSEN
+			command.ReadData = &model.ReadData{
+				Category: category,
+				Earning:  earning,
+			}
+		},
+		Help:    "Read the value of a cost or earnings category e.g. R, R GROCERIES or R GROCERIES MARCH for another month",
+		Handler: r.handleRead,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:    model.COMMAND_TYPE_READ_CATEGORY_CHOSEN,
+		Handler: r.handleReadCategoryChosen,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:           model.COMMAND_TYPE_DETAILS,
+		Keywords:       []string{"details"},
+		Inline:         true,
+		CallbackPrefix: CALLBACK_PREFIX_DETAILS,
+		FromCallback: func(command *model.Command, category string, earning bool) {
+			command.Type = model.COMMAND_TYPE_DETAILS_CATEGORY_CHOSEN
+			command.DetailsData = &model.DetailsData{
+				Category: category,
+				Earning:  earning,
+			}
+		},
+		Help:    "Get all the amounts of a category e.g. 2+4+6.",
+		Handler: r.handleDetails,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:    model.COMMAND_TYPE_DETAILS_CATEGORY_CHOSEN,
+		Handler: r.handleDetailsCategoryChosen,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:           model.COMMAND_TYPE_REMOVE,
+		Role:           model.ROLE_EDITOR,
+		Keywords:       []string{"remove"},
+		Inline:         true,
+		CallbackPrefix: CALLBACK_PREFIX_REMOVE,
+		FromCallback: func(command *model.Command, category string, earning bool) {
+			command.Type = model.COMMAND_TYPE_REMOVE_CATEGORY_CHOSEN
+			command.RemoveData = &model.RemoveData{
+				Category: category,
+				Earning:  earning,
+			}
+		},
+		Help:    "Delete the last added amount in a category.",
+		Handler: r.handleRemove,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:    model.COMMAND_TYPE_REMOVE_CATEGORY_CHOSEN,
+		Role:    model.ROLE_EDITOR,
+		Handler: r.handleRemoveCategoryChosen,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_UNDO,
+		Role:     model.ROLE_EDITOR,
+		Keywords: []string{"undo"},
+		Inline:   true,
+		Help:     "Undo the last change to the spreadsheet whichever category it was in. Or the last few e.g. UNDO 3",
+		Handler:  r.handleUndo,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_HISTORY,
+		Keywords: []string{"history"},
+		Inline:   true,
+		Help:     "List the latest changes and who made them e.g. HISTORY or HISTORY 20",
+		Handler:  r.handleHistory,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_ADD_CATEGORY,
+		Role:     model.ROLE_ADMIN,
+		Keywords: []string{"add category"},
+		Inline:   true,
+		Help:     "Add a cost category to the current month e.g. ADD CATEGORY PETS",
+		Handler:  r.handleAddCategory,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_ADD_EARNING_CATEGORY,
+		Role:     model.ROLE_ADMIN,
+		Keywords: []string{"add earning category"},
+		Inline:   true,
+		Help:     "Add an earnings category to the current month e.g. ADD EARNING CATEGORY BONUS",
+		Handler:  r.handleAddCategory,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_RENAME_CATEGORY,
+		Role:     model.ROLE_ADMIN,
+		Keywords: []string{"rename category"},
+		Inline:   true,
+		Prepare: func(command *model.Command) {
+			// e.g. RENAME CATEGORY groceries TO food, the category is matched like any other
+			category, newName := splitRename(command.CategoryQuery)
+			command.CategoryQuery = category
+			command.CategoryData = &model.CategoryData{
+				NewName: newName,
+			}
+		},
+		CallbackPrefix: CALLBACK_PREFIX_RENAME,
+		FromCallback: func(command *model.Command, category string, earning bool) {
+			command.Type = model.COMMAND_TYPE_RENAME_CATEGORY_CHOSEN
+			command.CategoryData = &model.CategoryData{
+				Category: category,
+				Earning:  earning,
+			}
+		},
+		Help:    "Rename a category in the current month e.g. RENAME CATEGORY GROCERIES TO FOOD",
+		Handler: r.handleRenameCategory,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:    model.COMMAND_TYPE_RENAME_CATEGORY_CHOSEN,
+		Role:    model.ROLE_ADMIN,
+		Handler: r.handleRenameCategoryChosen,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:           model.COMMAND_TYPE_DELETE_CATEGORY,
+		Role:           model.ROLE_ADMIN,
+		Keywords:       []string{"delete category"},
+		Inline:         true,
+		CallbackPrefix: CALLBACK_PREFIX_DELETE,
+		FromCallback: func(command *model.Command, category string, earning bool) {
+			command.Type = model.COMMAND_TYPE_DELETE_CATEGORY_CHOSEN
+			command.CategoryData = &model.CategoryData{
+				Category: category,
+				Earning:  earning,
+			}
+		},
+		Help:    "Delete a category from the current month, asks first if it has amounts in it.",
+		Handler: r.handleDeleteCategory,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:    model.COMMAND_TYPE_DELETE_CATEGORY_CHOSEN,
+		Role:    model.ROLE_ADMIN,
+		Handler: r.handleDeleteCategoryChosen,
+	})
+	// anyone can say yes, only admins can start the DELETE CATEGORY it confirms
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_CONFIRM,
+		Keywords: []string{"yes", "y"},
+		Handler:  r.handleConfirm,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_NEW_MONTH,
+		Role:     model.ROLE_ADMIN,
+		Keywords: []string{"new month"},
+		Help:     "Copy the last tab into a new month's tab with the values reset.",
+		Handler:  r.handleNewMonth,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_CANCEL,
+		Keywords: []string{"cancel"},
+		Help:     "Stop the command in progress e.g. when we are waiting for an amount.",
+		Handler:  r.handleCancel,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_HELP,
+		Keywords: []string{"help"},
+		Help:     "Print this help list.",
+		Handler:  r.handleHelp,
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_DORIS,
+		Keywords: []string{"doris"},
+		Handler:  r.replyWith("\U0001F99B"),
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_BOOBS,
+		Keywords: []string{"boobs"},
+		Handler:  r.replyWith("They're great aren't they."),
+	})
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     model.COMMAND_TYPE_ALICE,
+		Keywords: []string{"alice"},
+		Handler:  r.replyWith("Woooooo!", "\U0001F478", "\U00002728", "\u2764\ufe0f", "\U0001F478 \U00002728 \u2764\ufe0f"),
+	})
+}
+
+// private
+
+func (r *DataHandler) handlePing(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	r.MessagingService.SendTextMessage(message, command.ChatId, "Pong")
+	return nil
+}
+
+func (r *DataHandler) handleList(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	r.MessagingService.SendTextMessage(message, command.ChatId, "Listing... Hang tight...")
+	entries, ok := r.listEntriesWithTotals(message, command.ChatId, command.Month)
+	if !ok {
+		return nil
+	}
+	r.MessagingService.SendEntryList(message, command.ChatId, entries)
+
+	return nil
+}
+
+func (r *DataHandler) handleBudget(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	entries, ok := r.listEntries(message, command.ChatId, command.Month)
+	if !ok {
+		return nil
+	}
+
+	source := r.getSpreadsheetSource(message.UserName)
+	format := func(amount float64) string {
+		return utils.FormatAmount(amount, source.GetCurrency(), source.GetLocale())
+	}
+
+	lines := []string{}
+	spent, budgeted := 0.0, 0.0
+	for _, e := range *entries {
+		if e.Earning || e.Budget == nil {
+			continue
+		}
+
+		line := fmt.Sprintf("%s %s of %s (%.0f%%)", e.Category, format(e.Amount), format(*e.Budget), e.Amount / *e.Budget * 100)
+		if e.Amount > *e.Budget {
+			line = fmt.Sprintf("%s %s over", line, format(e.Amount-*e.Budget))
+		}
+		lines = append(lines, line)
+		spent += e.Amount
+		budgeted += *e.Budget
+	}
+
+	if len(lines) == 0 {
+		r.MessagingService.SendTextMessage(message, command.ChatId, "No budgets set. Add a budgetColumn or budgets to the spreadsheet source in the config.")
+		return nil
+	}
+
+	r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Spend against budget\n%s\n\nTotal %s of %s", strings.Join(lines, "\n"), format(spent), format(budgeted)))
+
+	return nil
+}
+
+func (r *DataHandler) handleUpdate(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	earning := command.Type == model.COMMAND_TYPE_EARN
+	refund := command.Type == model.COMMAND_TYPE_REFUND
+	entries, ok := r.listEntries(message, command.ChatId, command.Month)
+	if !ok {
+		return nil
+	}
+	entries = filterEntries(entries, earning)
+	if earning && len(*entries) == 0 {
+		r.MessagingService.SendTextMessage(message, command.ChatId, "No earnings categories found. Check the earnings columns in your config.")
+		return nil
+	}
+
+	// earnings share the update flow, the callback data marks them as earnings
+	callbackPrefix := CALLBACK_PREFIX_UPDATE
+	if refund {
+		callbackPrefix = CALLBACK_PREFIX_REFUND
+	}
+	entry, ok := r.resolveCategory(message, command, entries, callbackPrefix)
+	if !ok {
+		// a keyboard was sent, any amount given is picked up once a category is chosen
+		return chooseCategory(command)
+	}
+
+	if command.UpdateData == nil || command.UpdateData.Value == nil {
+		// wait for the amount as if the category was chosen from the keyboard
+		chosen := &model.Command{
+			Type:      model.COMMAND_TYPE_UPDATE_CATEGORY_CHOSEN,
+			ChatId:    command.ChatId,
+			MessageId: command.MessageId,
+			UserId:    command.UserId,
+			Month:     command.Month,
+			UpdateData: &model.UpdateData{
+				Category: &entry.Category,
+				Earning:  entry.Earning,
+				Refund:   refund,
+			},
+		}
+		return r.askForAmount(message, chosen)
+	}
+
+	r.addValue(message, &model.Command{
+		Type:      model.COMMAND_TYPE_UPDATE_FULL,
+		ChatId:    command.ChatId,
+		MessageId: command.MessageId,
+		UserId:    command.UserId,
+		Month:     command.Month,
+		UpdateData: &model.UpdateData{
+			Category:   &entry.Category,
+			Value:      command.UpdateData.Value,
+			Earning:    entry.Earning,
+			Expression: command.UpdateData.Expression,
+			Refund:     refund,
+			Currency:   command.UpdateData.Currency,
+			Note:       command.UpdateData.Note,
+		},
+	})
+
+	return nil
+}
+
+func (r *DataHandler) handleUpdateCategoryChosen(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	r.MessagingService.RemoveMarkupFromMessage(message, command.ChatId, command.MessageId)
+	command.Month = monthFromDialog(command, dialog)
+
+	// the amount may have been given up front e.g. "u 12.50" or with an ambiguous category
+	if dialog != nil && dialog.Step == model.DIALOG_STEP_CHOOSING_CATEGORY && isUpdate(dialog.Command.Type) && dialog.Command.UpdateData != nil && dialog.Command.UpdateData.Value != nil {
+		if !r.addValue(message, model.MergeUpdateCommandWithFinancial(command, dialog.Command)) {
+			return dialog
+		}
+		return nil
+	}
+
+	return r.askForAmount(message, command)
+}
+
+func (r *DataHandler) handleAmount(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	if dialog == nil || dialog.Step != model.DIALOG_STEP_AWAITING_AMOUNT {
+		r.MessagingService.SendTextMessage(message, command.ChatId, "Not sure what to do with that boyo. Type HELP.")
+		return dialog
+	}
+
+	// merge commands, if it fails the user can send the amount again
+	if !r.addValue(message, model.MergeUpdateCommandWithFinancial(dialog.Command, command)) {
+		return dialog
+	}
+
+	return nil
+}
+
+func (r *DataHandler) handleRead(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	entries, ok := r.listEntries(message, command.ChatId, command.Month)
+	if !ok {
+		return nil
+	}
+	entry, ok := r.resolveCategory(message, command, entries, CALLBACK_PREFIX_READ)
+	if !ok {
+		return chooseCategory(command)
+	}
+	r.readValue(message, command.ChatId, entry.Category, entry.Earning, command.Month)
+
+	return nil
+}
+
+func (r *DataHandler) handleReadCategoryChosen(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	r.MessagingService.RemoveMarkupFromMessage(message, command.ChatId, command.MessageId)
+	r.readValue(message, command.ChatId, command.ReadData.Category, command.ReadData.Earning, monthFromDialog(command, dialog))
+
+	return nil
+}
+
+func (r *DataHandler) handleDetails(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	entries, ok := r.listEntries(message, command.ChatId, command.Month)
+	if !ok {
+		return nil
+	}
+	entry, ok := r.resolveCategory(message, command, entries, CALLBACK_PREFIX_DETAILS)
+	if !ok {
+		return chooseCategory(command)
+	}
+	r.readDetails(message, command.ChatId, entry.Category, entry.Earning, command.Month)
+
+	return nil
+}
+
+func (r *DataHandler) handleDetailsCategoryChosen(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	r.MessagingService.RemoveMarkupFromMessage(message, command.ChatId, command.MessageId)
+	r.readDetails(message, command.ChatId, command.DetailsData.Category, command.DetailsData.Earning, monthFromDialog(command, dialog))
+
+	return nil
+}
+
+func (r *DataHandler) handleRemove(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	entries, ok := r.listEntries(message, command.ChatId, command.Month)
+	if !ok {
+		return nil
+	}
+	entry, ok := r.resolveCategory(message, command, entries, CALLBACK_PREFIX_REMOVE)
+	if !ok {
+		return chooseCategory(command)
+	}
+	r.removeLastValue(message, command.ChatId, command.UserId, entry.Category, entry.Earning, command.Month)
+
+	return nil
+}
+
+func (r *DataHandler) handleRemoveCategoryChosen(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	r.MessagingService.RemoveMarkupFromMessage(message, command.ChatId, command.MessageId)
+	r.removeLastValue(message, command.ChatId, command.UserId, command.RemoveData.Category, command.RemoveData.Earning, monthFromDialog(command, dialog))
+
+	return nil
+}
+
+func (r *DataHandler) handleUndo(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	count, ok := r.parseCount(message, command, 1)
+	if !ok {
+		return nil
+	}
+
+	source := r.getSpreadsheetSource(message.UserName)
+	mutations, err := r.StorageService.GetMutations(source.GetKey(), count)
+	if err != nil {
+		r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
+		return nil
+	}
+	if len(mutations) == 0 {
+		r.MessagingService.SendTextMessage(message, command.ChatId, "Nothing to undo.")
+		return nil
+	}
+
+	var undone []model.Mutation
+	var removeErr error
+	err = r.updateSpreadsheetThen(source, func(sheet io.Reader) (io.Reader, error) {
+		// read again under the lock in case someone else just undid them
+		mutations, err := r.StorageService.GetMutations(source.GetKey(), count)
+		if err != nil {
+			return nil, err
+		}
+		undone = mutations
+
+		changes := make([]model.CellChange, len(mutations))
+		for i, m := range mutations {
+			changes[i] = m.Change
+		}
+		return r.SpreadsheetService.RestoreCells(source, sheet, changes)
+	}, func() error {
+		// the exact ones undone, anything added since stays in the history
+		removeErr = r.StorageService.RemoveMutations(source.GetKey(), undone)
+		return nil
+	})
+	if err != nil {
+		if sheetErr, ok := err.(*errors.SpreadsheetError); ok && sheetErr.Type == errors.SPREADSHEET_ERROR_TYPE_CHANGED {
+			category := sheetErr.Cell
+			for _, m := range undone {
+				if fmt.Sprintf("%s!%s", m.Change.Sheet, m.Change.Cell) == sheetErr.Cell {
+					category = m.Category
+					break
+				}
+			}
+			r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("%s has been changed since so it can't be undone, nothing was changed.", category))
+			return nil
+		}
+		r.sendUpdateError(message, command.ChatId, err)
+		return nil
+	}
+
+	if removeErr != nil {
+		r.MessagingService.SendTextMessage(message, command.ChatId, "The changes were undone but are still in the HISTORY, please don't UNDO them again.")
+		return nil
+	}
+
+	if len(undone) == 1 {
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Undone: %s.", undone[0].Description))
+		return nil
+	}
+
+	lines := make([]string, len(undone))
+	for i, m := range undone {
+		lines[i] = m.Description
+	}
+	r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Undone %d changes:\n%s", len(undone), strings.Join(lines, "\n")))
+
+	return nil
+}
+
+func (r *DataHandler) handleHistory(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	count, ok := r.parseCount(message, command, DEFAULT_HISTORY_COUNT)
+	if !ok {
+		return nil
+	}
+
+	source := r.getSpreadsheetSource(message.UserName)
+	mutations, err := r.StorageService.GetMutations(source.GetKey(), count)
+	if err != nil {
+		r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
+		return nil
+	}
+	if len(mutations) == 0 {
+		r.MessagingService.SendTextMessage(message, command.ChatId, "No changes yet.")
+		return nil
+	}
+
+	lines := make([]string, len(mutations))
+	for i, m := range mutations {
+		lines[i] = fmt.Sprintf("%s %s - %s", m.Timestamp.Format(HISTORY_TIME_FORMAT), m.UserName, m.Description)
+	}
+	r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Latest changes, newest first:\n%s", strings.Join(lines, "\n")))
+
+	return nil
+}
+
+func (r *DataHandler) handleNewMonth(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	r.MessagingService.SendTextMessage(message, command.ChatId, "Creating a new month... Hang tight...")
+	source := r.getSpreadsheetSource(message.UserName)
+	var name *string
+	var createErr error
+	err := r.updateSpreadsheet(source, func(sheet io.Reader) (io.Reader, error) {
+		updated, n, err := r.SpreadsheetService.CreateMonthSheet(source, sheet, time.Now())
+		name = n
+		createErr = err
+		return updated, err
+	})
+	if createErr != nil {
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Could not create the new month: %s", createErr.Error()))
+		return nil
+	}
+	if err != nil {
+		r.sendUpdateError(message, command.ChatId, err)
+		return nil
+	}
+	// done!
+	r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Created %s from the last tab with values reset.", *name))
+
+	return nil
+}
+
+func (r *DataHandler) handleAddCategory(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	category := strings.TrimSpace(command.CategoryQuery)
+	if len(category) == 0 {
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Send the name too e.g. %s PETS", commandName(command.Type)))
+		return nil
+	}
+
+	earning := command.Type == model.COMMAND_TYPE_ADD_EARNING_CATEGORY
+	source := r.getSpreadsheetSource(message.UserName)
+	var addErr error
+	err := r.updateSpreadsheet(source, func(sheet io.Reader) (io.Reader, error) {
+		updated, err := r.SpreadsheetService.AddCategory(source, sheet, category, earning)
+		addErr = err
+		return updated, err
+	})
+	if addErr != nil {
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Could not add %s: %s", category, addErr.Error()))
+		return nil
+	}
+	if err != nil {
+		r.sendUpdateError(message, command.ChatId, err)
+		return nil
+	}
+
+	if earning {
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Added %s to the earnings.", category))
+	} else {
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Added %s to the costs.", category))
+	}
+
+	return nil
+}
+
+func (r *DataHandler) handleRenameCategory(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	if command.CategoryData == nil || len(command.CategoryData.NewName) == 0 {
+		r.MessagingService.SendTextMessage(message, command.ChatId, "Send the old and new names e.g. RENAME CATEGORY GROCERIES TO FOOD")
+		return nil
+	}
+
+	entries, ok := r.listEntries(message, command.ChatId, nil)
+	if !ok {
+		return nil
+	}
+	entry, ok := r.resolveCategory(message, command, entries, CALLBACK_PREFIX_RENAME)
+	if !ok {
+		// the new name is picked up once a category is chosen
+		return chooseCategory(command)
+	}
+	r.renameCategory(message, command.ChatId, entry.Category, command.CategoryData.NewName, entry.Earning)
+
+	return nil
+}
+
+func (r *DataHandler) handleRenameCategoryChosen(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	r.MessagingService.RemoveMarkupFromMessage(message, command.ChatId, command.MessageId)
+
+	if dialog == nil || dialog.Command.Type != model.COMMAND_TYPE_RENAME_CATEGORY || dialog.Command.CategoryData == nil {
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Not sure what to rename %s to, send RENAME CATEGORY %s TO the new name.", command.CategoryData.Category, command.CategoryData.Category))
+		return nil
+	}
+	r.renameCategory(message, command.ChatId, command.CategoryData.Category, dialog.Command.CategoryData.NewName, command.CategoryData.Earning)
+
+	return nil
+}
+
+func (r *DataHandler) handleDeleteCategory(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	entries, ok := r.listEntries(message, command.ChatId, nil)
+	if !ok {
+		return nil
+	}
+	entry, ok := r.resolveCategory(message, command, entries, CALLBACK_PREFIX_DELETE)
+	if !ok {
+		return chooseCategory(command)
+	}
+
+	return r.deleteCategory(message, command, entry.Category, entry.Earning, false)
+}
+
+func (r *DataHandler) handleDeleteCategoryChosen(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	r.MessagingService.RemoveMarkupFromMessage(message, command.ChatId, command.MessageId)
+
+	return r.deleteCategory(message, command, command.CategoryData.Category, command.CategoryData.Earning, false)
+}
+
+func (r *DataHandler) handleConfirm(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	if dialog == nil || dialog.Step != model.DIALOG_STEP_AWAITING_CONFIRMATION {
+		r.MessagingService.SendTextMessage(message, command.ChatId, "Nothing to confirm.")
+		return dialog
+	}
+
+	switch dialog.Command.Type {
+	case model.COMMAND_TYPE_DELETE_CATEGORY_CHOSEN:
+		return r.deleteCategory(message, command, dialog.Command.CategoryData.Category, dialog.Command.CategoryData.Earning, true)
+	default:
+		zap.L().DPanic("Nothing to confirm for command", zap.Uint8("type", dialog.Command.Type))
+		return nil
+	}
+}
+
+func (r *DataHandler) handleCancel(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	if dialog == nil {
+		r.MessagingService.SendTextMessage(message, command.ChatId, "Nothing to cancel.")
+		return nil
+	}
+
+	r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Cancelled your %s.", dialog.Describe()))
+
+	return nil
+}
+
+func (r *DataHandler) handleHelp(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+	helpText := fmt.Sprintf(`The following commands are available with this epic finance bot.
+%s
+Amounts can be negative, sums or in another currency e.g. -5, 12.50+3.20, 45/3, $25 or 25 EUR.`, model.HelpText(message.GetRole()))
+	r.MessagingService.SendTextMessage(message, command.ChatId, helpText)
+
+	return nil
+}
+
+func (r *DataHandler) replyWith(texts ...string) model.CommandHandler {
+	return func(message *model.Message, command *model.Command, dialog *model.Dialog) *model.Dialog {
+		for _, text := range texts {
+			r.MessagingService.SendTextMessage(message, command.ChatId, text)
+		}
+		return nil
+	}
+}
+
+// The number after a command e.g. UNDO 3, the fallback when there isn't one
+func (r *DataHandler) parseCount(message *model.Message, command *model.Command, fallback int) (int, bool) {
+	if len(command.CategoryQuery) == 0 {
+		return fallback, true
+	}
+
+	count, err := strconv.Atoi(command.CategoryQuery)
+	if err != nil || count < 1 {
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("%s isn't a number of changes e.g. %s 3", command.CategoryQuery, commandName(command.Type)))
+		return 0, false
+	}
+
+	return min(count, services.MAX_HISTORY), true
+}
+
+func (r *DataHandler) renameCategory(message *model.Message, chatId int64, category string, newName string, earning bool) {
+	source := r.getSpreadsheetSource(message.UserName)
+	var renameErr error
+	err := r.updateSpreadsheet(source, func(sheet io.Reader) (io.Reader, error) {
+		updated, err := r.SpreadsheetService.RenameCategory(source, sheet, category, newName, earning)
+		renameErr = err
+		return updated, err
+	})
+	if renameErr != nil {
+		r.MessagingService.SendTextMessage(message, chatId, fmt.Sprintf("Could not rename %s: %s", category, renameErr.Error()))
+		return
+	}
+	if err != nil {
+		r.sendUpdateError(message, chatId, err)
+		return
+	}
+
+	r.MessagingService.SendTextMessage(message, chatId, fmt.Sprintf("Renamed %s to %s.", category, newName))
+}
+
+// Deletes the category, if it has amounts in it the user is asked to confirm first
+func (r *DataHandler) deleteCategory(message *model.Message, command *model.Command, category string, earning bool, force bool) *model.Dialog {
+	source := r.getSpreadsheetSource(message.UserName)
+	var deleteErr error
+	err := r.updateSpreadsheet(source, func(sheet io.Reader) (io.Reader, error) {
+		updated, err := r.SpreadsheetService.DeleteCategory(source, sheet, category, earning, force)
+		deleteErr = err
+		return updated, err
+	})
+	if sheetErr, ok := deleteErr.(*errors.SpreadsheetError); ok && sheetErr.Type == errors.SPREADSHEET_ERROR_TYPE_NOT_EMPTY {
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("%s still has amounts in it. Send YES to delete it anyway or CANCEL to keep it.", category))
+		return &model.Dialog{
+			Step:     model.DIALOG_STEP_AWAITING_CONFIRMATION,
+			Name:     commandName(model.COMMAND_TYPE_DELETE_CATEGORY),
+			Category: category,
+			Command: &model.Command{
+				Type:      model.COMMAND_TYPE_DELETE_CATEGORY_CHOSEN,
+				ChatId:    command.ChatId,
+				MessageId: command.MessageId,
+				UserId:    command.UserId,
+				CategoryData: &model.CategoryData{
+					Category: category,
+					Earning:  earning,
+				},
+			},
+		}
+	}
+	if deleteErr != nil {
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Could not delete %s: %s", category, deleteErr.Error()))
+		return nil
+	}
+	if err != nil {
+		r.sendUpdateError(message, command.ChatId, err)
+		return nil
+	}
+
+	r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Deleted %s.", category))
+
+	return nil
+}
+
+// Splits e.g. "groceries to food" into the category and its new name, the new name is empty without a TO
+func splitRename(query string) (string, string) {
+	index := strings.LastIndex(strings.ToLower(query), " to ")
+	if index == -1 {
+		return query, ""
+	}
+
+	return strings.TrimSpace(query[:index]), strings.TrimSpace(query[index+len(" to "):])
+}
+
+// Waits for a category to be picked from the keyboard that was just sent
+func chooseCategory(command *model.Command) *model.Dialog {
+	return &model.Dialog{
+		Step:    model.DIALOG_STEP_CHOOSING_CATEGORY,
+		Name:    commandName(command.Type),
+		Command: command,
+	}
+}
+
+// Keyboard choices don't carry the month so it comes from the command that sent the keyboard
+func monthFromDialog(command *model.Command, dialog *model.Dialog) *time.Time {
+	if dialog != nil && dialog.Step == model.DIALOG_STEP_CHOOSING_CATEGORY && dialog.Command != nil {
+		return dialog.Command.Month
+	}
+	return command.Month
+}
+
+// The name the user knows the command by e.g. UPDATE
+func commandName(commandType byte) string {
+	definition := model.GetCommandDefinition(commandType)
+	if definition == nil || len(definition.Keywords) == 0 {
+		return ""
+	}
+	return strings.ToUpper(definition.Keywords[0])
+}
+
+func isUpdate(commandType byte) bool {
+	return commandType == model.COMMAND_TYPE_UPDATE || commandType == model.COMMAND_TYPE_EARN || commandType == model.COMMAND_TYPE_REFUND
+}
@@ -2,18 +2,29 @@ package handlers
 
 import (
 	"fmt"
+	"io"
+	"math"
+	"strings"
 	"telegram-spreadsheet-editor/errors"
 	"telegram-spreadsheet-editor/model"
 	"telegram-spreadsheet-editor/services"
+	"telegram-spreadsheet-editor/utils"
+	"time"
 
 	"go.uber.org/zap"
 )
 
+const (
+	MAX_WRITE_ATTEMPTS int = 3
+)
+
 type DataHandler struct {
 	DataService        services.IDataService
 	SpreadsheetService services.ISpreadsheetService
 	MessagingService   services.IMessagingService
 	StorageService     services.IStorageService
+	LockService        services.ILockService
+	RateService        services.IRateService
 }
 
 func (r *DataHandler) HandleMessage(message *model.Message) {
@@ -26,6 +37,9 @@ func (r *DataHandler) HandleMessage(message *model.Message) {
 		switch err := err.(type) {
 		case *errors.CommandError:
 			// check if the user is not wanted
+			if err.Silent {
+				return
+			}
 			if err.Unauthorized {
 				r.MessagingService.SendTextMessage(message, err.ChatId, "Go away you prune head!")
 				return
@@ -40,220 +54,435 @@ func (r *DataHandler) HandleMessage(message *model.Message) {
 
 	zap.L().Info("Handling message", zap.Uint8("type", command.Type))
 
-	switch command.Type {
-	case model.COMMAND_TYPE_PING:
-		r.MessagingService.SendTextMessage(message, command.ChatId, "Pong")
-	case model.COMMAND_TYPE_LIST:
-		r.MessagingService.SendTextMessage(message, command.ChatId, "Listing... Hang tight...")
-		source := r.getSpreadsheetSource(message.UserName)
-		sheet, err := r.DataService.GetSpreadsheet(source)
-		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
-		}
-		entries, err := r.SpreadsheetService.ListCategoriesAndValues(source, sheet)
-		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
+	definition := model.GetCommandDefinition(command.Type)
+	if definition == nil || definition.Handler == nil {
+		zap.L().DPanic("No handler for command", zap.Uint8("type", command.Type))
+		r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
+		return
+	}
+
+	// checked for every step as keyboard choices and amounts are commands too
+	if role := message.GetRole(); !model.RoleAllows(role, definition.Role) {
+		zap.L().Info("Command not allowed for role", zap.Uint8("type", command.Type), zap.String("role", role))
+		r.MessagingService.SendTextMessage(message, command.ChatId, roleDenial(message.GetAuthorName(), definition))
+		return
+	}
+
+	// a stray reply to a dialog that has timed out must not be applied e.g. a number sent days after an UPDATE
+	dialog, err := r.StorageService.GetDialog(command.ChatId, command.UserId)
+	if err != nil {
+		dialog = nil
+	}
+	stored := dialog != nil
+	if dialog != nil && dialog.Expired(time.Now()) {
+		if dialog.Continues(command.Type) {
+			r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Your %s expired so nothing was changed. Send %s to start again.", dialog.Describe(), dialog.Name))
+			r.StorageService.ClearDialog(command.ChatId, command.UserId)
 			return
 		}
-		r.MessagingService.SendEntryList(message, command.ChatId, entries)
-	case model.COMMAND_TYPE_UPDATE:
-		source := r.getSpreadsheetSource(message.UserName)
-		sheet, err := r.DataService.GetSpreadsheet(source)
+		dialog = nil
+	}
+
+	next := definition.Handler(message, command, dialog)
+
+	// keep the dialog for the user's next message (THIS MUST GO LAST)
+	switch {
+	case next == nil && stored:
+		r.StorageService.ClearDialog(command.ChatId, command.UserId)
+	case next != nil && next != dialog:
+		next.ChatId = command.ChatId
+		next.UserId = command.UserId
+		next.ExpiresAt = time.Now().Add(model.GetConfig().GetDialogTimeout())
+		r.StorageService.StoreDialog(next)
+	}
+}
+
+// private
+
+// Downloads the sheet, applies the change and uploads it. If the sheet was changed by someone else in the meantime
+// the change is re-applied to the latest version, giving up after MAX_WRITE_ATTEMPTS.
+func (r *DataHandler) updateSpreadsheet(source model.SpreadsheetSource, apply func(sheet io.Reader) (io.Reader, error)) error {
+	return r.updateSpreadsheetThen(source, apply, nil)
+}
+
+// As updateSpreadsheet, written runs once the sheet is uploaded while the sheet is still locked so the history is
+// changed in the same order as the sheet
+func (r *DataHandler) updateSpreadsheetThen(source model.SpreadsheetSource, apply func(sheet io.Reader) (io.Reader, error), written func() error) error {
+	// only one update per sheet at a time, the etag retry covers edits from outside the bot
+	unlock, err := r.LockService.Lock(source.GetKey())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for attempt := 1; attempt <= MAX_WRITE_ATTEMPTS; attempt++ {
+		sheet, version, err := r.DataService.GetSpreadsheet(source)
 		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
+			return err
 		}
-		entries, err := r.SpreadsheetService.ListCategoriesAndValues(source, sheet)
+
+		updated, err := apply(sheet)
 		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
+			return err
 		}
-		r.MessagingService.SendCategorySelectionKeyboard(message, command.ChatId, entries, "UPDATE")
-	case model.COMMAND_TYPE_UPDATE_CATEGORY_CHOSEN:
-		r.MessagingService.RemoveMarkupFromMessage(message, command.ChatId, command.MessageId)
-		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("How much to we add to %s?", *command.UpdateData.Category))
-	case model.COMMAND_TYPE_NUMERICAL_AMOUNT:
-		// need to fetch the previous command
-		prevCommand, err := r.StorageService.GetPreviousCommand(command.UserId)
-		if err != nil {
-			switch err := err.(type) {
-			case *errors.StorageError:
-				if err.Type == errors.STORAGE_ERROR_TYPE_NOT_FOUND {
-					r.MessagingService.SendTextMessage(message, command.ChatId, "Not sure what to do with that boyo. Type HELP.")
-					return
-				}
-			default:
-				r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-				return
+
+		err = r.DataService.WriteSpreadsheet(source, updated, version)
+		if err == nil {
+			if written == nil {
+				return nil
 			}
+			return written()
 		}
 
-		if prevCommand.Type != model.COMMAND_TYPE_UPDATE_CATEGORY_CHOSEN {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Not sure what to do with that boyo. Type HELP.")
-			return
+		if dataErr, ok := err.(*errors.DataError); !ok || dataErr.Type != errors.DATA_ERROR_TYPE_CONFLICT {
+			return err
 		}
 
-		// merge commands
-		fullCommand := model.MergeUpdateCommandWithFinancial(prevCommand, command)
+		zap.L().Warn("Spreadsheet changed while updating, retrying", zap.Int("attempt", attempt))
+	}
 
-		// ui feedback
-		r.MessagingService.SendTextMessage(message, command.ChatId, "On it, hang tight...")
+	return &errors.DataError{
+		Type: errors.DATA_ERROR_TYPE_CONFLICT,
+	}
+}
 
-		// get sheet
-		source := r.getSpreadsheetSource(message.UserName)
-		sheet, err := r.DataService.GetSpreadsheet(source)
-		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
-		}
+func (r *DataHandler) sendUpdateError(message *model.Message, chatId int64, err error) {
+	if dataErr, ok := err.(*errors.DataError); ok && dataErr.Type == errors.DATA_ERROR_TYPE_CONFLICT {
+		r.MessagingService.SendTextMessage(message, chatId, "Someone else is editing the spreadsheet right now, nothing was changed. Please try again.")
+		return
+	}
 
-		// update sheet
-		updated, newVal, err := r.SpreadsheetService.AddValueForCategory(source, sheet, *fullCommand.UpdateData.Category, *fullCommand.UpdateData.Value)
-		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
-		}
+	r.sendReadError(message, chatId, err)
+}
 
-		// save sheet
-		if err := r.DataService.WriteSpreadsheet(source, updated); err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
-		}
+// Says which tab is missing when there isn't one for the month asked for
+func (r *DataHandler) sendReadError(message *model.Message, chatId int64, err error) {
+	if sheetErr, ok := err.(*errors.SpreadsheetError); ok && sheetErr.Type == errors.SPREADSHEET_ERROR_TYPE_NO_SHEET {
+		r.MessagingService.SendTextMessage(message, chatId, fmt.Sprintf("There is no sheet named %s.", sheetErr.Cell))
+		return
+	}
 
-		// done!
-		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Added £%.2f to %s. New total: %s", *fullCommand.UpdateData.Value, *fullCommand.UpdateData.Category, *newVal))
-	case model.COMMAND_TYPE_READ:
-		source := r.getSpreadsheetSource(message.UserName)
-		sheet, err := r.DataService.GetSpreadsheet(source)
-		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
-		}
-		entries, err := r.SpreadsheetService.ListCategoriesAndValues(source, sheet)
-		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
-		}
-		r.MessagingService.SendCategorySelectionKeyboard(message, command.ChatId, entries, "READ")
-	case model.COMMAND_TYPE_READ_CATEGORY_CHOSEN:
-		r.MessagingService.RemoveMarkupFromMessage(message, command.ChatId, command.MessageId)
-		r.MessagingService.SendTextMessage(message, command.ChatId, "On it, hang tight home slice...")
+	r.MessagingService.SendTextMessage(message, chatId, "Something went wrong...")
+}
 
-		source := r.getSpreadsheetSource(message.UserName)
-		sheet, err := r.DataService.GetSpreadsheet(source)
-		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
-		}
-		val, err := r.SpreadsheetService.ReadValueForCategory(source, sheet, command.ReadData.Category, false)
-		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
-		}
-		// done!
-		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Current total for %s: %s", command.ReadData.Category, *val))
-	case model.COMMAND_TYPE_DETAILS:
-		source := r.getSpreadsheetSource(message.UserName)
-		sheet, err := r.DataService.GetSpreadsheet(source)
-		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
-		}
-		entries, err := r.SpreadsheetService.ListCategoriesAndValues(source, sheet)
-		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
+// The categories without the grand totals
+func (r *DataHandler) listEntries(message *model.Message, chatId int64, month *time.Time) (*[]model.Entry, bool) {
+	entries, ok := r.listEntriesWithTotals(message, chatId, month)
+	if !ok {
+		return nil, false
+	}
+
+	categories := []model.Entry{}
+	for _, e := range *entries {
+		if !e.Total {
+			categories = append(categories, e)
 		}
-		r.MessagingService.SendCategorySelectionKeyboard(message, command.ChatId, entries, "DETAILS")
-	case model.COMMAND_TYPE_DETAILS_CATEGORY_CHOSEN:
-		r.MessagingService.RemoveMarkupFromMessage(message, command.ChatId, command.MessageId)
+	}
 
-		r.MessagingService.SendTextMessage(message, command.ChatId, "On it, hang tight home slice...")
+	return &categories, true
+}
 
-		source := r.getSpreadsheetSource(message.UserName)
-		sheet, err := r.DataService.GetSpreadsheet(source)
-		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
-		}
-		val, err := r.SpreadsheetService.ReadValueForCategory(source, sheet, command.DetailsData.Category, true)
-		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
-		}
-		// done!
-		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Details for for %s: %s", command.DetailsData.Category, *val))
-	case model.COMMAND_TYPE_REMOVE:
-		source := r.getSpreadsheetSource(message.UserName)
-		sheet, err := r.DataService.GetSpreadsheet(source)
+func (r *DataHandler) listEntriesWithTotals(message *model.Message, chatId int64, month *time.Time) (*[]model.Entry, bool) {
+	source := r.getSpreadsheetSource(message.UserName)
+	sheet, _, err := r.DataService.GetSpreadsheet(source)
+	if err != nil {
+		r.MessagingService.SendTextMessage(message, chatId, "Something went wrong...")
+		return nil, false
+	}
+	entries, err := r.SpreadsheetService.ListCategoriesAndValues(source, sheet, month)
+	if err != nil {
+		r.sendReadError(message, chatId, err)
+		return nil, false
+	}
+
+	return entries, true
+}
+
+// Matches a typed category to one of the entries. When there is no typed category or it is ambiguous a keyboard
+// is sent instead and false is returned.
+func (r *DataHandler) resolveCategory(message *model.Message, command *model.Command, entries *[]model.Entry, callbackCommand string) (*model.Entry, bool) {
+	if len(command.CategoryQuery) == 0 {
+		r.MessagingService.SendCategorySelectionKeyboard(message, command.ChatId, entries, callbackCommand)
+		return nil, false
+	}
+
+	categories := make([]string, len(*entries))
+	for i, e := range *entries {
+		categories[i] = e.Category
+	}
+
+	matches := utils.MatchCategories(command.CategoryQuery, categories)
+	if len(matches) == 1 {
+		return &(*entries)[matches[0]], true
+	}
+
+	if len(matches) == 0 {
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Couldn't find a category like %s.", command.CategoryQuery))
+		r.MessagingService.SendCategorySelectionKeyboard(message, command.ChatId, entries, callbackCommand)
+		return nil, false
+	}
+
+	candidates := make([]model.Entry, len(matches))
+	for i, m := range matches {
+		candidates[i] = (*entries)[m]
+	}
+	r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("%s matches more than one category.", command.CategoryQuery))
+	r.MessagingService.SendCategorySelectionKeyboard(message, command.ChatId, &candidates, callbackCommand)
+
+	return nil, false
+}
+
+// Asks for the amount once the category is known and waits for it
+func (r *DataHandler) askForAmount(message *model.Message, command *model.Command) *model.Dialog {
+	name := commandName(model.COMMAND_TYPE_UPDATE)
+	if command.UpdateData.Refund {
+		name = commandName(model.COMMAND_TYPE_REFUND)
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("How much was refunded to %s?", *command.UpdateData.Category))
+	} else if command.UpdateData.Earning {
+		name = commandName(model.COMMAND_TYPE_EARN)
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("How much did we earn from %s?", *command.UpdateData.Category))
+	} else {
+		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("How much to we add to %s?", *command.UpdateData.Category))
+	}
+
+	return &model.Dialog{
+		Step:     model.DIALOG_STEP_AWAITING_AMOUNT,
+		Name:     name,
+		Category: *command.UpdateData.Category,
+		Command:  command,
+	}
+}
+
+func (r *DataHandler) addValue(message *model.Message, command *model.Command) bool {
+	// ui feedback
+	r.MessagingService.SendTextMessage(message, command.ChatId, "On it, hang tight...")
+
+	// refunds always take away
+	value := *command.UpdateData.Value
+	if command.UpdateData.Refund && value > 0 {
+		value = -value
+	}
+
+	source := r.getSpreadsheetSource(message.UserName)
+
+	// convert other currencies, the sum as typed is in the other currency so it only goes in the comment
+	expression := command.UpdateData.Expression
+	comment := ""
+	converted := ""
+	if currency := command.UpdateData.Currency; len(currency) > 0 && currency != source.GetCurrency() {
+		rate, err := r.RateService.GetRate(currency, source.GetCurrency())
 		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
+			r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Couldn't get the exchange rate for %s, nothing was changed.", currency))
+			return false
 		}
-		entries, err := r.SpreadsheetService.ListCategoriesAndValues(source, sheet)
-		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
+
+		original := utils.FormatAmount(float64(value), currency, source.GetLocale())
+		if len(expression) > 0 {
+			original = fmt.Sprintf("%s (%s)", original, expression)
 		}
-		r.MessagingService.SendCategorySelectionKeyboard(message, command.ChatId, entries, "REMOVE")
-	case model.COMMAND_TYPE_REMOVE_CATEGORY_CHOSEN:
-		r.MessagingService.RemoveMarkupFromMessage(message, command.ChatId, command.MessageId)
-
-		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Removing last added value from %s", command.RemoveData.Category))
-		// get sheet
-		source := r.getSpreadsheetSource(message.UserName)
-		sheet, err := r.DataService.GetSpreadsheet(source)
+
+		value = float32(math.Round(float64(value)*rate*100) / 100)
+		comment = fmt.Sprintf("%s was %s", utils.FormatAmount(float64(value), source.GetCurrency(), source.GetLocale()), original)
+		converted = fmt.Sprintf(" from %s", original)
+		expression = ""
+	}
+
+	added := utils.FormatAmount(math.Abs(float64(value)), source.GetCurrency(), source.GetLocale())
+	if len(expression) > 0 {
+		added = fmt.Sprintf("%s (%s)", added, expression)
+	}
+	added += converted
+
+	var description string
+	switch {
+	case command.UpdateData.Refund:
+		description = fmt.Sprintf("Refunded %s on %s", added, *command.UpdateData.Category)
+	case value < 0:
+		description = fmt.Sprintf("Took %s off %s", added, *command.UpdateData.Category)
+	case command.UpdateData.Earning:
+		description = fmt.Sprintf("Added %s to earnings for %s", added, *command.UpdateData.Category)
+	default:
+		description = fmt.Sprintf("Added %s to %s", added, *command.UpdateData.Category)
+	}
+	description += inMonth(source, command.Month)
+	if len(command.UpdateData.Note) > 0 {
+		description = fmt.Sprintf("%s - %s", description, command.UpdateData.Note)
+	}
+
+	// update sheet
+	var res *services.AddedResult
+	err := r.updateSpreadsheetThen(source, func(sheet io.Reader) (io.Reader, error) {
+		added, err := r.SpreadsheetService.AddValueForCategory(source, sheet, command.Month, *command.UpdateData.Category, services.AddValue{
+			Value:      value,
+			Expression: expression,
+			Comment:    comment,
+			Note:       command.UpdateData.Note,
+			Earning:    command.UpdateData.Earning,
+			User:       message.GetAuthorName(),
+		})
 		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
+			return nil, err
 		}
-		// remove last value
-		res, err := r.SpreadsheetService.RemoveLastValueForCategory(source, sheet, command.RemoveData.Category)
+		res = added
+		return added.ModifiedSheet, nil
+	}, func() error {
+		r.recordMutation(message, command.UserId, source, *command.UpdateData.Category, command.UpdateData.Earning, description, res.Change)
+		return nil
+	})
+	if err != nil {
+		r.sendUpdateError(message, command.ChatId, err)
+		return false
+	}
+
+	// done!
+	reply := fmt.Sprintf("%s. New total: %s", description, res.NewValue)
+	if res.Budget != nil {
+		reply = fmt.Sprintf("%s\n%s", reply, budgetStatus(source, *command.UpdateData.Category, res.PreviousTotal, res.Total, *res.Budget))
+	}
+	r.MessagingService.SendTextMessage(message, command.ChatId, reply)
+
+	return true
+}
+
+func (r *DataHandler) readValue(message *model.Message, chatId int64, category string, earning bool, month *time.Time) {
+	r.MessagingService.SendTextMessage(message, chatId, "On it, hang tight home slice...")
+
+	source := r.getSpreadsheetSource(message.UserName)
+	sheet, _, err := r.DataService.GetSpreadsheet(source)
+	if err != nil {
+		r.MessagingService.SendTextMessage(message, chatId, "Something went wrong...")
+		return
+	}
+	val, err := r.SpreadsheetService.ReadValueForCategory(source, sheet, month, category, false, earning)
+	if err != nil {
+		r.sendReadError(message, chatId, err)
+		return
+	}
+	// done!
+	r.MessagingService.SendTextMessage(message, chatId, fmt.Sprintf("Current total for %s%s: %s", category, inMonth(source, month), *val))
+}
+
+func (r *DataHandler) readDetails(message *model.Message, chatId int64, category string, earning bool, month *time.Time) {
+	r.MessagingService.SendTextMessage(message, chatId, "On it, hang tight home slice...")
+
+	source := r.getSpreadsheetSource(message.UserName)
+	sheet, _, err := r.DataService.GetSpreadsheet(source)
+	if err != nil {
+		r.MessagingService.SendTextMessage(message, chatId, "Something went wrong...")
+		return
+	}
+	val, err := r.SpreadsheetService.ReadValueForCategory(source, sheet, month, category, true, earning)
+	if err != nil {
+		r.sendReadError(message, chatId, err)
+		return
+	}
+	// done!
+	r.MessagingService.SendTextMessage(message, chatId, fmt.Sprintf("Details for for %s%s: %s", category, inMonth(source, month), *val))
+}
+
+func (r *DataHandler) removeLastValue(message *model.Message, chatId int64, userId int64, category string, earning bool, month *time.Time) {
+	source := r.getSpreadsheetSource(message.UserName)
+
+	// e.g. Groceries in Mar 2026
+	label := category + inMonth(source, month)
+	r.MessagingService.SendTextMessage(message, chatId, fmt.Sprintf("Removing last added value from %s", label))
+
+	// remove last value and update sheet
+	var res *services.RemovedResult
+	err := r.updateSpreadsheetThen(source, func(sheet io.Reader) (io.Reader, error) {
+		removed, err := r.SpreadsheetService.RemoveLastValueForCategory(source, sheet, month, category, earning)
 		if err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
+			return nil, err
 		}
-		// update sheet
-		if err := r.DataService.WriteSpreadsheet(source, res.ModifiedSheet); err != nil {
-			r.MessagingService.SendTextMessage(message, command.ChatId, "Something went wrong...")
-			return
+		res = removed
+		return removed.ModifiedSheet, nil
+	}, func() error {
+		r.recordMutation(message, userId, source, category, earning, fmt.Sprintf("Removed %s from %s", res.RemovedValue, label), res.Change)
+		return nil
+	})
+	if err != nil {
+		r.sendUpdateError(message, chatId, err)
+		return
+	}
+	// done!
+	r.MessagingService.SendTextMessage(message, chatId, fmt.Sprintf("Removed %s from %s. Was %s and is now %s.", res.RemovedValue, label, res.OldValue, res.NewValue))
+}
+
+// e.g. Sorry Sam, you can only read the sheet so UPDATE isn't available.
+func roleDenial(name string, definition *model.CommandDefinition) string {
+	commandName := "that"
+	if len(definition.Keywords) > 0 {
+		commandName = strings.ToUpper(definition.Keywords[0])
+	}
+
+	if definition.Role == model.ROLE_ADMIN {
+		return fmt.Sprintf("Sorry %s, only admins can use %s.", name, commandName)
+	}
+	return fmt.Sprintf("Sorry %s, you can only read the sheet so %s isn't available.", name, commandName)
+}
+
+// Keeps a change so it can be undone, the change itself has been made so failing to keep it only gets logged. Called
+// under the sheet lock so the history is in the order the changes were made
+func (r *DataHandler) recordMutation(message *model.Message, userId int64, source model.SpreadsheetSource, category string, earning bool, description string, change model.CellChange) {
+	err := r.StorageService.AddMutation(source.GetKey(), &model.Mutation{
+		Id:          model.NewMutationId(),
+		UserId:      userId,
+		UserName:    message.GetAuthorName(),
+		Category:    category,
+		Earning:     earning,
+		Description: description,
+		Change:      change,
+		Timestamp:   time.Now(),
+	})
+	if err != nil {
+		zap.L().Warn("Change was made but can't be undone", zap.String("description", description), zap.Error(err))
+	}
+}
+
+// e.g. £20.00 left of the £300.00 budget, with a warning when the change went past one of the alerts
+func budgetStatus(source model.SpreadsheetSource, category string, previousTotal float64, total float64, budget float64) string {
+	remaining := budget - total
+	formattedBudget := utils.FormatAmount(budget, source.GetCurrency(), source.GetLocale())
+
+	status := fmt.Sprintf("%s left of the %s budget.", utils.FormatAmount(remaining, source.GetCurrency(), source.GetLocale()), formattedBudget)
+	if remaining < 0 {
+		status = fmt.Sprintf("%s over the %s budget.", utils.FormatAmount(-remaining, source.GetCurrency(), source.GetLocale()), formattedBudget)
+	}
+
+	alert := model.CrossedBudgetAlert(previousTotal, total, budget, source.GetBudgetAlerts())
+	switch {
+	case alert >= 100:
+		status = fmt.Sprintf("Warning: %s has gone over budget. %s", category, status)
+	case alert > 0:
+		status = fmt.Sprintf("Warning: %s has used %d%% of its budget. %s", category, alert, status)
+	}
+
+	return status
+}
+
+// e.g. " in Mar 2026" for a month typed with the command, empty otherwise
+// The month's tab as the source names it e.g. " in Mar 2026" or " in 2026-03"
+func inMonth(source model.SpreadsheetSource, month *time.Time) string {
+	if month == nil {
+		return ""
+	}
+	return fmt.Sprintf(" in %s", month.Format(source.GetSheetNameFormat()))
+}
+
+func filterEntries(entries *[]model.Entry, earning bool) *[]model.Entry {
+	filtered := []model.Entry{}
+	for _, e := range *entries {
+		if e.Earning == earning {
+			filtered = append(filtered, e)
 		}
-		// done!
-		r.MessagingService.SendTextMessage(message, command.ChatId, fmt.Sprintf("Removed %s from %s. Was %s and is now %s.", res.RemovedValue, command.RemoveData.Category, res.OldValue, res.NewValue))
-	case model.COMMAND_TYPE_HELP:
-		helpText := `The following commands are available with this epic finance bot.
-PING - Pong.
-LIST | L - Lists all categories and their totals along with the running total.
-UPDATE | U - Add a cost to a category.
-READ | R - Read the value of a category.
-DETAILS - Get all the costs of a category e.g. 2+4+6.
-REMOVE - Delete the last added amount in a category.
-HELP - Print this help list.`
-		r.MessagingService.SendTextMessage(message, command.ChatId, helpText)
-	case model.COMMAND_TYPE_DORIS:
-		r.MessagingService.SendTextMessage(message, command.ChatId, "\U0001F99B")
-	case model.COMMAND_TYPE_BOOBS:
-		r.MessagingService.SendTextMessage(message, command.ChatId, "They're great aren't they.")
-	case model.COMMAND_TYPE_ALICE:
-		r.MessagingService.SendTextMessage(message, command.ChatId, "Woooooo!")
-		r.MessagingService.SendTextMessage(message, command.ChatId, "\U0001F478")
-		r.MessagingService.SendTextMessage(message, command.ChatId, "\U00002728")
-		r.MessagingService.SendTextMessage(message, command.ChatId, "\u2764\ufe0f")
-		r.MessagingService.SendTextMessage(message, command.ChatId, "\U0001F478 \U00002728 \u2764\ufe0f")
-	}
-
-	// update command for user for next call (THIS MUST GO LAST)
-	r.StorageService.StoreCommand(command, command.UserId)
+	}
+
+	return &filtered
 }
 
-// private
 func (r *DataHandler) getSpreadsheetSource(userName string) model.SpreadsheetSource {
-	config := model.GetConfig()
-	for _, u := range config.Users {
-		if u.Name == userName {
-			return u.SpreadsheetSource
-		}
+	if user := model.GetConfig().GetUser(userName); user != nil {
+		return user.SpreadsheetSource
 	}
 
 	return nil
@@ -1,37 +1,91 @@
 package inputs
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"telegram-spreadsheet-editor/model"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"go.uber.org/zap"
 )
 
+const (
+	// updates waiting to be handled before the webhook starts turning them away
+	TELEGRAM_QUEUE_SIZE    int    = 100
+	TELEGRAM_SECRET_BYTES  int    = 32
+	TELEGRAM_SECRET_HEADER string = "X-Telegram-Bot-Api-Secret-Token"
+)
+
 type TelegramInput struct {
-	Bot      *tgbotapi.BotAPI
-	UserId   int64
+	Bot *tgbotapi.BotAPI
+	// who can use the bot by user id
+	Members  map[int64]model.TelegramMember
 	UserName string
+	// webhook mode only, nil when long polling
+	Server      *WebhookServer
+	Path        string
+	PublicUrl   string
+	SecretToken string
+
+	// guards the queue which is nil until the webhook is added and again once stopped
+	mu    sync.Mutex
+	queue chan *model.Message
 }
 
-func NewTelegramInput(input *model.TelegramInput, user string) (*TelegramInput, error) {
+// The server is only used in webhook mode, bots listening on the same address share one
+func NewTelegramInput(input *model.TelegramInput, user *model.User, server *WebhookServer) (*TelegramInput, error) {
 	token, exists := os.LookupEnv(input.TokenEnv)
 	if !exists {
 		zap.L().Panic("No telegram input token")
 	}
 
+	// checked before the bot is created so a config mistake doesn't wait on telegram
+	webhook := input.GetMode() == model.TELEGRAM_MODE_WEBHOOK
+	var secret string
+	if webhook {
+		if len(input.PublicUrl) == 0 {
+			zap.L().Error("Telegram webhook mode needs a public url", zap.String("user", user.Name))
+			return nil, fmt.Errorf("Telegram webhook mode needs a public url")
+		}
+
+		var err error
+		if secret, err = webhookSecret(input.SecretTokenEnv); err != nil {
+			return nil, err
+		}
+	}
+
 	bot, err := tgbotapi.NewBotAPI(token)
 	if err != nil {
 		zap.L().Error("Failed to init new bot API", zap.Error(err))
 		return nil, fmt.Errorf("Failed to init new bot API: %w", err)
 	}
 
-	return &TelegramInput{
+	telegramInput := &TelegramInput{
 		Bot:      bot,
-		UserId:   input.UserId,
-		UserName: user,
-	}, nil
+		Members:  input.GetMembers(user),
+		UserName: user.Name,
+	}
+
+	if !webhook {
+		return telegramInput, nil
+	}
+
+	telegramInput.Server = server
+	telegramInput.Path = input.Path
+	if len(telegramInput.Path) == 0 {
+		telegramInput.Path = model.DEFAULT_TELEGRAM_PATH_PREFIX + bot.Self.UserName
+	}
+	telegramInput.PublicUrl = strings.TrimSuffix(input.PublicUrl, "/")
+	telegramInput.SecretToken = secret
+
+	return telegramInput, nil
 }
 
 // public
@@ -41,6 +95,11 @@ func (i *TelegramInput) GetType() string {
 }
 
 func (i *TelegramInput) Start(handler func(*model.Message)) {
+	if i.Server != nil {
+		i.startWebhook(handler)
+		return
+	}
+
 	// check for webhooks
 	if err := i.checkForWebhooks(); err != nil {
 		return
@@ -60,25 +119,149 @@ func (i *TelegramInput) Start(handler func(*model.Message)) {
 		message := model.Message{
 			UserName: i.UserName,
 			TelegramMessage: &model.TelegramMessage{
-				Update: &update,
-				Bot:    i.Bot,
-				UserId: i.UserId,
+				Update:  &update,
+				Bot:     i.Bot,
+				Members: i.Members,
 			},
 		}
 		handler(&message)
 	}
 }
 
+// The webhook is left registered so Telegram keeps the updates sent while stopped
 func (i *TelegramInput) Stop() {
-	i.Bot.StopReceivingUpdates()
+	if i.Server == nil {
+		i.Bot.StopReceivingUpdates()
+		return
+	}
+
+	// the server is stopped first so nothing can be queued
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.queue != nil {
+		close(i.queue)
+		i.queue = nil
+	}
+}
+
+// The handler Telegram posts updates to. Updates are answered straight away and handled one at a time in the
+// order they arrived, the same as when long polling
+func (i *TelegramInput) Webhook(handler func(*model.Message)) http.Handler {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.queue = make(chan *model.Message, TELEGRAM_QUEUE_SIZE)
+	go func(queue chan *model.Message) {
+		for message := range queue {
+			handler(message)
+		}
+	}(i.queue)
+
+	return http.HandlerFunc(i.receive)
 }
 
 // private
 
+func (i *TelegramInput) startWebhook(handler func(*model.Message)) {
+	if err := i.Server.Handle(i.Path, i.Webhook(handler)); err != nil {
+		// error logs in Handle, stopping ends the queue's worker as nothing will be queued
+		i.Stop()
+		return
+	}
+
+	if err := i.setWebhook(); err != nil {
+		return
+	}
+
+	zap.L().Info("Starting telegram webhook input", zap.String("user", i.UserName), zap.String("path", i.Path))
+}
+
+// Registers the webhook, replacing any that is there. Telegram sends the secret back in a header with every update
+func (i *TelegramInput) setWebhook() error {
+	params := tgbotapi.Params{
+		"url":             i.PublicUrl + i.Path,
+		"secret_token":    i.SecretToken,
+		"allowed_updates": `["message","callback_query"]`,
+	}
+	if _, err := i.Bot.MakeRequest("setWebhook", params); err != nil {
+		zap.L().DPanic("Failed to set webhook - cannot proceed.", zap.Error(err), zap.String("user", i.UserName))
+		return fmt.Errorf("Failed to set webhook - cannot proceed: %w", err)
+	}
+
+	return nil
+}
+
+func (i *TelegramInput) receive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(TELEGRAM_SECRET_HEADER)), []byte(i.SecretToken)) != 1 {
+		zap.L().Warn("Telegram webhook secret is not valid", zap.String("path", r.URL.Path))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var update tgbotapi.Update
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		zap.L().Warn("Failed to parse telegram update", zap.Error(err))
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	if update.Message == nil && update.CallbackQuery == nil {
+		return
+	}
+
+	message := &model.Message{
+		UserName: i.UserName,
+		TelegramMessage: &model.TelegramMessage{
+			Update:  &update,
+			Bot:     i.Bot,
+			Members: i.Members,
+		},
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.queue == nil {
+		http.Error(w, "Stopped", http.StatusServiceUnavailable)
+		return
+	}
+
+	select {
+	case i.queue <- message:
+	default:
+		// telegram retries so nothing is lost
+		zap.L().Error("Telegram queue is full, dropping update", zap.String("user", i.UserName))
+		http.Error(w, "Busy", http.StatusServiceUnavailable)
+	}
+}
+
+// The secret from the environment or a random one, telegram allows letters, numbers, _ and -
+func webhookSecret(secretTokenEnv string) (string, error) {
+	if len(secretTokenEnv) > 0 {
+		secret, exists := os.LookupEnv(secretTokenEnv)
+		if !exists {
+			zap.L().Error("No telegram webhook secret token", zap.String("var", secretTokenEnv))
+			return "", fmt.Errorf("No telegram webhook secret token in %s", secretTokenEnv)
+		}
+		return secret, nil
+	}
+
+	b := make([]byte, TELEGRAM_SECRET_BYTES)
+	if _, err := rand.Read(b); err != nil {
+		zap.L().Error("Failed to generate webhook secret", zap.Error(err))
+		return "", fmt.Errorf("Failed to generate webhook secret")
+	}
+	return hex.EncodeToString(b), nil
+}
+
 func (i *TelegramInput) checkForWebhooks() error {
 	info, err := i.Bot.GetWebhookInfo()
 	if err != nil {
-		zap.L().Warn("Failed to check for existing webhooks.", zap.Error(err), zap.Int64("userId", i.UserId))
+		zap.L().Warn("Failed to check for existing webhooks.", zap.Error(err), zap.String("user", i.UserName))
 		return nil
 	}
 
@@ -90,7 +273,7 @@ func (i *TelegramInput) checkForWebhooks() error {
 		DropPendingUpdates: true,
 	}
 	if _, err := i.Bot.Request(cfg); err != nil {
-		zap.L().DPanic("Failed to delete webhook - cannot proceed.", zap.Error(err), zap.Int64("userId", i.UserId))
+		zap.L().DPanic("Failed to delete webhook - cannot proceed.", zap.Error(err), zap.String("user", i.UserName))
 		return fmt.Errorf("Failed to delete webhook - cannot proceed: %w", err)
 	}
 
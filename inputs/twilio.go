@@ -0,0 +1,174 @@
+package inputs
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/utils"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// messages waiting to be handled before the webhook starts turning them away
+	TWILIO_QUEUE_SIZE int = 100
+	// an empty TwiML reply, the real replies are sent with the REST API once the message is handled
+	TWILIO_EMPTY_RESPONSE string = `<?xml version="1.0" encoding="UTF-8"?><Response></Response>`
+)
+
+// The webhook Twilio posts WhatsApp and SMS messages to, shared by every user with a twilio input
+type TwilioInput struct {
+	Server    *WebhookServer
+	Path      string
+	PublicUrl string
+	AuthToken string
+	// user names by normalised number e.g. +447700900123
+	Users map[string]string
+
+	// guards the queue which is nil until the webhook is added and again once stopped
+	mu    sync.Mutex
+	queue chan *model.Message
+}
+
+func NewTwilioInput(config *model.TwilioConfig, users map[string]string, server *WebhookServer) (*TwilioInput, error) {
+	token, exists := os.LookupEnv(config.AuthTokenEnv)
+	if !exists {
+		zap.L().Error("No twilio auth token", zap.String("var", config.AuthTokenEnv))
+		return nil, fmt.Errorf("No twilio auth token in %s", config.AuthTokenEnv)
+	}
+
+	normalised := map[string]string{}
+	for number, user := range users {
+		normalised[utils.NormalisePhoneNumber(number)] = user
+	}
+
+	return &TwilioInput{
+		Server:    server,
+		Path:      config.GetPath(),
+		PublicUrl: strings.TrimSuffix(config.PublicUrl, "/"),
+		AuthToken: token,
+		Users:     normalised,
+	}, nil
+}
+
+// public
+
+func (i *TwilioInput) GetType() string {
+	return model.INPUT_TYPE_TWILIO
+}
+
+func (i *TwilioInput) Start(handler func(*model.Message)) {
+	if err := i.Server.Handle(i.Path, i.Webhook(handler)); err != nil {
+		// error logs in Handle, stopping ends the queue's worker as nothing will be queued
+		i.Stop()
+		return
+	}
+
+	zap.L().Info("Starting twilio input", zap.String("address", i.Server.Server.Addr), zap.String("path", i.Path))
+}
+
+// The server is stopped first so nothing can be queued
+func (i *TwilioInput) Stop() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.queue != nil {
+		close(i.queue)
+		i.queue = nil
+	}
+}
+
+// The handler Twilio posts to. Messages are answered straight away and handled one at a time in the order they
+// arrived, the same as telegram updates
+func (i *TwilioInput) Webhook(handler func(*model.Message)) http.Handler {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.queue = make(chan *model.Message, TWILIO_QUEUE_SIZE)
+	go func(queue chan *model.Message) {
+		for message := range queue {
+			handler(message)
+		}
+	}(i.queue)
+
+	return http.HandlerFunc(i.receive)
+}
+
+// private
+
+func (i *TwilioInput) receive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		zap.L().Warn("Failed to parse twilio webhook", zap.Error(err))
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	if !utils.ValidTwilioSignature(i.AuthToken, i.requestUrl(r), r.PostForm, r.Header.Get("X-Twilio-Signature")) {
+		zap.L().Warn("Twilio webhook signature is not valid", zap.String("url", i.requestUrl(r)))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	from := r.PostForm.Get("From")
+	user, ok := i.Users[utils.NormalisePhoneNumber(from)]
+	if !ok {
+		// no reply so unknown numbers don't cost anything
+		zap.L().Warn("Number not allowed", zap.String("from", from))
+		i.respond(w)
+		return
+	}
+
+	message := &model.Message{
+		UserName: user,
+		TwilioMessage: &model.TwilioMessage{
+			From: from,
+			To:   r.PostForm.Get("To"),
+			Body: r.PostForm.Get("Body"),
+			Id:   utils.PhoneNumberId(from),
+		},
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.queue == nil {
+		http.Error(w, "Stopped", http.StatusServiceUnavailable)
+		return
+	}
+
+	select {
+	case i.queue <- message:
+		i.respond(w)
+	default:
+		zap.L().Error("Twilio queue is full, dropping message", zap.String("from", from))
+		http.Error(w, "Busy", http.StatusServiceUnavailable)
+	}
+}
+
+func (i *TwilioInput) respond(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprint(w, TWILIO_EMPTY_RESPONSE)
+}
+
+// The url Twilio signed, behind a proxy the public url is needed as the request has the proxy's host
+func (i *TwilioInput) requestUrl(r *http.Request) string {
+	if len(i.PublicUrl) > 0 {
+		return i.PublicUrl + r.URL.RequestURI()
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); len(proto) > 0 {
+		scheme = proto
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.RequestURI())
+}
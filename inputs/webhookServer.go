@@ -0,0 +1,82 @@
+package inputs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"telegram-spreadsheet-editor/model"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	INPUT_TYPE_WEBHOOK_SERVER string        = "webhookServer"
+	WEBHOOK_SHUTDOWN_TIMEOUT  time.Duration = time.Second * 10
+)
+
+// An http server shared by the webhook inputs listening on the same address, each input has its own path
+type WebhookServer struct {
+	Server *http.Server
+
+	mu    sync.Mutex
+	mux   *http.ServeMux
+	paths map[string]bool
+}
+
+func NewWebhookServer(address string) *WebhookServer {
+	mux := http.NewServeMux()
+	return &WebhookServer{
+		Server: &http.Server{
+			Addr:    address,
+			Handler: mux,
+		},
+		mux:   mux,
+		paths: map[string]bool{},
+	}
+}
+
+// public
+
+// Adds an input's handler, safe to call while the server is running. Each path can only be used once
+func (s *WebhookServer) Handle(path string, handler http.Handler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(path, "/") {
+		zap.L().Error("Webhook path must start with /", zap.String("path", path))
+		return fmt.Errorf("Webhook path must start with /: %s", path)
+	}
+
+	if s.paths[path] {
+		zap.L().Error("Webhook path is already used", zap.String("path", path), zap.String("address", s.Server.Addr))
+		return fmt.Errorf("Webhook path is already used: %s", path)
+	}
+
+	s.paths[path] = true
+	s.mux.Handle(path, handler)
+	return nil
+}
+
+func (s *WebhookServer) GetType() string {
+	return INPUT_TYPE_WEBHOOK_SERVER
+}
+
+// The messages are handled by the inputs on the server so the handler isn't used
+func (s *WebhookServer) Start(handler func(*model.Message)) {
+	zap.L().Info("Starting webhook server", zap.String("address", s.Server.Addr))
+
+	if err := s.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		zap.L().Error("Webhook server stopped", zap.Error(err), zap.String("address", s.Server.Addr))
+	}
+}
+
+func (s *WebhookServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), WEBHOOK_SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := s.Server.Shutdown(ctx); err != nil {
+		zap.L().Warn("Failed to shut down webhook server", zap.Error(err), zap.String("address", s.Server.Addr))
+	}
+}
@@ -109,23 +109,105 @@ func main() {
 	// dependencies
 	httpClient := utils.HttpClient{}
 
-	dataService := services.NCDataService{
-		Http: &httpClient,
+	dataService := services.SourceDataService{
+		Services: map[string]services.IDataService{
+			model.SOURCE_TYPE_NEXTCLOUD: &services.NCDataService{
+				Http: &httpClient,
+			},
+			model.SOURCE_TYPE_GOOGLE_SHEETS: &services.GoogleSheetsDataService{
+				Http: &httpClient,
+			},
+			model.SOURCE_TYPE_FILE: &services.FileDataService{},
+		},
 	}
 	spreadsheetService := services.ExcelerizeSpreadsheetService{}
-	telegramService := services.TelegramService{}
-	valkeyStorageService := services.NewValkeyStorageService()
+	messagingService := services.InputMessagingService{
+		Services: map[string]services.IMessagingService{
+			model.INPUT_TYPE_TELEGRAM: &services.TelegramService{},
+			model.INPUT_TYPE_TWILIO: &services.TwilioService{
+				Client: &services.TwilioRestClient{
+					Http:       &httpClient,
+					AccountSid: config.Twilio.AccountSid,
+					AuthToken:  os.Getenv(config.Twilio.AuthTokenEnv),
+					BaseUrl:    config.Twilio.BaseUrl,
+				},
+			},
+		},
+	}
+
+	// valkey is only needed to share state between replicas, a home install can keep it in memory or a file
+	storageType := config.Storage.Type
+	if len(storageType) == 0 {
+		storageType = model.STORAGE_TYPE_MEMORY
+		if utils.HasValkey() {
+			storageType = model.STORAGE_TYPE_VALKEY
+		}
+	}
+
+	var storageService services.IStorageService
+	var lockService services.ILockService
+	switch storageType {
+	case model.STORAGE_TYPE_VALKEY:
+		if !utils.HasValkey() {
+			zap.L().Error("Valkey storage needs a valkey host", zap.String("env", utils.VALKEY_HOST_KEY))
+			return
+		}
+		valkeyStorageService := services.NewValkeyStorageService()
+		storageService = valkeyStorageService
+		lockService = services.NewValkeyLockService(valkeyStorageService.Client)
+	case model.STORAGE_TYPE_MEMORY:
+		storageService = &services.MemoryStorageService{}
+		lockService = &services.LocalLockService{}
+	case model.STORAGE_TYPE_BOLT:
+		boltStorageService := services.NewBoltStorageService(config.Storage.FilePath)
+		defer boltStorageService.DB.Close()
+		storageService = boltStorageService
+		lockService = &services.LocalLockService{}
+	default:
+		zap.L().Error("Unknown storage type", zap.String("type", storageType))
+		return
+	}
+
+	var rateService services.IRateService
+	switch config.Rates.Type {
+	case model.RATES_TYPE_FILE:
+		rateService = &services.StaticRateService{
+			FilePath: config.Rates.FilePath,
+		}
+	default:
+		rateService = &services.HttpRateService{
+			Http:    &httpClient,
+			BaseUrl: config.Rates.BaseUrl,
+		}
+	}
 
 	// routes
 	dataHandler := handlers.DataHandler{
 		DataService:        &dataService,
 		SpreadsheetService: &spreadsheetService,
-		MessagingService:   &telegramService,
-		StorageService:     valkeyStorageService,
+		MessagingService:   &messagingService,
+		StorageService:     storageService,
+		LockService:        lockService,
+		RateService:        rateService,
+	}
+	dataHandler.RegisterCommands()
+
+	// webhook inputs on the same address share a server, twilio ones share a path too
+	webhookServers := []inputs.Input{}
+	serversByAddress := map[string]*inputs.WebhookServer{}
+	webhookServer := func(address string) *inputs.WebhookServer {
+		if server, ok := serversByAddress[address]; ok {
+			return server
+		}
+		server := inputs.NewWebhookServer(address)
+		serversByAddress[address] = server
+		webhookServers = append(webhookServers, server)
+		return server
 	}
 
 	// create input handlers for each user's inputs
 	inputHandlers := []inputs.Input{}
+	twilioUsers := map[string]string{}
 	for _, u := range config.Users {
 		for _, i := range u.Inputs {
 			switch i.GetType() {
@@ -135,18 +217,42 @@ func main() {
 					zap.L().DPanic("For some reason the telegram input is not *TelegramInput")
 					break
 				}
-				in, err := inputs.NewTelegramInput(ti, u.Name)
+				var server *inputs.WebhookServer
+				if ti.GetMode() == model.TELEGRAM_MODE_WEBHOOK {
+					server = webhookServer(ti.GetListenAddress())
+				}
+				in, err := inputs.NewTelegramInput(ti, &u, server)
 				if err != nil {
 					// error logs in NewTelegramInput
 					break
 				}
 				inputHandlers = append(inputHandlers, in)
+			case model.INPUT_TYPE_TWILIO:
+				ti, ok := i.(*model.TwilioInput)
+				if !ok {
+					zap.L().DPanic("For some reason the twilio input is not *TwilioInput")
+					break
+				}
+				twilioUsers[ti.Number] = u.Name
 			default:
 				zap.L().Error("Unhandled input type", zap.String("type", i.GetType()))
 			}
 		}
 	}
 
+	if len(twilioUsers) > 0 {
+		in, err := inputs.NewTwilioInput(&config.Twilio, twilioUsers, webhookServer(config.Twilio.GetListenAddress()))
+		if err == nil {
+			inputHandlers = append(inputHandlers, in)
+		} else {
+			// the other inputs still start
+			zap.L().Warn("Twilio input not started", zap.Error(err))
+		}
+	}
+
+	// servers first so they stop taking messages before the inputs stop handling them
+	inputHandlers = append(webhookServers, inputHandlers...)
+
 	// start each
 	for _, h := range inputHandlers {
 		go h.Start(dataHandler.HandleMessage)
@@ -0,0 +1,18 @@
+package model
+
+// The highest alert percentage of the budget a total went past e.g. 80 when going from 70% to 85%, 0 if none were
+func CrossedBudgetAlert(before float64, after float64, budget float64, alerts []int) int {
+	if budget <= 0 {
+		return 0
+	}
+
+	crossed := 0
+	for _, alert := range alerts {
+		limit := budget * float64(alert) / 100
+		if before < limit && after >= limit && alert > crossed {
+			crossed = alert
+		}
+	}
+
+	return crossed
+}
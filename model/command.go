@@ -2,10 +2,10 @@ package model
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 	e "telegram-spreadsheet-editor/errors"
 	"telegram-spreadsheet-editor/utils"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -27,23 +27,62 @@ const (
 	COMMAND_TYPE_DORIS                   byte = iota
 	COMMAND_TYPE_BOOBS                   byte = iota
 	COMMAND_TYPE_ALICE                   byte = iota
+	COMMAND_TYPE_EARN                    byte = iota
+	COMMAND_TYPE_NEW_MONTH               byte = iota
+	COMMAND_TYPE_REFUND                  byte = iota
+	COMMAND_TYPE_CANCEL                  byte = iota
+	COMMAND_TYPE_UNDO                    byte = iota
+	COMMAND_TYPE_HISTORY                 byte = iota
+	COMMAND_TYPE_ADD_CATEGORY            byte = iota
+	COMMAND_TYPE_ADD_EARNING_CATEGORY    byte = iota
+	COMMAND_TYPE_RENAME_CATEGORY         byte = iota
+	COMMAND_TYPE_RENAME_CATEGORY_CHOSEN  byte = iota
+	COMMAND_TYPE_DELETE_CATEGORY         byte = iota
+	COMMAND_TYPE_DELETE_CATEGORY_CHOSEN  byte = iota
+	COMMAND_TYPE_CONFIRM                 byte = iota
+	COMMAND_TYPE_BUDGET                  byte = iota
+)
+
+const (
+	// marks callback data as belonging to the earnings columns e.g. READ:E:Salary
+	CALLBACK_EARNING_MARKER string = "E"
 )
 
 type UpdateData struct {
 	Category *string  `json:"category,omitempty"`
 	Value    *float32 `json:"value,omitempty"`
+	Earning  bool     `json:"earning,omitempty"`
+	// the sum as typed e.g. 12.50+3.20, empty for a plain amount
+	Expression string `json:"expression,omitempty"`
+	// the amount is taken off the category rather than added
+	Refund bool `json:"refund,omitempty"`
+	// the currency the amount was given in e.g. USD, empty when none was given
+	Currency string `json:"currency,omitempty"`
+	// free text after the amount e.g. "lunch with Sam"
+	Note string `json:"note,omitempty"`
 }
 
 type ReadData struct {
 	Category string `json:"category,omitempty"`
+	Earning  bool   `json:"earning,omitempty"`
 }
 
 type DetailsData struct {
 	Category string `json:"category,omitempty"`
+	Earning  bool   `json:"earning,omitempty"`
 }
 
 type RemoveData struct {
 	Category string `json:"category,omitempty"`
+	Earning  bool   `json:"earning,omitempty"`
+}
+
+// For ADD, RENAME and DELETE CATEGORY
+type CategoryData struct {
+	Category string `json:"category,omitempty"`
+	Earning  bool   `json:"earning,omitempty"`
+	// what RENAME CATEGORY changes the name to
+	NewName string `json:"newName,omitempty"`
 }
 
 type Command struct {
@@ -52,99 +91,62 @@ type Command struct {
 	ChatId    int64 `json:"chatId"`
 	MessageId int   `json:"messageId"`
 
+	// set when the category was typed rather than chosen e.g. "u groceries 12.50", it still needs matching to a real category
+	CategoryQuery string `json:"categoryQuery,omitempty"`
+	// the month typed after the category e.g. "r groceries march", nil for the sheet the config picks
+	Month *time.Time `json:"month,omitempty"`
+
 	UpdateData  *UpdateData  `json:"updateData,omitempty"`
 	ReadData    *ReadData    `json:"readData,omitempty"`
 	DetailsData *DetailsData `json:"detailsData,omitempty"`
 	RemoveData  *RemoveData  `json:"removeData,omitempty"`
+	// e.g. the category being renamed and its new name
+	CategoryData *CategoryData `json:"categoryData,omitempty"`
 }
 
-func CommandFromMessage(message string, chatId int64, messageId int, userId int64) (*Command, error) {
+// Locale is how the user types numbers e.g. 12,50 in de-DE
+func CommandFromMessage(message string, chatId int64, messageId int, userId int64, locale string) (*Command, error) {
+	// one shot commands e.g. "u groceries 12.50" or "r groceries"
+	if command := inlineCommandFromMessage(message, chatId, messageId, userId, locale); command != nil {
+		return command, nil
+	}
+
 	norm := strings.ToLower(strings.ReplaceAll(message, " ", ""))
-	switch {
-	case norm == "ping":
-		return &Command{
-			Type:      COMMAND_TYPE_PING,
-			ChatId:    chatId,
-			MessageId: messageId,
-			UserId:    userId,
-		}, nil
-	case norm == "list" || norm == "l":
-		return &Command{
-			Type:      COMMAND_TYPE_LIST,
-			ChatId:    chatId,
-			MessageId: messageId,
-			UserId:    userId,
-		}, nil
-	case norm == "update" || norm == "u":
-		return &Command{
-			Type:      COMMAND_TYPE_UPDATE,
-			ChatId:    chatId,
-			MessageId: messageId,
-			UserId:    userId,
-		}, nil
-	case utils.IsFinancial(norm):
-		return commandFromFinancial(norm, chatId, messageId, userId)
-	case norm == "read" || norm == "r":
-		return &Command{
-			Type:      COMMAND_TYPE_READ,
-			ChatId:    chatId,
-			MessageId: messageId,
-			UserId:    userId,
-		}, nil
-	case norm == "details":
-		return &Command{
-			Type:      COMMAND_TYPE_DETAILS,
-			ChatId:    chatId,
-			MessageId: messageId,
-			UserId:    userId,
-		}, nil
-	case norm == "remove":
+	if definition := definitionForKeyword(norm); definition != nil {
 		return &Command{
-			Type:      COMMAND_TYPE_REMOVE,
+			Type:      definition.Type,
 			ChatId:    chatId,
 			MessageId: messageId,
 			UserId:    userId,
 		}, nil
-	case norm == "help":
-		return &Command{
-			Type:      COMMAND_TYPE_HELP,
-			ChatId:    chatId,
-			MessageId: messageId,
-			UserId:    userId,
-		}, nil
-	case norm == "doris":
-		return &Command{
-			Type:      COMMAND_TYPE_DORIS,
-			ChatId:    chatId,
-			MessageId: messageId,
-			UserId:    userId,
-		}, nil
-	case norm == "boobs":
-		return &Command{
-			Type:      COMMAND_TYPE_BOOBS,
-			ChatId:    chatId,
-			MessageId: messageId,
-			UserId:    userId,
-		}, nil
-	case norm == "alice":
-		return &Command{
-			Type:      COMMAND_TYPE_ALICE,
-			ChatId:    chatId,
-			MessageId: messageId,
-			UserId:    userId,
-		}, nil
-	default:
-		return nil, &e.CommandError{
-			ResponseMessage: fmt.Sprintf("%s not a recognised command", message),
-			ChatId:          chatId,
+	}
+
+	amount := utils.NormaliseAmount(norm, locale)
+	if utils.IsFinancial(amount) {
+		return commandFromFinancial(amount, chatId, messageId, userId)
+	}
+
+	// an amount with a note e.g. "12.50 lunch with Sam"
+	if amount, note := splitNote(strings.Fields(message), locale); len(note) > 0 {
+		command, err := commandFromFinancial(amount, chatId, messageId, userId)
+		if err != nil {
+			return nil, err
 		}
+		command.UpdateData.Note = note
+		return command, nil
+	}
+
+	return nil, &e.CommandError{
+		ResponseMessage: fmt.Sprintf("%s not a recognised command", message),
+		ChatId:          chatId,
 	}
 }
 
 func CommandFromCallback(data string, chatId int64, messageId int, userId int64) (*Command, error) {
-	// all callback data has the original command and then the data
+	// all callback data has the original command and then the data, earnings have a marker in between
 	split := strings.Split(data, ":")
-	if len(split) != 2 {
+	earning := len(split) == 3 && split[1] == CALLBACK_EARNING_MARKER
+	if len(split) != 2 && !earning {
 		zap.L().DPanic("Cannot parse callback data", zap.String("data", data))
 		return nil, &e.CommandError{
 			ResponseMessage: "Error, sorry...",
@@ -152,55 +154,31 @@ func CommandFromCallback(data string, chatId int64, messageId int, userId int64)
 		}
 	}
 
-	category := split[1]
+	category := split[len(split)-1]
 
-	switch split[0] {
-	case "UPDATE":
-		return &Command{
-			Type:      COMMAND_TYPE_UPDATE_CATEGORY_CHOSEN,
-			ChatId:    chatId,
-			MessageId: messageId,
-			UserId:    userId,
-			UpdateData: &UpdateData{
-				Category: &category,
-			},
-		}, nil
-	case "READ":
-		return &Command{
-			Type:      COMMAND_TYPE_READ_CATEGORY_CHOSEN,
-			ChatId:    chatId,
-			MessageId: messageId,
-			UserId:    userId,
-			ReadData: &ReadData{
-				Category: category,
-			},
-		}, nil
-	case "DETAILS":
-		return &Command{
-			Type:      COMMAND_TYPE_DETAILS_CATEGORY_CHOSEN,
-			ChatId:    chatId,
-			MessageId: messageId,
-			UserId:    userId,
-			DetailsData: &DetailsData{
-				Category: category,
-			},
-		}, nil
-	case "REMOVE":
-		return &Command{
-			Type:      COMMAND_TYPE_REMOVE_CATEGORY_CHOSEN,
-			ChatId:    chatId,
-			MessageId: messageId,
-			UserId:    userId,
-			RemoveData: &RemoveData{
-				Category: category,
-			},
-		}, nil
-	default:
+	definition := definitionForCallback(split[0])
+	if definition == nil || definition.FromCallback == nil {
 		return nil, &e.CommandError{
 			ResponseMessage: fmt.Sprintf("%s not a recognised command", split[0]),
 			ChatId:          chatId,
 		}
 	}
+
+	command := &Command{
+		ChatId:    chatId,
+		MessageId: messageId,
+		UserId:    userId,
+	}
+	definition.FromCallback(command, category, earning)
+
+	return command, nil
+}
+
+func CallbackData(command string, entry Entry) string {
+	if entry.Earning {
+		return fmt.Sprintf("%s:%s:%s", command, CALLBACK_EARNING_MARKER, entry.Category)
+	}
+	return fmt.Sprintf("%s:%s", command, entry.Category)
 }
 
 func MergeUpdateCommandWithFinancial(update *Command, financial *Command) *Command {
@@ -209,35 +187,177 @@ func MergeUpdateCommandWithFinancial(update *Command, financial *Command) *Comma
 		ChatId:    update.ChatId,
 		MessageId: update.MessageId,
 		UserId:    update.UserId,
+		Month:     update.Month,
 		UpdateData: &UpdateData{
-			Category: update.UpdateData.Category,
-			Value:    financial.UpdateData.Value,
+			Category:   update.UpdateData.Category,
+			Value:      financial.UpdateData.Value,
+			Earning:    update.UpdateData.Earning,
+			Expression: financial.UpdateData.Expression,
+			Refund:     update.UpdateData.Refund,
+			Currency:   financial.UpdateData.Currency,
+			Note:       financial.UpdateData.Note,
 		},
 	}
 }
 
 // private
 
+func inlineCommandFromMessage(message string, chatId int64, messageId int, userId int64, locale string) *Command {
+	tokens := strings.Fields(message)
+	if len(tokens) < 2 {
+		return nil
+	}
+
+	command := Command{
+		ChatId:    chatId,
+		MessageId: messageId,
+		UserId:    userId,
+	}
+
+	// keywords can be more than one word e.g. "add category pets", the longest wins
+	var definition *CommandDefinition
+	rest := tokens
+	for end := len(tokens) - 1; end > 0 && definition == nil; end-- {
+		if d := definitionForKeyword(strings.Join(tokens[:end], "")); d != nil && d.Inline {
+			definition = d
+			rest = tokens[end:]
+		}
+	}
+	if definition == nil {
+		return nil
+	}
+	command.Type = definition.Type
+
+	// updates can have an amount on the end
+	if definition.TakesAmount {
+		command.UpdateData = &UpdateData{}
+
+		// the longest trailing amount wins e.g. "groceries 12.50 + 3.20" but "groceries -5" is just -5
+		for start := amountStart(rest); start < len(rest); start++ {
+			if isMonthYear(rest, start) {
+				continue
+			}
+			amount := utils.NormaliseAmount(strings.Join(rest[start:], ""), locale)
+			if !utils.IsFinancial(amount) {
+				continue
+			}
+
+			financial, err := commandFromFinancial(amount, chatId, messageId, userId)
+			if err != nil {
+				return nil
+			}
+			command.UpdateData.Value = financial.UpdateData.Value
+			command.UpdateData.Expression = financial.UpdateData.Expression
+			command.UpdateData.Currency = financial.UpdateData.Currency
+			rest = rest[:start]
+			break
+		}
+
+		// otherwise the amount can be followed by a note e.g. "groceries 12.50 lunch with Sam"
+		for start := 1; command.UpdateData.Value == nil && start < len(rest); start++ {
+			if isMonthYear(rest, start) {
+				continue
+			}
+			amount, note := splitNote(rest[start:], locale)
+			if len(note) == 0 {
+				continue
+			}
+
+			financial, err := commandFromFinancial(amount, chatId, messageId, userId)
+			if err != nil {
+				return nil
+			}
+			command.UpdateData.Value = financial.UpdateData.Value
+			command.UpdateData.Expression = financial.UpdateData.Expression
+			command.UpdateData.Currency = financial.UpdateData.Currency
+			command.UpdateData.Note = note
+			rest = rest[:start]
+		}
+	}
+
+	// a month can follow the category e.g. "r groceries march" or "u groceries march 12.50"
+	if month, taken := utils.TrailingMonth(rest, time.Now()); month != nil {
+		command.Month = month
+		rest = rest[:len(rest)-taken]
+	}
+
+	command.CategoryQuery = strings.Join(rest, " ")
+	if definition.Prepare != nil {
+		definition.Prepare(&command)
+	}
+
+	return &command
+}
+
+// Index of the first token of a trailing amount, tokens joined by an operator belong together e.g. "12.50 + 3.20"
+// as does a currency e.g. "25 EUR" or "25 €"
+func amountStart(tokens []string) int {
+	start := len(tokens) - 1
+	if start > 0 && utils.IsCurrency(tokens[start]) {
+		start--
+	}
+
+	for start > 0 {
+		if !strings.ContainsAny(tokens[start][:1], "+-*/)") && !strings.ContainsAny(tokens[start-1][len(tokens[start-1])-1:], "+-*/(") {
+			break
+		}
+		start--
+	}
+
+	if start > 0 && utils.IsCurrency(tokens[start-1]) {
+		start--
+	}
+
+	return start
+}
+
+// Whether the token is the year of a month e.g. 2025 in "groceries march 2025" rather than an amount
+func isMonthYear(tokens []string, index int) bool {
+	_, taken := utils.TrailingMonth(tokens[:index+1], time.Now())
+	return taken == 2
+}
+
+// Splits a leading amount from the note after it e.g. "12.50 + 3.20 lunch with Sam", the longest amount wins.
+// The note is empty when there isn't both an amount and something after it
+func splitNote(tokens []string, locale string) (string, string) {
+	for end := len(tokens) - 1; end > 0; end-- {
+		amount := utils.NormaliseAmount(strings.ToLower(strings.Join(tokens[:end], "")), locale)
+		if utils.IsFinancial(amount) {
+			return amount, strings.Join(tokens[end:], " ")
+		}
+	}
+
+	return "", ""
+}
+
 func commandFromFinancial(str string, chatId int64, messageId int, userId int64) (*Command, error) {
-	// strip any £
-	stripped := strings.ReplaceAll(str, "£", "")
-	val, err := strconv.ParseFloat(stripped, 32)
+	// strip any currency e.g. £, $ or EUR
+	stripped, currency := utils.SplitCurrency(str)
+	stripped = strings.ReplaceAll(stripped, " ", "")
+	val, err := utils.EvaluateExpression(stripped)
 	if err != nil {
 		zap.L().DPanic("Failed to parse financial amount", zap.Error(err), zap.String("amount", stripped))
 		return nil, &e.CommandError{
-			ResponseMessage: fmt.Sprintf("Could not convert %s to GBP. Please enter a valid amount", str),
+			ResponseMessage: fmt.Sprintf("Could not convert %s to an amount. Please enter a valid amount", str),
 			ChatId:          chatId,
 		}
 	}
 
 	lower := float32(val)
+	expression := ""
+	if utils.IsExpression(stripped) {
+		expression = stripped
+	}
+
 	return &Command{
 		Type:      COMMAND_TYPE_NUMERICAL_AMOUNT,
 		ChatId:    chatId,
 		MessageId: messageId,
 		UserId:    userId,
 		UpdateData: &UpdateData{
-			Value: &lower,
+			Value:      &lower,
+			Expression: expression,
+			Currency:   currency,
 		},
 	}, nil
 }
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Does the work for a command. The dialog is the one in progress, nil if there isn't one. Returns the dialog to carry
+// on with for the user's next message, nil ends it
+type CommandHandler func(message *Message, command *Command, dialog *Dialog) *Dialog
+
+type CommandDefinition struct {
+	Type byte
+	// the first is the name shown in HELP, the rest are aliases. Matched ignoring case and spaces e.g. "new month"
+	Keywords []string
+	// the rest of the message is a category e.g. "r groceries"
+	Inline bool
+	// the category can be followed by an amount e.g. "u groceries 12.50"
+	TakesAmount bool
+	// fills in command specific data for one shot commands e.g. marking "e salary 1000" as an earning
+	Prepare func(command *Command)
+	// keyboard choices are sent back as PREFIX:category, empty if the command never sends a keyboard
+	CallbackPrefix string
+	// fills in the command for a category chosen from the keyboard
+	FromCallback func(command *Command, category string, earning bool)
+	// the least role that can use it e.g. ROLE_EDITOR, empty for everyone
+	Role string
+	// shown in HELP, commands without help are hidden e.g. easter eggs
+	Help    string
+	Handler CommandHandler
+}
+
+var (
+	registryMu  sync.RWMutex
+	definitions []CommandDefinition
+)
+
+// Adds a command, registering the same type again replaces it
+func RegisterCommand(definition CommandDefinition) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for i, d := range definitions {
+		if d.Type == definition.Type {
+			definitions[i] = definition
+			return
+		}
+	}
+
+	definitions = append(definitions, definition)
+}
+
+func GetCommandDefinition(commandType byte) *CommandDefinition {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	for i := range definitions {
+		if definitions[i].Type == commandType {
+			return &definitions[i]
+		}
+	}
+
+	return nil
+}
+
+// Lists the commands with help the role can use in the order they were registered
+func HelpText(role string) string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var builder strings.Builder
+	for _, d := range definitions {
+		if len(d.Help) == 0 || len(d.Keywords) == 0 || !RoleAllows(role, d.Role) {
+			continue
+		}
+		fmt.Fprintf(&builder, "%s - %s\n", strings.ToUpper(strings.Join(d.Keywords, " | ")), d.Help)
+	}
+
+	return strings.TrimSuffix(builder.String(), "\n")
+}
+
+// private
+
+func definitionForKeyword(keyword string) *CommandDefinition {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	norm := normaliseKeyword(keyword)
+	for i := range definitions {
+		for _, k := range definitions[i].Keywords {
+			if normaliseKeyword(k) == norm {
+				return &definitions[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+func definitionForCallback(prefix string) *CommandDefinition {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	for i := range definitions {
+		if len(definitions[i].CallbackPrefix) > 0 && definitions[i].CallbackPrefix == prefix {
+			return &definitions[i]
+		}
+	}
+
+	return nil
+}
+
+func normaliseKeyword(keyword string) string {
+	return strings.ToLower(strings.ReplaceAll(keyword, " ", ""))
+}
@@ -3,17 +3,74 @@ package model
 import (
 	"fmt"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"go.yaml.in/yaml/v3"
 )
 
 const (
 	INPUT_TYPE_TELEGRAM string = "telegram"
+	INPUT_TYPE_TWILIO   string = "twilio"
 )
 
 const (
-	SOURCE_TYPE_NEXTCLOUD string = "nextcloud"
+	SOURCE_TYPE_NEXTCLOUD     string = "nextcloud"
+	SOURCE_TYPE_GOOGLE_SHEETS string = "googlesheets"
+	SOURCE_TYPE_FILE          string = "file"
+)
+
+const (
+	RATES_TYPE_HTTP string = "http"
+	RATES_TYPE_FILE string = "file"
+)
+
+const (
+	STORAGE_TYPE_VALKEY string = "valkey"
+	STORAGE_TYPE_MEMORY string = "memory"
+	STORAGE_TYPE_BOLT   string = "bolt"
+)
+
+const (
+	// telegram inputs long poll by default
+	TELEGRAM_MODE_POLLING string = "polling"
+	TELEGRAM_MODE_WEBHOOK string = "webhook"
+)
+
+const (
+	// webhook inputs on the same address share one server
+	DEFAULT_WEBHOOK_LISTEN_ADDRESS string = ":8080"
+	DEFAULT_TWILIO_PATH            string = "/twilio"
+	// followed by the bot's username
+	DEFAULT_TELEGRAM_PATH_PREFIX string = "/telegram/"
+)
+
+const (
+	// used when a spreadsheet source doesn't say
+	DEFAULT_CURRENCY string = "GBP"
+	DEFAULT_LOCALE   string = "en-GB"
+)
+
+const (
+	// go time layout used to name new month sheets
+	DEFAULT_SHEET_NAME_FORMAT string = "Jan 2006"
+)
+
+const (
+	// the last tab, the default
+	SHEET_SELECTION_LAST string = "last"
+	// the newest tab named like sheetNameFormat wherever it is e.g. Mar 2026
+	SHEET_SELECTION_PATTERN string = "pattern"
+	// the tab named like sheetNameFormat for the current month
+	SHEET_SELECTION_DATE string = "date"
+	// always the tab in sheetName
+	SHEET_SELECTION_FIXED string = "fixed"
+)
+
+var (
+	// percentages of a budget to warn at
+	DEFAULT_BUDGET_ALERTS = []int{80, 100}
 )
 
 var (
@@ -37,10 +94,80 @@ type TelegramInput struct {
 	BaseInput `yaml:",inline"`
 	UserId    int64  `yaml:"userId"`
 	TokenEnv  string `yaml:"tokenEnv"`
+	// others who can use the bot e.g. a household sharing the sheet, in a group chat or on their own
+	Members []TelegramMember `yaml:"members"`
+	// polling or webhook, defaults to polling
+	Mode string `yaml:"mode"`
+	// webhook mode only. Where the server listens, defaults to :8080, and the path Telegram posts to, defaults
+	// to /telegram/<bot username>
+	ListenAddress string `yaml:"listenAddress"`
+	Path          string `yaml:"path"`
+	// webhook mode only. The url Telegram posts to without the path e.g. https://bot.example.com
+	PublicUrl string `yaml:"publicUrl"`
+	// webhook mode only, optional. A random secret is registered on each start without it
+	SecretTokenEnv string `yaml:"secretTokenEnv"`
+}
+
+// Someone else who can use a telegram bot
+type TelegramMember struct {
+	UserId int64 `yaml:"userId"`
+	// who their changes are attributed to in the history and journal, defaults to the user's name
+	Name string `yaml:"name"`
+	// readonly, editor or admin, defaults to the user's role
+	Role string `yaml:"role"`
+}
+
+// Everyone who can use the bot by telegram user id with the name their changes are attributed to and their role
+func (t TelegramInput) GetMembers(user *User) map[int64]TelegramMember {
+	members := map[int64]TelegramMember{}
+	if t.UserId != 0 {
+		members[t.UserId] = TelegramMember{UserId: t.UserId, Name: user.Name, Role: user.GetRole()}
+	}
+	for _, m := range t.Members {
+		if len(m.Name) == 0 {
+			m.Name = user.Name
+		}
+		if len(m.Role) == 0 {
+			m.Role = user.GetRole()
+		}
+		members[m.UserId] = m
+	}
+	return members
+}
+
+func (t TelegramInput) GetMode() string {
+	if len(t.Mode) == 0 {
+		return TELEGRAM_MODE_POLLING
+	}
+	return t.Mode
+}
+
+func (t TelegramInput) GetListenAddress() string {
+	if len(t.ListenAddress) == 0 {
+		return DEFAULT_WEBHOOK_LISTEN_ADDRESS
+	}
+	return t.ListenAddress
+}
+
+// WhatsApp or SMS through Twilio, the webhook server itself is set up once in the twilio config
+type TwilioInput struct {
+	BaseInput `yaml:",inline"`
+	// the user's number as Twilio sends it, whatsapp:+447700900123 and +447700900123 are both matched
+	Number string `yaml:"number"`
 }
 
 type SpreadsheetSource interface {
 	GetType() string
+	// uniquely identifies the spreadsheet e.g. for locking
+	GetKey() string
+	// the currency values in the sheet are in e.g. GBP
+	GetCurrency() string
+	// how numbers are typed and shown e.g. en-GB or de-DE
+	GetLocale() string
+	// percentages of a budget to warn at e.g. 80 and 100
+	GetBudgetAlerts() []int
+	// how month tabs are named as a go time layout e.g. Jan 2006
+	GetSheetNameFormat() string
 }
 
 type BaseSpreadsheetSource struct {
@@ -49,13 +176,76 @@ type BaseSpreadsheetSource struct {
 	CostValueColumn     string `yaml:"costValueColumn"`
 	EarningNameColumn   string `yaml:"earningNameColumn"`
 	EarningsValueColumn string `yaml:"earningsValueColumn"`
-	StartRow            int    `yaml:"startRow"`
+	// the rows the categories are in, without an end row they stop after a few empty rows
+	StartRow int `yaml:"startRow"`
+	EndRow   int `yaml:"endRow"`
+	// the rows with the grand totals LIST shows, the SUM under the categories is used when not set
+	TotalRow         int `yaml:"totalRow"`
+	EarningsTotalRow int `yaml:"earningsTotalRow"`
+	// e.g. EUR and de-DE, defaults to GBP and en-GB
+	Currency string `yaml:"currency"`
+	Locale   string `yaml:"locale"`
+	// write sums like 12.50+3.20 into the formula rather than their total so DETAILS shows the breakdown
+	WriteExpressions bool `yaml:"writeExpressions"`
+	// which tab commands edit, see the SHEET_SELECTION_ constants. sheetName is the tab for fixed
+	SheetSelection string `yaml:"sheetSelection"`
+	SheetName      string `yaml:"sheetName"`
+	// NEW MONTH settings
+	SheetNameFormat string             `yaml:"sheetNameFormat"`
+	CostDefaults    map[string]float32 `yaml:"costDefaults"`
+	EarningDefaults map[string]float32 `yaml:"earningDefaults"`
+	// optional tab e.g. Journal that gets a row per amount added, left out of the month sheets
+	JournalSheet string `yaml:"journalSheet"`
+	// budgets for cost categories either from a column on the same row e.g. F or by name, the column wins
+	BudgetColumn string             `yaml:"budgetColumn"`
+	Budgets      map[string]float32 `yaml:"budgets"`
+	// percentages of a budget that get a warning when an UPDATE goes past them, defaults to 80 and 100
+	BudgetAlerts []int `yaml:"budgetAlerts"`
 }
 
 func (b BaseSpreadsheetSource) GetType() string {
 	return b.Type
 }
 
+func (b BaseSpreadsheetSource) GetKey() string {
+	return b.Type
+}
+
+func (b BaseSpreadsheetSource) GetCurrency() string {
+	if len(b.Currency) == 0 {
+		return DEFAULT_CURRENCY
+	}
+	return strings.ToUpper(b.Currency)
+}
+
+func (b BaseSpreadsheetSource) GetLocale() string {
+	if len(b.Locale) == 0 {
+		return DEFAULT_LOCALE
+	}
+	return b.Locale
+}
+
+func (b BaseSpreadsheetSource) GetBudgetAlerts() []int {
+	if len(b.BudgetAlerts) == 0 {
+		return DEFAULT_BUDGET_ALERTS
+	}
+	return b.BudgetAlerts
+}
+
+func (b BaseSpreadsheetSource) GetSheetSelection() string {
+	if len(b.SheetSelection) == 0 {
+		return SHEET_SELECTION_LAST
+	}
+	return strings.ToLower(b.SheetSelection)
+}
+
+func (b BaseSpreadsheetSource) GetSheetNameFormat() string {
+	if len(b.SheetNameFormat) == 0 {
+		return DEFAULT_SHEET_NAME_FORMAT
+	}
+	return b.SheetNameFormat
+}
+
 type NextcloudSpreadsheetSource struct {
 	BaseSpreadsheetSource `yaml:",inline"`
 	User                  string `yaml:"user"`
@@ -64,14 +254,101 @@ type NextcloudSpreadsheetSource struct {
 	FilePath              string `yaml:"filePath"`
 }
 
+func (n NextcloudSpreadsheetSource) GetKey() string {
+	return fmt.Sprintf("%s:%s/%s", n.Type, n.BaseUrl, n.FilePath)
+}
+
+type GoogleSheetsSpreadsheetSource struct {
+	BaseSpreadsheetSource `yaml:",inline"`
+	SpreadsheetId         string `yaml:"spreadsheetId"`
+	// service account credentials json, either a file path or an env var holding the json
+	CredentialsFile string `yaml:"credentialsFile"`
+	CredentialsEnv  string `yaml:"credentialsEnv"`
+	// optional, defaults to the google API. Useful for testing
+	BaseUrl string `yaml:"baseUrl"`
+}
+
+func (g GoogleSheetsSpreadsheetSource) GetKey() string {
+	return fmt.Sprintf("%s:%s", g.Type, g.SpreadsheetId)
+}
+
+type FileSpreadsheetSource struct {
+	BaseSpreadsheetSource `yaml:",inline"`
+	FilePath              string `yaml:"filePath"`
+}
+
+func (f FileSpreadsheetSource) GetKey() string {
+	return fmt.Sprintf("%s:%s", f.Type, f.FilePath)
+}
+
 type User struct {
-	Name              string            `yaml:"name"`
+	Name string `yaml:"name"`
+	// readonly, editor or admin, defaults to admin
+	Role              string            `yaml:"role"`
 	Inputs            []Input           `yaml:"inputs"`
 	SpreadsheetSource SpreadsheetSource `yaml:"spreadsheetSource"`
 }
 
+func (u *User) GetRole() string {
+	if len(u.Role) == 0 {
+		return ROLE_ADMIN
+	}
+	return u.Role
+}
+
 type Config struct {
 	Users []User `yaml:"users"`
+	// where exchange rates come from for amounts in other currencies e.g. $25
+	Rates RatesConfig `yaml:"rates"`
+	// where commands in progress are kept between messages
+	Storage StorageConfig `yaml:"storage"`
+	// how long a multi-step command waits for the next step e.g. 10m, defaults to 15m
+	DialogTimeout time.Duration `yaml:"dialogTimeout"`
+	// the webhook server for twilio inputs
+	Twilio TwilioConfig `yaml:"twilio"`
+}
+
+type TwilioConfig struct {
+	AccountSid   string `yaml:"accountSid"`
+	AuthTokenEnv string `yaml:"authTokenEnv"`
+	// where the webhook server listens and the path Twilio posts to, defaults to :8080 and /twilio
+	ListenAddress string `yaml:"listenAddress"`
+	Path          string `yaml:"path"`
+	// the url Twilio posts to without the path e.g. https://bot.example.com. Requests are signed with it so
+	// it's needed behind a proxy, otherwise it's worked out from the request
+	PublicUrl string `yaml:"publicUrl"`
+	// optional, defaults to the twilio API. Useful for testing
+	BaseUrl string `yaml:"baseUrl"`
+}
+
+func (t TwilioConfig) GetListenAddress() string {
+	if len(t.ListenAddress) == 0 {
+		return DEFAULT_WEBHOOK_LISTEN_ADDRESS
+	}
+	return t.ListenAddress
+}
+
+func (t TwilioConfig) GetPath() string {
+	if len(t.Path) == 0 {
+		return DEFAULT_TWILIO_PATH
+	}
+	return t.Path
+}
+
+type StorageConfig struct {
+	// valkey, memory or bolt. Defaults to valkey when VALKEY_HOST is set and memory otherwise
+	Type string `yaml:"type"`
+	// the bolt database file e.g. /data/editor.db
+	FilePath string `yaml:"filePath"`
+}
+
+type RatesConfig struct {
+	// http (default) or file
+	Type string `yaml:"type"`
+	// optional, defaults to the frankfurter API
+	BaseUrl string `yaml:"baseUrl"`
+	// yaml file of rates against a base currency for offline use
+	FilePath string `yaml:"filePath"`
 }
 
 func NewConfigFromFile(path string) (*Config, error) {
@@ -85,6 +362,10 @@ func NewConfigFromFile(path string) (*Config, error) {
 		return nil, err
 	}
 
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
 
@@ -98,12 +379,87 @@ func GetConfig() *Config {
 	return cfg
 }
 
+func (c *Config) GetUser(name string) *User {
+	for i := range c.Users {
+		if c.Users[i].Name == name {
+			return &c.Users[i]
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) GetDialogTimeout() time.Duration {
+	if c.DialogTimeout <= 0 {
+		return DEFAULT_DIALOG_TIMEOUT
+	}
+	return c.DialogTimeout
+}
+
+// Validation
+
+// Checks what can't be checked while unmarshalling a single user
+func (c *Config) validate() error {
+	if err := c.validateStorage(); err != nil {
+		return err
+	}
+	return c.validateWebhookPaths()
+}
+
+func (c *Config) validateStorage() error {
+	if c.Storage.Type == STORAGE_TYPE_BOLT && len(c.Storage.FilePath) == 0 {
+		return fmt.Errorf("bolt storage needs a filePath e.g. /data/editor.db")
+	}
+	return nil
+}
+
+// Inputs sharing a webhook server need their own paths. Telegram's default path has the bot's username in it so
+// it's only known once the bot has started, the server refuses it then if it's taken
+func (c *Config) validateWebhookPaths() error {
+	used := map[string]bool{}
+	add := func(address string, path string) error {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("webhook path must start with /: %s", path)
+		}
+		key := address + path
+		if used[key] {
+			return fmt.Errorf("webhook path is used twice on %s: %s", address, path)
+		}
+		used[key] = true
+		return nil
+	}
+
+	twilio := false
+	for _, u := range c.Users {
+		for _, i := range u.Inputs {
+			switch input := i.(type) {
+			case *TelegramInput:
+				if input.GetMode() != TELEGRAM_MODE_WEBHOOK || len(input.Path) == 0 {
+					continue
+				}
+				if err := add(input.GetListenAddress(), input.Path); err != nil {
+					return err
+				}
+			case *TwilioInput:
+				twilio = true
+			}
+		}
+	}
+
+	if twilio {
+		return add(c.Twilio.GetListenAddress(), c.Twilio.GetPath())
+	}
+
+	return nil
+}
+
 // Unmarshalling
 
 func (u *User) UnmarshalYAML(node *yaml.Node) error {
 	// capture the raw data
 	type rawUser struct {
 		Name              string      `yaml:"name"`
+		Role              string      `yaml:"role"`
 		Inputs            []yaml.Node `yaml:"inputs"`
 		SpreadsheetSource yaml.Node   `yaml:"spreadsheetSource"`
 	}
@@ -114,6 +470,10 @@ func (u *User) UnmarshalYAML(node *yaml.Node) error {
 	}
 
 	u.Name = raw.Name
+	u.Role = raw.Role
+	if len(u.Role) > 0 && !IsRole(u.Role) {
+		return fmt.Errorf("unknown role: %s", u.Role)
+	}
 
 	for _, inputNode := range raw.Inputs {
 		var base BaseInput
@@ -128,6 +488,17 @@ func (u *User) UnmarshalYAML(node *yaml.Node) error {
 			if err := inputNode.Decode(&t); err != nil {
 				return fmt.Errorf("Failed to decode telegram input node: %w", err)
 			}
+			for _, m := range t.Members {
+				if len(m.Role) > 0 && !IsRole(m.Role) {
+					return fmt.Errorf("unknown role: %s", m.Role)
+				}
+			}
+			input = &t
+		case INPUT_TYPE_TWILIO:
+			var t TwilioInput
+			if err := inputNode.Decode(&t); err != nil {
+				return fmt.Errorf("Failed to decode twilio input node: %w", err)
+			}
 			input = &t
 		default:
 			return fmt.Errorf("unknown input type: %s", base.Type)
@@ -148,6 +519,18 @@ func (u *User) UnmarshalYAML(node *yaml.Node) error {
 			return fmt.Errorf("failed to decode nextcloud source: %w", err)
 		}
 		u.SpreadsheetSource = &ns
+	case SOURCE_TYPE_GOOGLE_SHEETS:
+		var gs GoogleSheetsSpreadsheetSource
+		if err := raw.SpreadsheetSource.Decode(&gs); err != nil {
+			return fmt.Errorf("failed to decode google sheets source: %w", err)
+		}
+		u.SpreadsheetSource = &gs
+	case SOURCE_TYPE_FILE:
+		var fs FileSpreadsheetSource
+		if err := raw.SpreadsheetSource.Decode(&fs); err != nil {
+			return fmt.Errorf("failed to decode file source: %w", err)
+		}
+		u.SpreadsheetSource = &fs
 	default:
 		return fmt.Errorf("unknown spreadsheet source type: %s", baseSource.Type)
 	}
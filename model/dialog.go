@@ -0,0 +1,63 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// a keyboard was sent and we are waiting for a category to be picked
+	DIALOG_STEP_CHOOSING_CATEGORY byte = iota
+	// a category was picked and we are waiting for the amount
+	DIALOG_STEP_AWAITING_AMOUNT byte = iota
+	// something can't be undone e.g. deleting a category with amounts in it, waiting for a YES
+	DIALOG_STEP_AWAITING_CONFIRMATION byte = iota
+)
+
+const (
+	DEFAULT_DIALOG_TIMEOUT time.Duration = time.Minute * 15
+)
+
+// A multi-step flow in progress for a user in a chat e.g. UPDATE, pick Groceries, send 12.50
+type Dialog struct {
+	Step   byte  `json:"step"`
+	ChatId int64 `json:"chatId"`
+	UserId int64 `json:"userId"`
+	// the command the user typed e.g. UPDATE, used in messages
+	Name     string `json:"name"`
+	Category string `json:"category,omitempty"`
+	// the command waiting on the next step e.g. the UPDATE with its category chosen
+	Command   *Command  `json:"command"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (d *Dialog) Expired(now time.Time) bool {
+	return !now.Before(d.ExpiresAt)
+}
+
+// Whether the command is the next step of this dialog rather than something new
+func (d *Dialog) Continues(commandType byte) bool {
+	switch d.Step {
+	case DIALOG_STEP_CHOOSING_CATEGORY:
+		return commandType == COMMAND_TYPE_UPDATE_CATEGORY_CHOSEN ||
+			commandType == COMMAND_TYPE_READ_CATEGORY_CHOSEN ||
+			commandType == COMMAND_TYPE_DETAILS_CATEGORY_CHOSEN ||
+			commandType == COMMAND_TYPE_REMOVE_CATEGORY_CHOSEN ||
+			commandType == COMMAND_TYPE_RENAME_CATEGORY_CHOSEN ||
+			commandType == COMMAND_TYPE_DELETE_CATEGORY_CHOSEN
+	case DIALOG_STEP_AWAITING_AMOUNT:
+		return commandType == COMMAND_TYPE_NUMERICAL_AMOUNT
+	case DIALOG_STEP_AWAITING_CONFIRMATION:
+		return commandType == COMMAND_TYPE_CONFIRM
+	default:
+		return false
+	}
+}
+
+// e.g. UPDATE for Groceries
+func (d *Dialog) Describe() string {
+	if len(d.Category) == 0 {
+		return d.Name
+	}
+	return fmt.Sprintf("%s for %s", d.Name, d.Category)
+}
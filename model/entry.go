@@ -3,4 +3,11 @@ package model
 type Entry struct {
 	Category string
 	Value    string
+	Earning  bool
+	// the value as a number, 0 when it isn't one
+	Amount float64
+	// nil when the category has no budget
+	Budget *float64
+	// the sheet's grand total for costs or earnings rather than a category
+	Total bool
 }
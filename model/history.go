@@ -0,0 +1,75 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// What a cell held at a point in time, the formula if it has one otherwise the value
+type CellState struct {
+	Formula string `json:"formula,omitempty"`
+	Value   string `json:"value,omitempty"`
+	// only the comments the bot wrote e.g. £19.75 was $25.00
+	Comment string `json:"comment,omitempty"`
+}
+
+type CellChange struct {
+	Sheet  string    `json:"sheet"`
+	Cell   string    `json:"cell"`
+	Before CellState `json:"before"`
+	After  CellState `json:"after"`
+	// the journal row written with the change, or the one deleted with it when JournalRemoved
+	JournalRow     []string `json:"journalRow,omitempty"`
+	JournalRemoved bool     `json:"journalRemoved,omitempty"`
+}
+
+// A change made to a spreadsheet through the bot, kept so it can be undone
+type Mutation struct {
+	// unique so the ones undone are removed from the history rather than whatever is newest
+	Id       string `json:"id,omitempty"`
+	UserId   int64  `json:"userId"`
+	UserName string `json:"userName"`
+	Category string `json:"category"`
+	Earning  bool   `json:"earning,omitempty"`
+	// e.g. Added £12.50 to Groceries
+	Description string     `json:"description"`
+	Change      CellChange `json:"change"`
+	Timestamp   time.Time  `json:"timestamp"`
+}
+
+func NewMutationId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Whether both are the same change, history kept before ids were added is matched on when and what
+func (m *Mutation) Is(other *Mutation) bool {
+	if len(m.Id) > 0 || len(other.Id) > 0 {
+		return m.Id == other.Id
+	}
+	return m.Timestamp.Equal(other.Timestamp) && m.Description == other.Description && m.UserId == other.UserId
+}
+
+// The history without the given mutations
+func WithoutMutations(history []Mutation, remove []Mutation) []Mutation {
+	kept := []Mutation{}
+	for i := range history {
+		if !containsMutation(remove, &history[i]) {
+			kept = append(kept, history[i])
+		}
+	}
+	return kept
+}
+
+// private
+
+func containsMutation(mutations []Mutation, mutation *Mutation) bool {
+	for i := range mutations {
+		if mutations[i].Is(mutation) {
+			return true
+		}
+	}
+	return false
+}
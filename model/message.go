@@ -6,10 +6,91 @@ import tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 type Message struct {
 	UserName        string
 	TelegramMessage *TelegramMessage
+	TwilioMessage   *TwilioMessage
 }
 
 type TelegramMessage struct {
 	Update *tgbotapi.Update
 	Bot    *tgbotapi.BotAPI
-	UserId int64
+	// who can use the bot by user id
+	Members map[int64]TelegramMember
+}
+
+// The telegram user who sent the message or pressed the button
+func (t *TelegramMessage) SenderId() int64 {
+	switch {
+	case t.Update == nil:
+		return 0
+	case t.Update.CallbackQuery != nil:
+		return t.Update.CallbackQuery.From.ID
+	case t.Update.Message != nil && t.Update.Message.From != nil:
+		return t.Update.Message.From.ID
+	default:
+		return 0
+	}
+}
+
+// The chat the message was sent in or the button was pressed in, a group chat or the one with the bot
+func (t *TelegramMessage) Chat() *tgbotapi.Chat {
+	switch {
+	case t.Update == nil:
+		return nil
+	case t.Update.CallbackQuery != nil && t.Update.CallbackQuery.Message != nil:
+		return t.Update.CallbackQuery.Message.Chat
+	case t.Update.Message != nil:
+		return t.Update.Message.Chat
+	default:
+		return nil
+	}
+}
+
+// A WhatsApp or SMS message from a Twilio webhook
+type TwilioMessage struct {
+	// e.g. whatsapp:+447700900123, replies go back to it from the number it was sent to
+	From string
+	To   string
+	Body string
+	// the sender's number as a number, used for the chat and user ids
+	Id int64
+}
+
+// Which input the message came from e.g. telegram
+func (m *Message) GetInputType() string {
+	switch {
+	case m.TelegramMessage != nil:
+		return INPUT_TYPE_TELEGRAM
+	case m.TwilioMessage != nil:
+		return INPUT_TYPE_TWILIO
+	default:
+		return ""
+	}
+}
+
+// Who changes from the message are attributed to, the member who sent it or the user
+func (m *Message) GetAuthorName() string {
+	if m.TelegramMessage != nil {
+		if member, ok := m.TelegramMessage.Members[m.TelegramMessage.SenderId()]; ok {
+			return member.Name
+		}
+	}
+	return m.UserName
+}
+
+// What the sender can do, the member's role or the user's
+func (m *Message) GetRole() string {
+	if m.TelegramMessage != nil {
+		if member, ok := m.TelegramMessage.Members[m.TelegramMessage.SenderId()]; ok {
+			return member.Role
+		}
+	}
+
+	config := GetConfig()
+	if config == nil {
+		return ROLE_ADMIN
+	}
+	user := config.GetUser(m.UserName)
+	if user == nil {
+		return ROLE_ADMIN
+	}
+	return user.GetRole()
 }
@@ -0,0 +1,37 @@
+package model
+
+const (
+	// can READ, LIST e.t.c. but change nothing
+	ROLE_READ_ONLY string = "readonly"
+	// can also add, remove and undo amounts
+	ROLE_EDITOR string = "editor"
+	// can also manage categories and start new months, the default
+	ROLE_ADMIN string = "admin"
+)
+
+var (
+	roleRanks = map[string]int{
+		ROLE_READ_ONLY: 1,
+		ROLE_EDITOR:    2,
+		ROLE_ADMIN:     3,
+	}
+)
+
+func IsRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// Whether someone with the role can use a command needing the required role, commands without one are for everyone.
+// Unknown roles can't use anything that needs a role
+func RoleAllows(role string, required string) bool {
+	if len(required) == 0 {
+		return true
+	}
+
+	rank, ok := roleRanks[role]
+	if !ok {
+		return false
+	}
+	return rank >= roleRanks[required]
+}
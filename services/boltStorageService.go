@@ -0,0 +1,182 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/model"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+// Keeps dialogs in an embedded bolt database file so they survive restarts without running valkey
+type BoltStorageService struct {
+	DB *bbolt.DB
+}
+
+const (
+	BOLT_DIALOG_BUCKET  string = "dialogs"
+	BOLT_HISTORY_BUCKET string = "history"
+)
+
+func NewBoltStorageService(path string) *BoltStorageService {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		zap.L().Panic("Failed to open bolt database", zap.String("path", path), zap.Error(err))
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range []string{BOLT_DIALOG_BUCKET, BOLT_HISTORY_BUCKET} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		zap.L().Panic("Failed to create bolt bucket", zap.String("path", path), zap.Error(err))
+	}
+
+	return &BoltStorageService{
+		DB: db,
+	}
+}
+
+func (s *BoltStorageService) StoreDialog(dialog *model.Dialog) error {
+	json, err := json.Marshal(dialog)
+	if err != nil {
+		zap.L().DPanic("Failed to serialise dialog", zap.Error(err))
+		return fmt.Errorf("Failed to serialise dialog")
+	}
+
+	if err := s.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(BOLT_DIALOG_BUCKET)).Put([]byte(dialogKey(dialog.ChatId, dialog.UserId)), json)
+	}); err != nil {
+		zap.L().Error("Failed to set dialog for user", zap.Error(err))
+		return fmt.Errorf("Failed to set dialog for user")
+	}
+
+	return nil
+}
+
+func (s *BoltStorageService) GetDialog(chatId int64, userId int64) (*model.Dialog, error) {
+	var data []byte
+	if err := s.DB.View(func(tx *bbolt.Tx) error {
+		// the value is only valid inside the transaction
+		if v := tx.Bucket([]byte(BOLT_DIALOG_BUCKET)).Get([]byte(dialogKey(chatId, userId))); v != nil {
+			data = append([]byte{}, v...)
+		}
+		return nil
+	}); err != nil {
+		zap.L().Error("Failed to get dialog for user", zap.Error(err))
+		return nil, fmt.Errorf("Failed to get dialog for user")
+	}
+
+	if data == nil {
+		return nil, &errors.StorageError{
+			Type: errors.STORAGE_ERROR_TYPE_NOT_FOUND,
+		}
+	}
+
+	var dialog model.Dialog
+	if err := json.Unmarshal(data, &dialog); err != nil {
+		zap.L().DPanic("Failed to unmarshal json dialog", zap.Error(err))
+		return nil, fmt.Errorf("Failed to unmarshal json dialog")
+	}
+
+	if forgotten(&dialog, time.Now()) {
+		s.ClearDialog(chatId, userId)
+		return nil, &errors.StorageError{
+			Type: errors.STORAGE_ERROR_TYPE_NOT_FOUND,
+		}
+	}
+
+	return &dialog, nil
+}
+
+func (s *BoltStorageService) ClearDialog(chatId int64, userId int64) error {
+	if err := s.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(BOLT_DIALOG_BUCKET)).Delete([]byte(dialogKey(chatId, userId)))
+	}); err != nil {
+		zap.L().Error("Failed to clear dialog for user", zap.Error(err))
+		return fmt.Errorf("Failed to clear dialog for user")
+	}
+
+	return nil
+}
+
+// History is kept as one json list per spreadsheet, it is capped so it stays small
+
+func (s *BoltStorageService) AddMutation(key string, mutation *model.Mutation) error {
+	if err := s.DB.Update(func(tx *bbolt.Tx) error {
+		mutations, err := getBoltMutations(tx, key)
+		if err != nil {
+			return err
+		}
+		return putBoltMutations(tx, key, limitHistory(append([]model.Mutation{*mutation}, mutations...)))
+	}); err != nil {
+		zap.L().Error("Failed to add mutation", zap.Error(err))
+		return fmt.Errorf("Failed to add mutation")
+	}
+
+	return nil
+}
+
+func (s *BoltStorageService) GetMutations(key string, count int) ([]model.Mutation, error) {
+	var mutations []model.Mutation
+	if err := s.DB.View(func(tx *bbolt.Tx) error {
+		var err error
+		mutations, err = getBoltMutations(tx, key)
+		return err
+	}); err != nil {
+		zap.L().Error("Failed to get mutations", zap.Error(err))
+		return nil, fmt.Errorf("Failed to get mutations")
+	}
+
+	if count < len(mutations) {
+		mutations = mutations[:count]
+	}
+
+	return mutations, nil
+}
+
+func (s *BoltStorageService) RemoveMutations(key string, mutations []model.Mutation) error {
+	if err := s.DB.Update(func(tx *bbolt.Tx) error {
+		history, err := getBoltMutations(tx, key)
+		if err != nil {
+			return err
+		}
+		return putBoltMutations(tx, key, model.WithoutMutations(history, mutations))
+	}); err != nil {
+		zap.L().Error("Failed to remove mutations", zap.Error(err))
+		return fmt.Errorf("Failed to remove mutations")
+	}
+
+	return nil
+}
+
+// private
+
+func getBoltMutations(tx *bbolt.Tx, key string) ([]model.Mutation, error) {
+	data := tx.Bucket([]byte(BOLT_HISTORY_BUCKET)).Get([]byte(key))
+	if data == nil {
+		return []model.Mutation{}, nil
+	}
+
+	var mutations []model.Mutation
+	if err := json.Unmarshal(data, &mutations); err != nil {
+		return nil, err
+	}
+
+	return mutations, nil
+}
+
+func putBoltMutations(tx *bbolt.Tx, key string, mutations []model.Mutation) error {
+	data, err := json.Marshal(mutations)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(BOLT_HISTORY_BUCKET)).Put([]byte(key), data)
+}
@@ -4,18 +4,27 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
+	e "telegram-spreadsheet-editor/errors"
 	"telegram-spreadsheet-editor/model"
 	"telegram-spreadsheet-editor/utils"
 
 	"go.uber.org/zap"
 )
 
+// GetSpreadsheet also returns the version of the sheet (e.g. an ETag) which can be passed to WriteSpreadsheet
+// to only write if it has not changed since, in which case a DataError conflict is returned. An empty version always writes.
 type IDataService interface {
-	GetSpreadsheet(source model.SpreadsheetSource) (io.Reader, error)
-	WriteSpreadsheet(source model.SpreadsheetSource, sheet io.Reader) error
+	GetSpreadsheet(source model.SpreadsheetSource) (io.Reader, string, error)
+	WriteSpreadsheet(source model.SpreadsheetSource, sheet io.Reader, version string) error
+}
+
+// Picks the data service to use based on the source type
+type SourceDataService struct {
+	Services map[string]IDataService
 }
 
 type NCDataService struct {
@@ -29,21 +38,39 @@ const (
 	PASSWORD_KEY       string = "BASIC_AUTH_PASSWORD"
 )
 
-func (s *NCDataService) GetSpreadsheet(source model.SpreadsheetSource) (io.Reader, error) {
+func (s *SourceDataService) GetSpreadsheet(source model.SpreadsheetSource) (io.Reader, string, error) {
+	service, err := s.getService(source)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return service.GetSpreadsheet(source)
+}
+
+func (s *SourceDataService) WriteSpreadsheet(source model.SpreadsheetSource, sheet io.Reader, version string) error {
+	service, err := s.getService(source)
+	if err != nil {
+		return err
+	}
+
+	return service.WriteSpreadsheet(source, sheet, version)
+}
+
+func (s *NCDataService) GetSpreadsheet(source model.SpreadsheetSource) (io.Reader, string, error) {
 	ncSource, err := getSource(source)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	fileUrl, err := getFileUrl(ncSource)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	password, exists := os.LookupEnv(ncSource.PasswordEnv)
 	if !exists {
 		zap.L().Error("Expected to find nextcloud user password", zap.String("var", ncSource.PasswordEnv))
-		return nil, fmt.Errorf("Expected to find nextcloud user password")
+		return nil, "", fmt.Errorf("Expected to find nextcloud user password")
 	}
 
 	opts := utils.HttpOptions{}
@@ -56,19 +83,25 @@ func (s *NCDataService) GetSpreadsheet(source model.SpreadsheetSource) (io.Reade
 	var responseString string
 	response, err := s.Http.Get(fileUrl, &responseString, &opts)
 	if err != nil {
-		zap.L().Error("Failed to download file", zap.Int("response", response.StatusCode), zap.Error(err))
-		return nil, fmt.Errorf("Failed to download file")
+		zap.L().Error("Failed to download file", zap.Error(err))
+		return nil, "", fmt.Errorf("Failed to download file")
 	}
 
 	if response.StatusCode != 200 {
 		zap.L().Error("Non 200 response code", zap.Int("response", response.StatusCode))
-		return nil, fmt.Errorf("Non 200 response code")
+		return nil, "", fmt.Errorf("Non 200 response code")
+	}
+
+	// nextcloud always sends an etag but don't fail if something in between strips it
+	version := ""
+	if response.ETag != nil {
+		version = *response.ETag
 	}
 
-	return bytes.NewReader(*response.Body), nil
+	return bytes.NewReader(*response.Body), version, nil
 }
 
-func (s *NCDataService) WriteSpreadsheet(source model.SpreadsheetSource, sheet io.Reader) error {
+func (s *NCDataService) WriteSpreadsheet(source model.SpreadsheetSource, sheet io.Reader, version string) error {
 	ncSource, err := getSource(source)
 	if err != nil {
 		return err
@@ -100,18 +133,52 @@ func (s *NCDataService) WriteSpreadsheet(source model.SpreadsheetSource, sheet i
 		opts.BasicAuthPassword = &password
 	}
 
+	// only overwrite the version we downloaded
+	if len(version) > 0 {
+		opts.Headers = &map[string]string{
+			"If-Match": version,
+		}
+	}
+
 	buffer := bytes.NewBuffer(b)
 	response, err := s.Http.Put(fileUrl, buffer, nil, &opts)
 	if err != nil {
-		zap.L().Error("Failed to upload file", zap.Int("response", response.StatusCode), zap.Error(err))
+		zap.L().Error("Failed to upload file", zap.Error(err))
 		return fmt.Errorf("Failed to upload file")
 	}
 
+	if response.StatusCode == http.StatusPreconditionFailed {
+		zap.L().Warn("File changed since it was downloaded", zap.String("etag", version))
+		return &e.DataError{
+			Type: e.DATA_ERROR_TYPE_CONFLICT,
+		}
+	}
+
+	if response.StatusCode > 299 {
+		zap.L().Error("Non 2xx response code uploading file", zap.Int("response", response.StatusCode))
+		return fmt.Errorf("Non 2xx response code")
+	}
+
 	return nil
 }
 
 // private
 
+func (s *SourceDataService) getService(source model.SpreadsheetSource) (IDataService, error) {
+	if source == nil {
+		zap.L().DPanic("Spreadsheet source is nil.")
+		return nil, fmt.Errorf("Spreadsheet source is nil")
+	}
+
+	service, ok := s.Services[source.GetType()]
+	if !ok {
+		zap.L().Error("No data service for spreadsheet source.", zap.String("type", source.GetType()))
+		return nil, fmt.Errorf("No data service for spreadsheet source %s", source.GetType())
+	}
+
+	return service, nil
+}
+
 func getSource(source model.SpreadsheetSource) (*model.NextcloudSpreadsheetSource, error) {
 	if source == nil {
 		zap.L().DPanic("Spreadsheet source is nil.")
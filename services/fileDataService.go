@@ -0,0 +1,141 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	e "telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/utils"
+
+	"go.uber.org/zap"
+)
+
+// Reads and writes an xlsx on local disk e.g. a synced folder or a mounted volume
+type FileDataService struct{}
+
+func (s *FileDataService) GetSpreadsheet(source model.SpreadsheetSource) (io.Reader, string, error) {
+	fSource, err := getFileSource(source)
+	if err != nil {
+		return nil, "", err
+	}
+
+	lock, err := utils.LockFile(fSource.FilePath, false)
+	if err != nil {
+		zap.L().Error("Failed to lock spreadsheet file", zap.String("path", fSource.FilePath), zap.Error(err))
+		return nil, "", fmt.Errorf("Failed to lock spreadsheet file")
+	}
+	defer lock.Unlock()
+
+	b, err := os.ReadFile(fSource.FilePath)
+	if err != nil {
+		zap.L().Error("Failed to read spreadsheet file", zap.String("path", fSource.FilePath), zap.Error(err))
+		return nil, "", fmt.Errorf("Failed to read spreadsheet file")
+	}
+
+	version, err := getFileVersion(fSource.FilePath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return bytes.NewReader(b), version, nil
+}
+
+func (s *FileDataService) WriteSpreadsheet(source model.SpreadsheetSource, sheet io.Reader, version string) error {
+	fSource, err := getFileSource(source)
+	if err != nil {
+		return err
+	}
+
+	lock, err := utils.LockFile(fSource.FilePath, true)
+	if err != nil {
+		zap.L().Error("Failed to lock spreadsheet file", zap.String("path", fSource.FilePath), zap.Error(err))
+		return fmt.Errorf("Failed to lock spreadsheet file")
+	}
+	defer lock.Unlock()
+
+	// the file may have been changed by a sync since it was read
+	if len(version) > 0 {
+		current, err := getFileVersion(fSource.FilePath)
+		if err != nil {
+			return err
+		}
+		if current != version {
+			zap.L().Warn("File changed since it was read", zap.String("path", fSource.FilePath))
+			return &e.DataError{
+				Type: e.DATA_ERROR_TYPE_CONFLICT,
+			}
+		}
+	}
+
+	// write to a temp file in the same directory and rename over the original so readers never see half a file
+	dir := filepath.Dir(fSource.FilePath)
+	tmp, err := os.CreateTemp(dir, fmt.Sprintf(".%s-*.tmp", filepath.Base(fSource.FilePath)))
+	if err != nil {
+		zap.L().Error("Failed to create temp file", zap.String("dir", dir), zap.Error(err))
+		return fmt.Errorf("Failed to create temp file")
+	}
+
+	// no-op once renamed
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, sheet); err != nil {
+		tmp.Close()
+		zap.L().Error("Failed to write temp file", zap.String("path", tmp.Name()), zap.Error(err))
+		return fmt.Errorf("Failed to write temp file")
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		zap.L().Error("Failed to sync temp file", zap.String("path", tmp.Name()), zap.Error(err))
+		return fmt.Errorf("Failed to sync temp file")
+	}
+
+	if err := tmp.Close(); err != nil {
+		zap.L().Error("Failed to close temp file", zap.String("path", tmp.Name()), zap.Error(err))
+		return fmt.Errorf("Failed to close temp file")
+	}
+
+	// keep the permissions of the original file
+	if info, err := os.Stat(fSource.FilePath); err == nil {
+		if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+			zap.L().Warn("Failed to copy file permissions", zap.Error(err))
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), fSource.FilePath); err != nil {
+		zap.L().Error("Failed to replace spreadsheet file", zap.String("path", fSource.FilePath), zap.Error(err))
+		return fmt.Errorf("Failed to replace spreadsheet file")
+	}
+
+	return nil
+}
+
+// private
+
+// modification time and size is good enough to spot another writer
+func getFileVersion(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		zap.L().Error("Failed to stat spreadsheet file", zap.String("path", path), zap.Error(err))
+		return "", fmt.Errorf("Failed to stat spreadsheet file")
+	}
+
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()), nil
+}
+
+func getFileSource(source model.SpreadsheetSource) (*model.FileSpreadsheetSource, error) {
+	if source == nil {
+		zap.L().DPanic("Spreadsheet source is nil.")
+		return nil, fmt.Errorf("Spreadsheet source is nil")
+	}
+	fSource, ok := source.(*model.FileSpreadsheetSource)
+	if !ok {
+		zap.L().Error("Expected file spreadsheet source.", zap.String("type", source.GetType()))
+		return nil, fmt.Errorf("Expected file spreadsheet source")
+	}
+
+	return fSource, nil
+}
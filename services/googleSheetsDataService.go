@@ -0,0 +1,948 @@
+package services
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/utils"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/zap"
+)
+
+// Google sheets has no xlsx download/upload that keeps the file intact so this service builds an in memory
+// workbook from the sheet values (with formulas) and on write pushes the configured columns back that changed.
+// Notes on the configured columns are read as comments and written back the same way.
+type GoogleSheetsDataService struct {
+	Http utils.IHttpClient
+
+	mu     sync.Mutex
+	tokens map[string]googleToken
+}
+
+const (
+	GOOGLE_SHEETS_BASE_URL string = "https://sheets.googleapis.com"
+	GOOGLE_SHEETS_SCOPE    string = "https://www.googleapis.com/auth/spreadsheets"
+	GOOGLE_TOKEN_URL       string = "https://oauth2.googleapis.com/token"
+)
+
+type googleCredentials struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenUri    string `json:"token_uri"`
+}
+
+type googleToken struct {
+	AccessToken string
+	Expires     time.Time
+}
+
+type googleTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type googleSheetProperties struct {
+	SheetId int64  `json:"sheetId"`
+	Title   string `json:"title"`
+	Index   int    `json:"index"`
+}
+
+type googleSpreadsheet struct {
+	Sheets []struct {
+		Properties googleSheetProperties `json:"properties"`
+		Data       []googleGridData      `json:"data"`
+	} `json:"sheets"`
+}
+
+// an empty note clears it
+type googleCellData struct {
+	Note string `json:"note,omitempty"`
+}
+
+type googleRowData struct {
+	Values []googleCellData `json:"values"`
+}
+
+type googleGridData struct {
+	StartRow    int             `json:"startRow"`
+	StartColumn int             `json:"startColumn"`
+	RowData     []googleRowData `json:"rowData"`
+}
+
+type googleGridRange struct {
+	SheetId          int64 `json:"sheetId"`
+	StartRowIndex    int   `json:"startRowIndex"`
+	EndRowIndex      int   `json:"endRowIndex"`
+	StartColumnIndex int   `json:"startColumnIndex"`
+	EndColumnIndex   int   `json:"endColumnIndex"`
+}
+
+type googleUpdateCells struct {
+	Rows   []googleRowData `json:"rows"`
+	Fields string          `json:"fields"`
+	Range  googleGridRange `json:"range"`
+}
+
+type googleValueRange struct {
+	Range          string  `json:"range"`
+	MajorDimension string  `json:"majorDimension,omitempty"`
+	Values         [][]any `json:"values"`
+}
+
+type googleBatchGetResponse struct {
+	ValueRanges []googleValueRange `json:"valueRanges"`
+}
+
+type googleBatchUpdateValuesRequest struct {
+	ValueInputOption string             `json:"valueInputOption"`
+	Data             []googleValueRange `json:"data"`
+}
+
+type googleDuplicateSheet struct {
+	SourceSheetId    int64  `json:"sourceSheetId"`
+	InsertSheetIndex int    `json:"insertSheetIndex"`
+	NewSheetName     string `json:"newSheetName"`
+}
+
+// no sheet id so google picks one
+type googleNewSheetProperties struct {
+	Title string `json:"title"`
+	Index int    `json:"index"`
+}
+
+type googleAddSheet struct {
+	Properties googleNewSheetProperties `json:"properties"`
+}
+
+type googleSheetRequest struct {
+	DuplicateSheet *googleDuplicateSheet `json:"duplicateSheet,omitempty"`
+	AddSheet       *googleAddSheet       `json:"addSheet,omitempty"`
+	UpdateCells    *googleUpdateCells    `json:"updateCells,omitempty"`
+}
+
+type googleBatchUpdateRequest struct {
+	Requests []googleSheetRequest `json:"requests"`
+}
+
+func (s *GoogleSheetsDataService) GetSpreadsheet(source model.SpreadsheetSource) (io.Reader, string, error) {
+	gsSource, err := getGoogleSheetsSource(source)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.getAccessToken(gsSource)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sheets, err := s.getSheets(gsSource, token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	titles := make([]string, len(sheets))
+	for i, sh := range sheets {
+		titles[i] = sh.Title
+	}
+
+	valueRanges, err := s.getValues(gsSource, token, titles, "ROWS")
+	if err != nil {
+		return nil, "", err
+	}
+
+	notes, err := s.getNotes(gsSource, token, noteRanges(gsSource, titles))
+	if err != nil {
+		return nil, "", err
+	}
+
+	f := excelize.NewFile()
+	defer func() {
+		if err := f.Close(); err != nil {
+			zap.L().Error("Failed to close spreadsheet", zap.Error(err))
+		}
+	}()
+
+	for i, title := range titles {
+		if i == 0 {
+			// a new file always has a default sheet so reuse it
+			if err := f.SetSheetName(f.GetSheetName(0), title); err != nil {
+				zap.L().Error("Failed to rename sheet", zap.String("title", title), zap.Error(err))
+				return nil, "", fmt.Errorf("Failed to rename sheet")
+			}
+		} else if _, err := f.NewSheet(title); err != nil {
+			zap.L().Error("Failed to create sheet", zap.String("title", title), zap.Error(err))
+			return nil, "", fmt.Errorf("Failed to create sheet")
+		}
+
+		if i >= len(valueRanges) {
+			continue
+		}
+
+		for r, row := range valueRanges[i].Values {
+			for c, value := range row {
+				cell, err := excelize.CoordinatesToCellName(c+1, r+1)
+				if err != nil {
+					return nil, "", err
+				}
+				if err := setCellFromGoogleValue(f, title, cell, value); err != nil {
+					zap.L().Error("Failed to set cell from google value", zap.String("cell", cell), zap.Error(err))
+					return nil, "", fmt.Errorf("Failed to set cell value")
+				}
+			}
+		}
+
+		// google doesn't say who wrote a note so they are left without an author like anyone else's comments
+		for cell, note := range notes[title] {
+			if err := f.AddComment(title, excelize.Comment{Cell: cell, Text: note}); err != nil {
+				zap.L().Error("Failed to add comment from google note", zap.String("cell", cell), zap.Error(err))
+				return nil, "", fmt.Errorf("Failed to add comment")
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := f.Write(&buffer); err != nil {
+		zap.L().Error("Failed to write spreadsheet to buffer", zap.Error(err))
+		return nil, "", fmt.Errorf("Failed to write spreadsheet")
+	}
+
+	return bytes.NewReader(buffer.Bytes()), "", nil
+}
+
+// Only the changed columns and their notes are written. Google has no cheap equivalent of an etag for values so there is
+// no version and no optimistic concurrency, an edit made in google since the read is lost in any column the bot changes
+func (s *GoogleSheetsDataService) WriteSpreadsheet(source model.SpreadsheetSource, sheet io.Reader, version string) error {
+	gsSource, err := getGoogleSheetsSource(source)
+	if err != nil {
+		return err
+	}
+
+	f, err := excelize.OpenReader(sheet, excelize.Options{})
+	if err != nil {
+		zap.L().Error("Failed to open spreadsheet", zap.Error(err))
+		return fmt.Errorf("Failed to open spreadsheet")
+	}
+
+	defer func() {
+		if err := f.Close(); err != nil {
+			zap.L().Error("Failed to close spreadsheet", zap.Error(err))
+		}
+	}()
+
+	token, err := s.getAccessToken(gsSource)
+	if err != nil {
+		return err
+	}
+
+	sheets, err := s.getSheets(gsSource, token)
+	if err != nil {
+		return err
+	}
+
+	// any new sheets (e.g. NEW MONTH) are duplicated from the last remote sheet so formatting is kept
+	if err := s.addMissingSheets(gsSource, token, f, sheets); err != nil {
+		return err
+	}
+
+	columns := syncedColumns(gsSource)
+
+	ranges := []string{}
+	local := [][]string{}
+	for _, title := range f.GetSheetList() {
+		sheetColumns := columns
+		if title == gsSource.JournalSheet {
+			sheetColumns = journalColumns()
+		}
+		for _, column := range sheetColumns {
+			values, err := getLocalColumn(f, title, column)
+			if err != nil {
+				return err
+			}
+			ranges = append(ranges, googleRange(title, column))
+			local = append(local, values)
+		}
+	}
+
+	remoteRanges, err := s.getRanges(gsSource, token, ranges, "COLUMNS")
+	if err != nil {
+		return err
+	}
+
+	// only send the columns that have changed so we don't clobber anything else
+	update := googleBatchUpdateValuesRequest{
+		ValueInputOption: "USER_ENTERED",
+		Data:             []googleValueRange{},
+	}
+	for i, r := range ranges {
+		remote := []string{}
+		if i < len(remoteRanges) && len(remoteRanges[i].Values) > 0 {
+			for _, v := range remoteRanges[i].Values[0] {
+				remote = append(remote, googleValueToString(v))
+			}
+		}
+
+		if columnsEqual(local[i], remote) {
+			continue
+		}
+
+		// pad so that removed values are cleared
+		values := make([]any, max(len(local[i]), len(remote)))
+		for j := range values {
+			values[j] = ""
+			if j < len(local[i]) {
+				values[j] = local[i][j]
+			}
+		}
+
+		update.Data = append(update.Data, googleValueRange{
+			Range:          r,
+			MajorDimension: "COLUMNS",
+			Values:         [][]any{values},
+		})
+	}
+
+	notes, err := s.noteUpdates(gsSource, token, f, sheets)
+	if err != nil {
+		return err
+	}
+
+	if len(update.Data) == 0 && len(notes.Requests) == 0 {
+		zap.L().Info("No changes to write to google sheets")
+		return nil
+	}
+
+	if len(update.Data) > 0 {
+		if err := s.updateValues(gsSource, token, update); err != nil {
+			return err
+		}
+	}
+
+	if len(notes.Requests) > 0 {
+		return s.batchUpdate(gsSource, token, notes)
+	}
+
+	return nil
+}
+
+// private
+
+func (s *GoogleSheetsDataService) updateValues(gsSource *model.GoogleSheetsSpreadsheetSource, token string, update googleBatchUpdateValuesRequest) error {
+	body, err := json.Marshal(update)
+	if err != nil {
+		zap.L().DPanic("Failed to serialise values update", zap.Error(err))
+		return fmt.Errorf("Failed to serialise values update")
+	}
+
+	var response map[string]any
+	res, err := s.Http.Post(
+		fmt.Sprintf("%s/v4/spreadsheets/%s/values:batchUpdate", getGoogleBaseUrl(gsSource), url.PathEscape(gsSource.SpreadsheetId)),
+		bytes.NewBuffer(body),
+		&response,
+		&utils.HttpOptions{BearerToken: &token, ContentType: "application/json"},
+	)
+	if err != nil {
+		zap.L().Error("Failed to update google sheet values", zap.Error(err))
+		return fmt.Errorf("Failed to update google sheet values")
+	}
+
+	if res.StatusCode != 200 {
+		zap.L().Error("Non 200 response code updating google sheet values", zap.Int("response", res.StatusCode))
+		return fmt.Errorf("Non 200 response code")
+	}
+
+	return nil
+}
+
+func getGoogleSheetsSource(source model.SpreadsheetSource) (*model.GoogleSheetsSpreadsheetSource, error) {
+	if source == nil {
+		zap.L().DPanic("Spreadsheet source is nil.")
+		return nil, fmt.Errorf("Spreadsheet source is nil")
+	}
+	gsSource, ok := source.(*model.GoogleSheetsSpreadsheetSource)
+	if !ok {
+		zap.L().Error("Expected google sheets spreadsheet source.", zap.String("type", source.GetType()))
+		return nil, fmt.Errorf("Expected google sheets spreadsheet source")
+	}
+
+	return gsSource, nil
+}
+
+func getGoogleBaseUrl(source *model.GoogleSheetsSpreadsheetSource) string {
+	if len(source.BaseUrl) > 0 {
+		return strings.TrimSuffix(source.BaseUrl, "/")
+	}
+	return GOOGLE_SHEETS_BASE_URL
+}
+
+func loadGoogleCredentials(source *model.GoogleSheetsSpreadsheetSource) (*googleCredentials, error) {
+	var data []byte
+	switch {
+	case len(source.CredentialsEnv) > 0:
+		value, exists := os.LookupEnv(source.CredentialsEnv)
+		if !exists {
+			zap.L().Error("Expected to find google credentials", zap.String("var", source.CredentialsEnv))
+			return nil, fmt.Errorf("Expected to find google credentials")
+		}
+		data = []byte(value)
+	case len(source.CredentialsFile) > 0:
+		b, err := os.ReadFile(source.CredentialsFile)
+		if err != nil {
+			zap.L().Error("Failed to read google credentials file", zap.String("file", source.CredentialsFile), zap.Error(err))
+			return nil, fmt.Errorf("Failed to read google credentials file")
+		}
+		data = b
+	default:
+		zap.L().Error("No google credentials configured")
+		return nil, fmt.Errorf("No google credentials configured")
+	}
+
+	var creds googleCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		zap.L().Error("Failed to parse google credentials", zap.Error(err))
+		return nil, fmt.Errorf("Failed to parse google credentials")
+	}
+
+	if len(creds.TokenUri) == 0 {
+		creds.TokenUri = GOOGLE_TOKEN_URL
+	}
+
+	return &creds, nil
+}
+
+func (s *GoogleSheetsDataService) getAccessToken(source *model.GoogleSheetsSpreadsheetSource) (string, error) {
+	creds, err := loadGoogleCredentials(source)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tokens == nil {
+		s.tokens = map[string]googleToken{}
+	}
+
+	// refresh a minute early so a token doesn't expire mid request
+	if t, ok := s.tokens[creds.ClientEmail]; ok && time.Now().Add(time.Minute).Before(t.Expires) {
+		return t.AccessToken, nil
+	}
+
+	assertion, err := signGoogleJwt(creds, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+
+	var tokenResponse googleTokenResponse
+	res, err := s.Http.Post(creds.TokenUri, bytes.NewBufferString(form.Encode()), &tokenResponse, &utils.HttpOptions{
+		ContentType: "application/x-www-form-urlencoded",
+	})
+	if err != nil {
+		zap.L().Error("Failed to get google access token", zap.Error(err))
+		return "", fmt.Errorf("Failed to get google access token")
+	}
+
+	if res.StatusCode != 200 || len(tokenResponse.AccessToken) == 0 {
+		zap.L().Error("Google token request failed", zap.Int("response", res.StatusCode))
+		return "", fmt.Errorf("Google token request failed")
+	}
+
+	s.tokens[creds.ClientEmail] = googleToken{
+		AccessToken: tokenResponse.AccessToken,
+		Expires:     time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second),
+	}
+
+	return tokenResponse.AccessToken, nil
+}
+
+func signGoogleJwt(creds *googleCredentials, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		zap.L().Error("Failed to decode google private key pem")
+		return "", fmt.Errorf("Failed to decode google private key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		zap.L().Error("Failed to parse google private key", zap.Error(err))
+		return "", fmt.Errorf("Failed to parse google private key")
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		zap.L().Error("Google private key is not an RSA key")
+		return "", fmt.Errorf("Google private key is not an RSA key")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   creds.ClientEmail,
+		"scope": GOOGLE_SHEETS_SCOPE,
+		"aud":   creds.TokenUri,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, hash[:])
+	if err != nil {
+		zap.L().Error("Failed to sign google jwt", zap.Error(err))
+		return "", fmt.Errorf("Failed to sign google jwt")
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (s *GoogleSheetsDataService) getSheets(source *model.GoogleSheetsSpreadsheetSource, token string) ([]googleSheetProperties, error) {
+	var spreadsheet googleSpreadsheet
+	res, err := s.Http.Get(
+		fmt.Sprintf("%s/v4/spreadsheets/%s?fields=sheets.properties", getGoogleBaseUrl(source), url.PathEscape(source.SpreadsheetId)),
+		&spreadsheet,
+		&utils.HttpOptions{BearerToken: &token},
+	)
+	if err != nil {
+		zap.L().Error("Failed to get google spreadsheet", zap.Error(err))
+		return nil, fmt.Errorf("Failed to get google spreadsheet")
+	}
+
+	if res.StatusCode != 200 {
+		zap.L().Error("Non 200 response code getting google spreadsheet", zap.Int("response", res.StatusCode))
+		return nil, fmt.Errorf("Non 200 response code")
+	}
+
+	sheets := make([]googleSheetProperties, len(spreadsheet.Sheets))
+	for _, sh := range spreadsheet.Sheets {
+		if sh.Properties.Index < 0 || sh.Properties.Index >= len(sheets) {
+			zap.L().Error("Unexpected google sheet index", zap.Int("index", sh.Properties.Index))
+			return nil, fmt.Errorf("Unexpected google sheet index")
+		}
+		sheets[sh.Properties.Index] = sh.Properties
+	}
+
+	return sheets, nil
+}
+
+func (s *GoogleSheetsDataService) getValues(source *model.GoogleSheetsSpreadsheetSource, token string, titles []string, dimension string) ([]googleValueRange, error) {
+	ranges := make([]string, len(titles))
+	for i, t := range titles {
+		ranges[i] = quoteGoogleSheetTitle(t)
+	}
+
+	return s.getRanges(source, token, ranges, dimension)
+}
+
+func (s *GoogleSheetsDataService) getRanges(source *model.GoogleSheetsSpreadsheetSource, token string, ranges []string, dimension string) ([]googleValueRange, error) {
+	query := url.Values{}
+	for _, r := range ranges {
+		query.Add("ranges", r)
+	}
+	query.Set("valueRenderOption", "FORMULA")
+	query.Set("majorDimension", dimension)
+
+	var response googleBatchGetResponse
+	res, err := s.Http.Get(
+		fmt.Sprintf("%s/v4/spreadsheets/%s/values:batchGet?%s", getGoogleBaseUrl(source), url.PathEscape(source.SpreadsheetId), query.Encode()),
+		&response,
+		&utils.HttpOptions{BearerToken: &token},
+	)
+	if err != nil {
+		zap.L().Error("Failed to get google sheet values", zap.Error(err))
+		return nil, fmt.Errorf("Failed to get google sheet values")
+	}
+
+	if res.StatusCode != 200 {
+		zap.L().Error("Non 200 response code getting google sheet values", zap.Int("response", res.StatusCode))
+		return nil, fmt.Errorf("Non 200 response code")
+	}
+
+	return response.ValueRanges, nil
+}
+
+func (s *GoogleSheetsDataService) addMissingSheets(source *model.GoogleSheetsSpreadsheetSource, token string, f *excelize.File, sheets []googleSheetProperties) error {
+	if len(sheets) == 0 {
+		return nil
+	}
+
+	existing := map[string]bool{}
+	for _, sh := range sheets {
+		existing[sh.Title] = true
+	}
+
+	// the journal isn't a month so don't copy it
+	last := sheets[len(sheets)-1]
+	for i := len(sheets) - 1; i >= 0; i-- {
+		if sheets[i].Title != source.JournalSheet {
+			last = sheets[i]
+			break
+		}
+	}
+
+	var request googleBatchUpdateRequest
+	for i, title := range f.GetSheetList() {
+		if existing[title] {
+			continue
+		}
+		if title == source.JournalSheet {
+			request.Requests = append(request.Requests, googleSheetRequest{
+				AddSheet: &googleAddSheet{
+					Properties: googleNewSheetProperties{Title: title, Index: i},
+				},
+			})
+			continue
+		}
+		request.Requests = append(request.Requests, googleSheetRequest{
+			DuplicateSheet: &googleDuplicateSheet{
+				SourceSheetId:    last.SheetId,
+				InsertSheetIndex: i,
+				NewSheetName:     title,
+			},
+		})
+	}
+
+	if len(request.Requests) == 0 {
+		return nil
+	}
+
+	return s.batchUpdate(source, token, request)
+}
+
+func (s *GoogleSheetsDataService) batchUpdate(source *model.GoogleSheetsSpreadsheetSource, token string, request googleBatchUpdateRequest) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		zap.L().DPanic("Failed to serialise sheet update", zap.Error(err))
+		return fmt.Errorf("Failed to serialise sheet update")
+	}
+
+	var response map[string]any
+	res, err := s.Http.Post(
+		fmt.Sprintf("%s/v4/spreadsheets/%s:batchUpdate", getGoogleBaseUrl(source), url.PathEscape(source.SpreadsheetId)),
+		bytes.NewBuffer(body),
+		&response,
+		&utils.HttpOptions{BearerToken: &token, ContentType: "application/json"},
+	)
+	if err != nil {
+		zap.L().Error("Failed to update google spreadsheet", zap.Error(err))
+		return fmt.Errorf("Failed to update google spreadsheet")
+	}
+
+	if res.StatusCode != 200 {
+		zap.L().Error("Non 200 response code updating google spreadsheet", zap.Int("response", res.StatusCode))
+		return fmt.Errorf("Non 200 response code")
+	}
+
+	return nil
+}
+
+// Notes for the synced columns by sheet title then cell
+func (s *GoogleSheetsDataService) getNotes(source *model.GoogleSheetsSpreadsheetSource, token string, ranges []string) (map[string]map[string]string, error) {
+	notes := map[string]map[string]string{}
+	if len(ranges) == 0 {
+		return notes, nil
+	}
+
+	query := url.Values{}
+	for _, r := range ranges {
+		query.Add("ranges", r)
+	}
+	query.Set("fields", "sheets(properties(title),data(startRow,startColumn,rowData(values(note))))")
+
+	var spreadsheet googleSpreadsheet
+	res, err := s.Http.Get(
+		fmt.Sprintf("%s/v4/spreadsheets/%s?%s", getGoogleBaseUrl(source), url.PathEscape(source.SpreadsheetId), query.Encode()),
+		&spreadsheet,
+		&utils.HttpOptions{BearerToken: &token},
+	)
+	if err != nil {
+		zap.L().Error("Failed to get google sheet notes", zap.Error(err))
+		return nil, fmt.Errorf("Failed to get google sheet notes")
+	}
+
+	if res.StatusCode != 200 {
+		zap.L().Error("Non 200 response code getting google sheet notes", zap.Int("response", res.StatusCode))
+		return nil, fmt.Errorf("Non 200 response code")
+	}
+
+	for _, sh := range spreadsheet.Sheets {
+		for _, data := range sh.Data {
+			for r, row := range data.RowData {
+				for c, value := range row.Values {
+					if len(value.Note) == 0 {
+						continue
+					}
+					cell, err := excelize.CoordinatesToCellName(data.StartColumn+c+1, data.StartRow+r+1)
+					if err != nil {
+						return nil, err
+					}
+					if notes[sh.Properties.Title] == nil {
+						notes[sh.Properties.Title] = map[string]string{}
+					}
+					notes[sh.Properties.Title][cell] = value.Note
+				}
+			}
+		}
+	}
+
+	return notes, nil
+}
+
+// Requests setting the notes of every synced column whose comments differ from google's, a whole column at a time
+// so moved categories take their notes with them
+func (s *GoogleSheetsDataService) noteUpdates(source *model.GoogleSheetsSpreadsheetSource, token string, f *excelize.File, sheets []googleSheetProperties) (googleBatchUpdateRequest, error) {
+	var request googleBatchUpdateRequest
+
+	titles := f.GetSheetList()
+	remote, err := s.getNotes(source, token, noteRanges(source, titles))
+	if err != nil {
+		return request, err
+	}
+
+	for _, title := range titles {
+		if title == source.JournalSheet {
+			continue
+		}
+
+		comments, err := f.GetComments(title)
+		if err != nil {
+			zap.L().Error("Failed to get comments", zap.String("sheet", title), zap.Error(err))
+			return request, fmt.Errorf("Failed to get comments")
+		}
+		local := map[string]string{}
+		for _, c := range comments {
+			local[c.Cell] = commentText(c)
+		}
+
+		for _, column := range syncedColumns(source) {
+			localNotes, err := columnNotes(local, column)
+			if err != nil {
+				return request, err
+			}
+			remoteNotes, err := columnNotes(remote[title], column)
+			if err != nil {
+				return request, err
+			}
+			if maps.Equal(localNotes, remoteNotes) {
+				continue
+			}
+
+			// sheets added by this write only have an id once they exist
+			sheetId, ok := googleSheetId(sheets, title)
+			if !ok {
+				if sheets, err = s.getSheets(source, token); err != nil {
+					return request, err
+				}
+				if sheetId, ok = googleSheetId(sheets, title); !ok {
+					zap.L().Error("Google sheet not found", zap.String("title", title))
+					return request, fmt.Errorf("Google sheet not found")
+				}
+			}
+
+			rowCount := 0
+			for row := range localNotes {
+				rowCount = max(rowCount, row+1)
+			}
+			for row := range remoteNotes {
+				rowCount = max(rowCount, row+1)
+			}
+
+			// rows without a note clear whatever google has
+			rows := make([]googleRowData, rowCount)
+			for row := range rows {
+				rows[row] = googleRowData{Values: []googleCellData{{Note: localNotes[row]}}}
+			}
+
+			index, err := excelize.ColumnNameToNumber(column)
+			if err != nil {
+				zap.L().Error("Invalid column", zap.String("column", column), zap.Error(err))
+				return request, fmt.Errorf("Invalid column")
+			}
+
+			request.Requests = append(request.Requests, googleSheetRequest{
+				UpdateCells: &googleUpdateCells{
+					Rows:   rows,
+					Fields: "note",
+					Range: googleGridRange{
+						SheetId:          sheetId,
+						StartRowIndex:    0,
+						EndRowIndex:      rowCount,
+						StartColumnIndex: index - 1,
+						EndColumnIndex:   index,
+					},
+				},
+			})
+		}
+	}
+
+	return request, nil
+}
+
+func setCellFromGoogleValue(f *excelize.File, sheetName string, cell string, value any) error {
+	switch v := value.(type) {
+	case string:
+		if strings.HasPrefix(v, "=") {
+			return f.SetCellFormula(sheetName, cell, strings.TrimPrefix(v, "="))
+		}
+		if len(v) == 0 {
+			return nil
+		}
+		return f.SetCellValue(sheetName, cell, v)
+	case float64:
+		return f.SetCellValue(sheetName, cell, v)
+	case bool:
+		return f.SetCellValue(sheetName, cell, v)
+	case nil:
+		return nil
+	default:
+		return f.SetCellValue(sheetName, cell, fmt.Sprintf("%v", v))
+	}
+}
+
+func getLocalColumn(f *excelize.File, sheetName string, column string) ([]string, error) {
+	rows, err := f.GetRows(sheetName)
+	if err != nil {
+		zap.L().Error("Failed to get rows", zap.String("sheet", sheetName), zap.Error(err))
+		return nil, fmt.Errorf("Failed to get rows")
+	}
+
+	values := make([]string, len(rows))
+	for i := range rows {
+		cell := fmt.Sprintf("%s%d", column, i+1)
+		form, err := f.GetCellFormula(sheetName, cell)
+		if err != nil {
+			zap.L().Error("Failed to get cell formula", zap.String("cell", cell), zap.Error(err))
+			return nil, fmt.Errorf("Failed to get cell formula")
+		}
+
+		if len(form) > 0 {
+			values[i] = "=" + form
+			continue
+		}
+
+		val, err := f.GetCellValue(sheetName, cell, excelize.Options{RawCellValue: true})
+		if err != nil {
+			zap.L().Error("Failed to get cell value", zap.String("cell", cell), zap.Error(err))
+			return nil, fmt.Errorf("Failed to get cell value")
+		}
+		values[i] = val
+	}
+
+	return values, nil
+}
+
+// The columns kept in sync, the budget column too as deleting a category moves its budget up
+func syncedColumns(source *model.GoogleSheetsSpreadsheetSource) []string {
+	columns := []string{source.CostNameColumn, source.CostValueColumn}
+	if len(source.EarningNameColumn) > 0 && len(source.EarningsValueColumn) > 0 {
+		columns = append(columns, source.EarningNameColumn, source.EarningsValueColumn)
+	}
+	if len(source.BudgetColumn) > 0 {
+		columns = append(columns, source.BudgetColumn)
+	}
+
+	return columns
+}
+
+// The journal never has notes so it isn't asked for
+func noteRanges(source *model.GoogleSheetsSpreadsheetSource, titles []string) []string {
+	ranges := []string{}
+	for _, title := range titles {
+		if title == source.JournalSheet {
+			continue
+		}
+		for _, column := range syncedColumns(source) {
+			ranges = append(ranges, googleRange(title, column))
+		}
+	}
+
+	return ranges
+}
+
+// The notes in one column by zero based row
+func columnNotes(notes map[string]string, column string) (map[int]string, error) {
+	byRow := map[int]string{}
+	for cell, note := range notes {
+		col, row, err := excelize.SplitCellName(cell)
+		if err != nil {
+			zap.L().Error("Invalid cell", zap.String("cell", cell), zap.Error(err))
+			return nil, fmt.Errorf("Invalid cell")
+		}
+		if strings.EqualFold(col, column) && len(note) > 0 {
+			byRow[row-1] = note
+		}
+	}
+
+	return byRow, nil
+}
+
+func googleSheetId(sheets []googleSheetProperties, title string) (int64, bool) {
+	for _, sh := range sheets {
+		if sh.Title == title {
+			return sh.SheetId, true
+		}
+	}
+
+	return 0, false
+}
+
+func googleValueToString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func columnsEqual(a []string, b []string) bool {
+	trim := func(c []string) []string {
+		for len(c) > 0 && len(c[len(c)-1]) == 0 {
+			c = c[:len(c)-1]
+		}
+		return c
+	}
+
+	a = trim(a)
+	b = trim(b)
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func quoteGoogleSheetTitle(title string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(title, "'", "''"))
+}
+
+func googleRange(title string, column string) string {
+	return fmt.Sprintf("%s!%s:%s", quoteGoogleSheetTitle(title), column, column)
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"fmt"
+	"telegram-spreadsheet-editor/model"
+
+	"go.uber.org/zap"
+)
+
+// Picks the messaging service to use based on the input the message came from
+type InputMessagingService struct {
+	Services map[string]IMessagingService
+}
+
+func (s *InputMessagingService) GetCommandFromMessage(m *model.Message) (*model.Command, error) {
+	service, err := s.getService(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.GetCommandFromMessage(m)
+}
+
+func (s *InputMessagingService) SendTextMessage(m *model.Message, chatId int64, message string) error {
+	service, err := s.getService(m)
+	if err != nil {
+		return err
+	}
+
+	return service.SendTextMessage(m, chatId, message)
+}
+
+func (s *InputMessagingService) SendEntryList(m *model.Message, chatId int64, entries *[]model.Entry) error {
+	service, err := s.getService(m)
+	if err != nil {
+		return err
+	}
+
+	return service.SendEntryList(m, chatId, entries)
+}
+
+func (s *InputMessagingService) SendCategorySelectionKeyboard(m *model.Message, chatId int64, entries *[]model.Entry, command string) error {
+	service, err := s.getService(m)
+	if err != nil {
+		return err
+	}
+
+	return service.SendCategorySelectionKeyboard(m, chatId, entries, command)
+}
+
+func (s *InputMessagingService) RemoveMarkupFromMessage(m *model.Message, chatId int64, messageId int) error {
+	service, err := s.getService(m)
+	if err != nil {
+		return err
+	}
+
+	return service.RemoveMarkupFromMessage(m, chatId, messageId)
+}
+
+// private
+
+func (s *InputMessagingService) getService(m *model.Message) (IMessagingService, error) {
+	service, ok := s.Services[m.GetInputType()]
+	if !ok {
+		zap.L().Error("No messaging service for input.", zap.String("type", m.GetInputType()))
+		return nil, fmt.Errorf("No messaging service for input %s", m.GetInputType())
+	}
+
+	return service, nil
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valkey-io/valkey-go"
+	"go.uber.org/zap"
+)
+
+// Serialises read-modify-write operations on a spreadsheet. Lock blocks until the key is free and returns the unlock func.
+type ILockService interface {
+	Lock(key string) (func(), error)
+}
+
+// Locks within this process only
+type LocalLockService struct {
+	mu    sync.Mutex
+	locks map[string]*localLock
+}
+
+type localLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Locks across replicas using valkey, also locks locally so this process doesn't poll valkey against itself
+type ValkeyLockService struct {
+	Client valkey.Client
+	local  LocalLockService
+}
+
+// The ttl is how long a lock outlives a replica that died holding it, locks are renewed while held so slow writes keep them
+const (
+	LOCK_KEY_PREFIX string        = "lock:"
+	LOCK_TTL        time.Duration = time.Minute
+	LOCK_RENEW      time.Duration = LOCK_TTL / 3
+	LOCK_TIMEOUT    time.Duration = time.Second * 30
+	LOCK_RETRY      time.Duration = time.Millisecond * 100
+)
+
+const (
+	// only delete the lock if we still own it
+	unlockScript string = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+	// only extend the lock if we still own it
+	renewScript string = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+)
+
+func NewValkeyLockService(client valkey.Client) *ValkeyLockService {
+	return &ValkeyLockService{
+		Client: client,
+	}
+}
+
+func (s *LocalLockService) Lock(key string) (func(), error) {
+	s.mu.Lock()
+	if s.locks == nil {
+		s.locks = map[string]*localLock{}
+	}
+	l, ok := s.locks[key]
+	if !ok {
+		l = &localLock{}
+		s.locks[key] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		// tidy up so the map doesn't grow forever
+		s.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(s.locks, key)
+		}
+		s.mu.Unlock()
+	}, nil
+}
+
+func (s *ValkeyLockService) Lock(key string) (func(), error) {
+	unlockLocal, _ := s.local.Lock(key)
+
+	token, err := newLockToken()
+	if err != nil {
+		unlockLocal()
+		return nil, err
+	}
+
+	lockKey := LOCK_KEY_PREFIX + key
+	deadline := time.Now().Add(LOCK_TIMEOUT)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		err := s.Client.Do(ctx, s.Client.B().Set().Key(lockKey).Value(token).Nx().Px(LOCK_TTL).Build()).Error()
+		cancel()
+
+		if err == nil {
+			break
+		}
+
+		if err != valkey.Nil {
+			zap.L().Error("Failed to acquire lock", zap.String("key", lockKey), zap.Error(err))
+			unlockLocal()
+			return nil, fmt.Errorf("Failed to acquire lock")
+		}
+
+		// someone else has it
+		if time.Now().After(deadline) {
+			zap.L().Warn("Timed out waiting for lock", zap.String("key", lockKey))
+			unlockLocal()
+			return nil, fmt.Errorf("Timed out waiting for lock")
+		}
+
+		time.Sleep(LOCK_RETRY)
+	}
+
+	stop := make(chan struct{})
+	go s.renew(lockKey, token, stop)
+
+	return func() {
+		close(stop)
+		defer unlockLocal()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		if err := s.Client.Do(ctx, s.Client.B().Eval().Script(unlockScript).Numkeys(1).Key(lockKey).Arg(token).Build()).Error(); err != nil {
+			// it will expire anyway
+			zap.L().Warn("Failed to release lock", zap.String("key", lockKey), zap.Error(err))
+		}
+	}, nil
+}
+
+// private
+
+// Keeps extending the lock until it's released e.g. while a conflicting write is retried, gives up if it was lost
+func (s *ValkeyLockService) renew(lockKey string, token string, stop chan struct{}) {
+	ticker := time.NewTicker(LOCK_RENEW)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		renewed, err := s.Client.Do(ctx, s.Client.B().Eval().Script(renewScript).Numkeys(1).Key(lockKey).Arg(token, strconv.FormatInt(LOCK_TTL.Milliseconds(), 10)).Build()).AsInt64()
+		cancel()
+
+		if err != nil {
+			// there is still time to try again before it expires
+			zap.L().Warn("Failed to renew lock", zap.String("key", lockKey), zap.Error(err))
+			continue
+		}
+
+		if renewed == 0 {
+			zap.L().Error("Lock expired while held", zap.String("key", lockKey))
+			return
+		}
+	}
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		zap.L().Error("Failed to generate lock token", zap.Error(err))
+		return "", fmt.Errorf("Failed to generate lock token")
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"sync"
+	"telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/model"
+	"time"
+)
+
+// Keeps dialogs in this process only, they are lost on restart which is fine for a single user install
+type MemoryStorageService struct {
+	mu        sync.Mutex
+	dialogs   map[string]model.Dialog
+	mutations map[string][]model.Mutation
+}
+
+func (s *MemoryStorageService) StoreDialog(dialog *model.Dialog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dialogs == nil {
+		s.dialogs = map[string]model.Dialog{}
+	}
+
+	// tidy up so the map doesn't grow forever
+	now := time.Now()
+	for key, d := range s.dialogs {
+		if forgotten(&d, now) {
+			delete(s.dialogs, key)
+		}
+	}
+
+	s.dialogs[dialogKey(dialog.ChatId, dialog.UserId)] = *dialog
+
+	return nil
+}
+
+func (s *MemoryStorageService) GetDialog(chatId int64, userId int64) (*model.Dialog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dialog, ok := s.dialogs[dialogKey(chatId, userId)]
+	if !ok || forgotten(&dialog, time.Now()) {
+		return nil, &errors.StorageError{
+			Type: errors.STORAGE_ERROR_TYPE_NOT_FOUND,
+		}
+	}
+
+	return &dialog, nil
+}
+
+func (s *MemoryStorageService) ClearDialog(chatId int64, userId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.dialogs, dialogKey(chatId, userId))
+
+	return nil
+}
+
+func (s *MemoryStorageService) AddMutation(key string, mutation *model.Mutation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mutations == nil {
+		s.mutations = map[string][]model.Mutation{}
+	}
+
+	s.mutations[key] = limitHistory(append([]model.Mutation{*mutation}, s.mutations[key]...))
+
+	return nil
+}
+
+func (s *MemoryStorageService) GetMutations(key string, count int) ([]model.Mutation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mutations := s.mutations[key]
+	if count < len(mutations) {
+		mutations = mutations[:count]
+	}
+
+	return append([]model.Mutation{}, mutations...), nil
+}
+
+func (s *MemoryStorageService) RemoveMutations(key string, mutations []model.Mutation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, ok := s.mutations[key]
+	if !ok {
+		return nil
+	}
+	s.mutations[key] = model.WithoutMutations(history, mutations)
+
+	return nil
+}
+
+// private
+
+// Past the point a late reply is told the dialog expired, valkey drops these itself
+func forgotten(dialog *model.Dialog, now time.Time) bool {
+	return now.After(dialog.ExpiresAt.Add(DIALOG_EXPIRED_GRACE))
+}
+
+func limitHistory(mutations []model.Mutation) []model.Mutation {
+	if len(mutations) > MAX_HISTORY {
+		return mutations[:MAX_HISTORY]
+	}
+	return mutations
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"telegram-spreadsheet-editor/utils"
+	"time"
+
+	"go.uber.org/zap"
+	"go.yaml.in/yaml/v3"
+)
+
+// Exchange rates for amounts given in another currency
+type IRateService interface {
+	// how much one unit of from is worth in to
+	GetRate(from string, to string) (float64, error)
+}
+
+// Rates from a yaml file e.g. for offline use
+//
+//	base: GBP
+//	rates:
+//	  USD: 1.27
+//	  EUR: 1.17
+type StaticRateService struct {
+	FilePath string
+}
+
+type StaticRates struct {
+	Base  string             `yaml:"base"`
+	Rates map[string]float64 `yaml:"rates"`
+}
+
+// Rates from a frankfurter compatible API e.g. https://api.frankfurter.app/latest?from=USD&to=GBP
+type HttpRateService struct {
+	Http    utils.IHttpClient
+	BaseUrl string
+
+	mu    sync.Mutex
+	cache map[string]cachedRate
+}
+
+type cachedRate struct {
+	rate    float64
+	expires time.Time
+}
+
+type httpRatesResponse struct {
+	Rates map[string]float64 `json:"rates"`
+}
+
+const (
+	DEFAULT_RATES_BASE_URL string        = "https://api.frankfurter.app"
+	RATE_CACHE_TTL         time.Duration = time.Hour
+)
+
+func (s *StaticRateService) GetRate(from string, to string) (float64, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	if from == to {
+		return 1, nil
+	}
+
+	data, err := os.ReadFile(s.FilePath)
+	if err != nil {
+		zap.L().Error("Failed to read rates file", zap.String("path", s.FilePath), zap.Error(err))
+		return 0, fmt.Errorf("Failed to read rates file")
+	}
+
+	var rates StaticRates
+	if err := yaml.Unmarshal(data, &rates); err != nil {
+		zap.L().Error("Failed to parse rates file", zap.String("path", s.FilePath), zap.Error(err))
+		return 0, fmt.Errorf("Failed to parse rates file")
+	}
+
+	// everything is relative to the base
+	if rates.Rates == nil {
+		rates.Rates = map[string]float64{}
+	}
+	rates.Rates[strings.ToUpper(rates.Base)] = 1
+
+	fromRate, ok := rates.Rates[from]
+	if !ok || fromRate == 0 {
+		return 0, fmt.Errorf("No rate for %s", from)
+	}
+	toRate, ok := rates.Rates[to]
+	if !ok {
+		return 0, fmt.Errorf("No rate for %s", to)
+	}
+
+	return toRate / fromRate, nil
+}
+
+func (s *HttpRateService) GetRate(from string, to string) (float64, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	if from == to {
+		return 1, nil
+	}
+
+	key := from + ":" + to
+
+	s.mu.Lock()
+	cached, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.rate, nil
+	}
+
+	baseUrl := s.BaseUrl
+	if len(baseUrl) == 0 {
+		baseUrl = DEFAULT_RATES_BASE_URL
+	}
+
+	query := url.Values{}
+	query.Set("from", from)
+	query.Set("to", to)
+
+	var body httpRatesResponse
+	res, err := s.Http.Get(fmt.Sprintf("%s/latest?%s", strings.TrimSuffix(baseUrl, "/"), query.Encode()), &body)
+	if err != nil {
+		zap.L().Error("Failed to get exchange rate", zap.String("from", from), zap.String("to", to), zap.Error(err))
+		return 0, fmt.Errorf("Failed to get exchange rate")
+	}
+
+	if res.StatusCode > 299 {
+		zap.L().Error("Got error code getting exchange rate", zap.Int("code", res.StatusCode), zap.String("from", from), zap.String("to", to))
+		return 0, fmt.Errorf("Failed to get exchange rate")
+	}
+
+	rate, ok := body.Rates[to]
+	if !ok {
+		return 0, fmt.Errorf("No rate for %s", to)
+	}
+
+	s.mu.Lock()
+	if s.cache == nil {
+		s.cache = map[string]cachedRate{}
+	}
+	s.cache[key] = cachedRate{rate: rate, expires: time.Now().Add(RATE_CACHE_TTL)}
+	s.mu.Unlock()
+
+	return rate, nil
+}
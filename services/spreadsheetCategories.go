@@ -0,0 +1,256 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"telegram-spreadsheet-editor/model"
+
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/zap"
+)
+
+// The categories in a name and value column pair and the totals row under them
+type categoryBlock struct {
+	NameColumn  string
+	ValueColumn string
+	// in order, there can be gaps between them
+	Rows  []uint
+	Names []string
+	// where the categories start, the header is above it
+	Start uint
+	// e.g. SUM(E2:E20), 0 if there isn't one
+	Totals uint
+	// where each section after the first starts in Rows, a subtotal sits between each section and the one before
+	sectionStarts []int
+}
+
+// The part of Rows in the same section as the category at index, between subtotals
+func (b *categoryBlock) section(index int) (int, int) {
+	start, end := 0, len(b.Rows)
+	for _, s := range b.sectionStarts {
+		if s <= index {
+			start = s
+		} else {
+			end = s
+			break
+		}
+	}
+	return start, end
+}
+
+// Index of a category in the block compared the same way as everywhere else, -1 if it isn't there
+func (b *categoryBlock) index(category string) int {
+	comparison := strings.ToLower(strings.ReplaceAll(category, " ", ""))
+	for i, name := range b.Names {
+		if strings.ToLower(strings.ReplaceAll(name, " ", "")) == comparison {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// The row of the last category, the row above the start when there are none
+func (b *categoryBlock) last() uint {
+	if len(b.Rows) == 0 {
+		return b.Start - 1
+	}
+	return b.Rows[len(b.Rows)-1]
+}
+
+// Finds the categories between the start and end rows, skipping headers and subtotals. Without an end row the
+// categories end after MAX_EMPTY_CELL_COUNT empty rows or at the configured totals row
+func findCategoryBlock(file *excelize.File, sheetName string, source *model.BaseSpreadsheetSource, earning bool) (*categoryBlock, error) {
+	nameColumn, valueColumn, err := getColumns(source, earning)
+	if err != nil {
+		return nil, err
+	}
+
+	block := &categoryBlock{
+		NameColumn:  nameColumn,
+		ValueColumn: valueColumn,
+		Start:       uint(max(source.StartRow, 1)),
+	}
+
+	totalRow := uint(max(source.TotalRow, 0))
+	if earning {
+		totalRow = uint(max(source.EarningsTotalRow, 0))
+	}
+
+	// a sum with categories after it is a subtotal rather than the totals
+	totals := uint(0)
+	emptyCellCount := uint(0)
+	for currentRow := block.Start; currentRow != totalRow; currentRow++ {
+		if source.EndRow > 0 && currentRow > uint(source.EndRow) {
+			break
+		}
+		if source.EndRow <= 0 && emptyCellCount >= MAX_EMPTY_CELL_COUNT {
+			break
+		}
+
+		categoryCell := fmt.Sprintf("%s%d", nameColumn, currentRow)
+		category, err := file.GetCellValue(sheetName, categoryCell)
+		if err != nil {
+			zap.L().Error("Failed to get value for category cell", zap.String("cell", categoryCell), zap.Error(err))
+			return nil, fmt.Errorf("Failed to get value for cell")
+		}
+
+		if len(strings.TrimSpace(category)) == 0 {
+			emptyCellCount++
+			continue
+		}
+
+		emptyCellCount = 0
+
+		valueCell := fmt.Sprintf("%s%d", valueColumn, currentRow)
+		form, err := file.GetCellFormula(sheetName, valueCell)
+		if err != nil {
+			zap.L().Error("Failed to get cell formula", zap.String("cell", valueCell), zap.Error(err))
+			return nil, fmt.Errorf("Failed to get cell formula")
+		}
+
+		if !valueFormulaRegex.MatchString(form) {
+			if totals == 0 {
+				totals = currentRow
+			}
+			continue
+		}
+
+		if len(form) == 0 {
+			val, err := file.GetCellValue(sheetName, valueCell)
+			if err != nil {
+				zap.L().Error("Failed to get cell value", zap.String("cell", valueCell), zap.Error(err))
+				return nil, fmt.Errorf("Failed to get cell value")
+			}
+
+			// headers have words rather than numbers
+			if len(strings.TrimSpace(val)) > 0 && !digitRegex.MatchString(val) {
+				if len(block.Rows) == 0 {
+					block.Start = currentRow + 1
+				}
+				continue
+			}
+		}
+
+		// the sum above was a subtotal so this starts a new section
+		if totals > 0 && len(block.Rows) > 0 {
+			block.sectionStarts = append(block.sectionStarts, len(block.Rows))
+		}
+
+		block.Rows = append(block.Rows, currentRow)
+		block.Names = append(block.Names, category)
+		totals = 0
+	}
+
+	block.Totals = totals
+	if totalRow > 0 {
+		block.Totals = totalRow
+	}
+
+	return block, nil
+}
+
+func isEmptyRow(file *excelize.File, sheetName string, nameColumn string, valueColumn string, row uint) (bool, error) {
+	for _, column := range []string{nameColumn, valueColumn} {
+		cell := fmt.Sprintf("%s%d", column, row)
+		val, err := file.GetCellValue(sheetName, cell)
+		if err != nil {
+			zap.L().Error("Failed to get cell value", zap.String("cell", cell), zap.Error(err))
+			return false, fmt.Errorf("Failed to get cell value")
+		}
+		form, err := file.GetCellFormula(sheetName, cell)
+		if err != nil {
+			zap.L().Error("Failed to get cell formula", zap.String("cell", cell), zap.Error(err))
+			return false, fmt.Errorf("Failed to get cell formula")
+		}
+		if len(strings.TrimSpace(val)) > 0 || len(form) > 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Ranges in the totals formula that end on the old last category are stretched to the row after it e.g. SUM(E2:E19) to SUM(E2:E20)
+func extendTotals(file *excelize.File, sheetName string, valueColumn string, totalsRow uint, lastRow uint) error {
+	cell := fmt.Sprintf("%s%d", valueColumn, totalsRow)
+	form, err := file.GetCellFormula(sheetName, cell)
+	if err != nil {
+		zap.L().Error("Failed to get cell formula", zap.String("cell", cell), zap.Error(err))
+		return fmt.Errorf("Failed to get cell formula")
+	}
+
+	column := regexp.QuoteMeta(valueColumn)
+	rangeRegex := regexp.MustCompile(fmt.Sprintf(`(\$?%s\$?\d+:\$?%s\$?)(\d+)`, column, column))
+	extended := rangeRegex.ReplaceAllStringFunc(form, func(match string) string {
+		parts := rangeRegex.FindStringSubmatch(match)
+		if parts[2] != strconv.Itoa(int(lastRow)) {
+			return match
+		}
+		return fmt.Sprintf("%s%d", parts[1], lastRow+1)
+	})
+
+	if extended == form {
+		return nil
+	}
+
+	if err := file.SetCellFormula(sheetName, cell, extended); err != nil {
+		zap.L().Error("Failed to set the cell's formula", zap.Error(err), zap.String("formula", extended))
+		return fmt.Errorf("Failed to set cell formula")
+	}
+
+	return nil
+}
+
+// The cells of a category's row that move with it, the budget column only belongs to costs
+func categoryColumns(source *model.BaseSpreadsheetSource, earning bool) ([]string, error) {
+	nameColumn, valueColumn, err := getColumns(source, earning)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := []string{nameColumn, valueColumn}
+	if !earning && len(source.BudgetColumn) > 0 {
+		columns = append(columns, source.BudgetColumn)
+	}
+	return columns, nil
+}
+
+// Copies a category's cells, with their formulas and comments, into another row
+func moveCategory(file *excelize.File, sheetName string, columns []string, from uint, to uint) error {
+	for _, column := range columns {
+		fromCell := fmt.Sprintf("%s%d", column, from)
+		toCell := fmt.Sprintf("%s%d", column, to)
+		state, err := readCellState(file, sheetName, fromCell)
+		if err != nil {
+			return err
+		}
+		current, err := readCellState(file, sheetName, toCell)
+		if err != nil {
+			return err
+		}
+		if err := writeCellState(file, sheetName, toCell, current, state); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Empties a category's cells e.g. the row left behind once the rest have moved up
+func clearCategory(file *excelize.File, sheetName string, columns []string, row uint) error {
+	for _, column := range columns {
+		cell := fmt.Sprintf("%s%d", column, row)
+		current, err := readCellState(file, sheetName, cell)
+		if err != nil {
+			return err
+		}
+		if err := writeCellState(file, sheetName, cell, current, model.CellState{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
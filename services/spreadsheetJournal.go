@@ -0,0 +1,194 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/utils"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/zap"
+)
+
+// The journal is an optional tab with a row per change so there is a record of when and who added each amount
+
+const (
+	JOURNAL_TIME_FORMAT string = "2006-01-02 15:04"
+	// where the amount is in a journal row
+	JOURNAL_AMOUNT_INDEX int = 4
+)
+
+var journalHeaders = []string{"Date", "User", "Sheet", "Category", "Amount", "Note"}
+
+// Appends a row for an amount added to a category, returns the row as it reads back or nil when not journalling
+func appendJournalRow(file *excelize.File, source *model.BaseSpreadsheetSource, user string, sheetName string, category string, amount float64, note string) ([]string, error) {
+	if len(source.JournalSheet) == 0 {
+		return nil, nil
+	}
+
+	return writeJournalRow(file, source, []any{time.Now().Format(JOURNAL_TIME_FORMAT), user, sheetName, category, math.Round(amount*100) / 100, note})
+}
+
+// Deletes the newest row matching the given one e.g. when the amount it records is undone. The date isn't
+// compared as google sheets reads it back as a date rather than the text written
+func removeJournalRow(file *excelize.File, source *model.BaseSpreadsheetSource, row []string) error {
+	rows, err := getJournalRows(file, source)
+	if err != nil || rows == nil {
+		return err
+	}
+
+	for i := len(rows) - 1; i > 0; i-- {
+		if !sameJournalEntry(rows[i], row) {
+			continue
+		}
+		if err := file.RemoveRow(source.JournalSheet, i+1); err != nil {
+			zap.L().Error("Failed to remove journal row", zap.Int("row", i+1), zap.Error(err))
+			return fmt.Errorf("Failed to remove journal row")
+		}
+		return nil
+	}
+
+	zap.L().Warn("No journal row to remove", zap.Strings("row", row))
+
+	return nil
+}
+
+// Deletes the newest row for an amount in a category e.g. for REMOVE, returns the row or nil if there wasn't one
+func removeJournalRowFor(file *excelize.File, source *model.BaseSpreadsheetSource, sheetName string, nameColumn string, categoryRow uint, amount float64) ([]string, error) {
+	rows, err := getJournalRows(file, source)
+	if err != nil || rows == nil {
+		return nil, err
+	}
+
+	category, err := file.GetCellValue(sheetName, fmt.Sprintf("%s%d", nameColumn, categoryRow))
+	if err != nil {
+		zap.L().Error("Failed to get category name", zap.Error(err))
+		return nil, fmt.Errorf("Failed to get category name")
+	}
+
+	for i := len(rows) - 1; i > 0; i-- {
+		row := rows[i]
+		if len(row) <= JOURNAL_AMOUNT_INDEX || row[2] != sheetName || row[3] != category {
+			continue
+		}
+		value, err := strconv.ParseFloat(row[JOURNAL_AMOUNT_INDEX], 64)
+		if err != nil || math.Abs(value-amount) > 0.005 {
+			continue
+		}
+
+		if err := file.RemoveRow(source.JournalSheet, i+1); err != nil {
+			zap.L().Error("Failed to remove journal row", zap.Int("row", i+1), zap.Error(err))
+			return nil, fmt.Errorf("Failed to remove journal row")
+		}
+		return row, nil
+	}
+
+	return nil, nil
+}
+
+// As removeJournalRowFor for a cell that was cleared, the amount being what the cell held
+func removeJournalRowForCell(file *excelize.File, source *model.BaseSpreadsheetSource, sheetName string, nameColumn string, categoryRow uint, cleared model.CellState) ([]string, error) {
+	var amount float64
+	var err error
+	if len(cleared.Formula) > 0 {
+		amount, err = utils.EvaluateExpression(cleared.Formula)
+	} else {
+		amount, err = strconv.ParseFloat(cleared.Value, 64)
+	}
+	if err != nil {
+		// e.g. a formula referencing other cells, there is no amount to match
+		return nil, nil
+	}
+
+	return removeJournalRowFor(file, source, sheetName, nameColumn, categoryRow, amount)
+}
+
+// Puts back a row deleted by REMOVE when it is undone
+func restoreJournalRow(file *excelize.File, source *model.BaseSpreadsheetSource, row []string) error {
+	values := make([]any, len(row))
+	for i, v := range row {
+		values[i] = v
+		if i == JOURNAL_AMOUNT_INDEX {
+			if amount, err := strconv.ParseFloat(v, 64); err == nil {
+				values[i] = amount
+			}
+		}
+	}
+
+	_, err := writeJournalRow(file, source, values)
+	return err
+}
+
+// private
+
+func writeJournalRow(file *excelize.File, source *model.BaseSpreadsheetSource, values []any) ([]string, error) {
+	if index, err := file.GetSheetIndex(source.JournalSheet); err != nil || index == -1 {
+		if _, err := file.NewSheet(source.JournalSheet); err != nil {
+			zap.L().Error("Failed to create journal sheet", zap.String("name", source.JournalSheet), zap.Error(err))
+			return nil, fmt.Errorf("Failed to create journal sheet")
+		}
+		if err := file.SetSheetRow(source.JournalSheet, "A1", &journalHeaders); err != nil {
+			zap.L().Error("Failed to write journal headers", zap.Error(err))
+			return nil, fmt.Errorf("Failed to write journal headers")
+		}
+	}
+
+	rows, err := getJournalRows(file, source)
+	if err != nil {
+		return nil, err
+	}
+
+	next := len(rows) + 1
+	if err := file.SetSheetRow(source.JournalSheet, fmt.Sprintf("A%d", next), &values); err != nil {
+		zap.L().Error("Failed to write journal row", zap.Int("row", next), zap.Error(err))
+		return nil, fmt.Errorf("Failed to write journal row")
+	}
+
+	// read it back so it can be matched later
+	rows, err = getJournalRows(file, source)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows[next-1], nil
+}
+
+// nil when there is no journal tab yet
+func getJournalRows(file *excelize.File, source *model.BaseSpreadsheetSource) ([][]string, error) {
+	if index, err := file.GetSheetIndex(source.JournalSheet); err != nil || index == -1 {
+		return nil, nil
+	}
+
+	rows, err := file.GetRows(source.JournalSheet, excelize.Options{RawCellValue: true})
+	if err != nil {
+		zap.L().Error("Failed to read journal", zap.Error(err))
+		return nil, fmt.Errorf("Failed to read journal")
+	}
+
+	return rows, nil
+}
+
+func sameJournalEntry(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 1; i < len(a); i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// e.g. A to F, the columns synced for the journal tab
+func journalColumns() []string {
+	columns := make([]string, len(journalHeaders))
+	for i := range journalHeaders {
+		columns[i], _ = excelize.ColumnNumberToName(i + 1)
+	}
+
+	return columns
+}
@@ -1,32 +1,54 @@
 package services
 
 import (
+	"archive/zip"
 	"bytes"
+	"encoding/xml"
 	"fmt"
 	"io"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	e "telegram-spreadsheet-editor/errors"
 	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/utils"
+	"time"
 
 	"github.com/xuri/excelize/v2"
 	"go.uber.org/zap"
 )
 
 type ISpreadsheetService interface {
-	ListCategoriesAndValues(source model.SpreadsheetSource, sheet io.Reader) (*[]model.Entry, error)
-	AddValueForCategory(source model.SpreadsheetSource, sheet io.Reader, category string, value float32) (io.Reader, *string, error)
-	ReadValueForCategory(source model.SpreadsheetSource, sheet io.Reader, category string, details bool) (*string, error)
-	RemoveLastValueForCategory(source model.SpreadsheetSource, sheet io.Reader, category string) (*RemovedResult, error)
+	// The month is the tab to use e.g. "r groceries march", nil for the one the source's sheetSelection picks
+	ListCategoriesAndValues(source model.SpreadsheetSource, sheet io.Reader, month *time.Time) (*[]model.Entry, error)
+	AddValueForCategory(source model.SpreadsheetSource, sheet io.Reader, month *time.Time, category string, add AddValue) (*AddedResult, error)
+	ReadValueForCategory(source model.SpreadsheetSource, sheet io.Reader, month *time.Time, category string, details bool, earning bool) (*string, error)
+	RemoveLastValueForCategory(source model.SpreadsheetSource, sheet io.Reader, month *time.Time, category string, earning bool) (*RemovedResult, error)
+	CreateMonthSheet(source model.SpreadsheetSource, sheet io.Reader, month time.Time) (io.Reader, *string, error)
+	// Puts cells back to how they were before the changes, newest first. Fails if a cell was changed since
+	RestoreCells(source model.SpreadsheetSource, sheet io.Reader, changes []model.CellChange) (io.Reader, error)
+	// Category management on the current month, only the configured name and value columns are touched
+	AddCategory(source model.SpreadsheetSource, sheet io.Reader, category string, earning bool) (io.Reader, error)
+	RenameCategory(source model.SpreadsheetSource, sheet io.Reader, category string, newName string, earning bool) (io.Reader, error)
+	// Fails with a not empty error if the category has amounts in it unless forced
+	DeleteCategory(source model.SpreadsheetSource, sheet io.Reader, category string, earning bool, force bool) (io.Reader, error)
 }
 
 type ExcelerizeSpreadsheetService struct{}
 
 const (
-	MAX_EMPTY_CELL_COUNT uint = 5
+	MAX_EMPTY_CELL_COUNT uint   = 5
+	COMMENT_AUTHOR       string = "telegram-spreadsheet-editor"
 )
 
-func (s *ExcelerizeSpreadsheetService) ListCategoriesAndValues(source model.SpreadsheetSource, sheet io.Reader) (*[]model.Entry, error) {
+var (
+	// formulas made up purely of numbers are ones the bot manages, anything else (e.g. SUM(E2:E20)) is left alone
+	valueFormulaRegex = regexp.MustCompile(`^[\d.+\-*/() ]*$`)
+	digitRegex        = regexp.MustCompile(`\d`)
+)
+
+func (s *ExcelerizeSpreadsheetService) ListCategoriesAndValues(source model.SpreadsheetSource, sheet io.Reader, month *time.Time) (*[]model.Entry, error) {
 	bs := getBaseSpreadsheetSource(source)
 
 	f, err := excelize.OpenReader(sheet, excelize.Options{})
@@ -42,60 +64,64 @@ func (s *ExcelerizeSpreadsheetService) ListCategoriesAndValues(source model.Spre
 		}
 	}()
 
-	entries := []model.Entry{}
-
-	// currently default to last sheet
-	sheetName := f.GetSheetName(f.SheetCount - 1)
-
-	// iterate key column until getting empty cells
-	emptyCellCount := uint(0)
-	currentRow := uint(1)
-	for {
-		if emptyCellCount >= MAX_EMPTY_CELL_COUNT {
-			break
-		}
-
-		categoryCell := fmt.Sprintf("%s%d", bs.CostNameColumn, currentRow)
-		category, err := f.GetCellValue(sheetName, categoryCell)
-		if err != nil {
-			zap.L().Error("Failed to get value for category cell", zap.String("cell", categoryCell), zap.Error(err))
-			return nil, fmt.Errorf("Failed to get value for cell")
-		}
-
-		trimmed := strings.ReplaceAll(category, " ", "")
-		if len(trimmed) == 0 {
-			emptyCellCount++
-			currentRow++
-			continue
-		}
+	sheetName, err := targetSheetName(f, bs, month)
+	if err != nil {
+		return nil, err
+	}
 
-		emptyCellCount = 0
+	entries, err := listEntries(f, sheetName, bs, false)
+	if err != nil {
+		return nil, err
+	}
 
-		valueCell := fmt.Sprintf("%s%d", bs.CostValueColumn, currentRow)
-		value, err := f.CalcCellValue(sheetName, valueCell)
+	// earnings are optional
+	if len(bs.EarningNameColumn) > 0 && len(bs.EarningsValueColumn) > 0 {
+		earnings, err := listEntries(f, sheetName, bs, true)
 		if err != nil {
-			zap.L().Error("Failed to get value for value cell", zap.String("cell", categoryCell), zap.Error(err))
-			return nil, fmt.Errorf("Failed to get value for cell")
+			return nil, err
 		}
-
-		entries = append(entries, model.Entry{
-			Category: category,
-			Value:    value,
-		})
-
-		currentRow++
+		entries = append(entries, earnings...)
 	}
 
 	return &entries, nil
 }
 
-func (s *ExcelerizeSpreadsheetService) AddValueForCategory(source model.SpreadsheetSource, sheet io.Reader, category string, value float32) (io.Reader, *string, error) {
+// What to add to a category, only the value is needed
+type AddValue struct {
+	Value float32
+	// how the value was typed e.g. 12.50+3.20, written in place of the value when the source writes expressions
+	Expression string
+	// e.g. £19.75 was $25.00 when the value was converted from another currency
+	Comment string
+	// the user's own e.g. lunch with Sam
+	Note    string
+	Earning bool
+	// who the journal row is for
+	User string
+}
+
+type AddedResult struct {
+	ModifiedSheet io.Reader
+	NewValue      string
+	Change        model.CellChange
+	// the totals as numbers e.g. to check against the budget
+	PreviousTotal float64
+	Total         float64
+	// nil when the category has no budget
+	Budget *float64
+}
+
+func (s *ExcelerizeSpreadsheetService) AddValueForCategory(source model.SpreadsheetSource, sheet io.Reader, month *time.Time, category string, add AddValue) (*AddedResult, error) {
 	bs := getBaseSpreadsheetSource(source)
+	nameColumn, valueColumn, err := getColumns(bs, add.Earning)
+	if err != nil {
+		return nil, err
+	}
 
 	f, err := excelize.OpenReader(sheet, excelize.Options{})
 	if err != nil {
 		zap.L().Error("Failed to open spreadsheet", zap.Error(err))
-		return nil, nil, fmt.Errorf("Failed to open spreadsheet")
+		return nil, fmt.Errorf("Failed to open spreadsheet")
 	}
 
 	defer func() {
@@ -105,21 +131,33 @@ func (s *ExcelerizeSpreadsheetService) AddValueForCategory(source model.Spreadsh
 		}
 	}()
 
-	// currently default to last sheet
-	sheetName := f.GetSheetName(f.SheetCount - 1)
+	sheetName, err := targetSheetName(f, bs, month)
+	if err != nil {
+		return nil, err
+	}
 
 	// get correct row
-	row, err := getRowForCategory(bs, f, category, sheetName)
+	row, err := getRowForCategory(f, sheetName, bs, category, add.Earning)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	// get the cell formula
-	cell := fmt.Sprintf("%s%d", bs.CostValueColumn, *row)
+	cell := fmt.Sprintf("%s%d", valueColumn, *row)
 	form, err := f.GetCellFormula(sheetName, cell)
 	if err != nil {
 		zap.L().Error("Failed to get cell formula", zap.Error(err))
-		return nil, nil, fmt.Errorf("Failed to get cell formula")
+		return nil, fmt.Errorf("Failed to get cell formula")
+	}
+
+	before, err := readCellState(f, sheetName, cell)
+	if err != nil {
+		return nil, err
+	}
+
+	previousTotal, err := calcAmount(f, sheetName, cell)
+	if err != nil {
+		return nil, err
 	}
 
 	var valueToAdd *string
@@ -128,7 +166,7 @@ func (s *ExcelerizeSpreadsheetService) AddValueForCategory(source model.Spreadsh
 		val, err := f.GetCellValue(sheetName, cell)
 		if err != nil {
 			zap.L().Error("Failed to get cell value, borking", zap.Error(err))
-			return nil, nil, fmt.Errorf("Failed to get cell value")
+			return nil, fmt.Errorf("Failed to get cell value")
 		}
 
 		numericRegex := regexp.MustCompile(`-?\d+(?:,\d{3})*(?:\.\d+)?`)
@@ -148,67 +186,100 @@ func (s *ExcelerizeSpreadsheetService) AddValueForCategory(source model.Spreadsh
 
 	zap.L().Info("Staring formula update", zap.String("current formula", form))
 
+	// signed so it can be appended e.g. +12.50, -12.50 or +(12.50+3.20)
+	term := formulaTerm(add.Value, add.Expression, bs.WriteExpressions)
+
 	var updatedFormula string
 	isValue := false // whether to use set value rather than set formula
 
 	if (len(form) == 0 || strings.Compare(form, "0") == 0) && valueToAdd == nil {
 		// set the first value
-		updatedFormula = fmt.Sprintf("%.2f", value)
-		isValue = true
+		if strings.HasPrefix(term, "+(") {
+			// kept in brackets so REMOVE takes off the whole expression
+			updatedFormula = strings.TrimPrefix(term, "+")
+		} else if strings.HasPrefix(term, "-(") {
+			updatedFormula = term
+		} else {
+			updatedFormula = term
+			isValue = true
+		}
 	} else if valueToAdd != nil {
 		// include the old value
-		updatedFormula = fmt.Sprintf("%s+%.2f", *valueToAdd, value)
+		updatedFormula = *valueToAdd + term
 	} else {
 		// add the value to the formula
-		updatedFormula = fmt.Sprintf("%s+%.2f", form, value)
+		updatedFormula = form + term
+	}
+
+	comment, journalNote := add.comments(term)
+	if len(comment) > 0 {
+		if err := appendCellComment(f, sheetName, cell, comment); err != nil {
+			return nil, err
+		}
 	}
 
 	if isValue {
 		zap.L().Info(
 			"Setting cell value as there was nothing there. Will change to formula next time.",
-			zap.Float32("value", value),
+			zap.Float32("value", add.Value),
 			zap.String("sheet", sheetName),
 			zap.String("cell", cell),
 		)
 
-		if err := f.SetCellValue(sheetName, cell, value); err != nil {
+		if err := f.SetCellValue(sheetName, cell, add.Value); err != nil {
 			zap.L().Error("Failed to set the cell's value", zap.Error(err))
-			return nil, nil, fmt.Errorf("Failed to set cell value")
+			return nil, fmt.Errorf("Failed to set cell value")
 		}
+	} else {
+		zap.L().Info("Setting cell formula", zap.String("formula", updatedFormula), zap.String("Sheet", sheetName), zap.String("cell", cell))
 
-		// We can skip the rest as it is all related to formulas
-		updatedValue, err := f.GetCellValue(sheetName, cell)
-		if err != nil {
-			zap.L().Error("Failed to get updated cell value", zap.Error(err))
-			return nil, nil, fmt.Errorf("Failed to get updated value")
+		if err := f.SetCellFormula(sheetName, cell, updatedFormula); err != nil {
+			zap.L().Error("Failed to set the cell's formula", zap.Error(err), zap.String("formula", updatedFormula))
+			return nil, fmt.Errorf("Failed to set cell formula")
 		}
+	}
 
-		// return the spreadhseet as an io.Reader
-		var buffer bytes.Buffer
-		if err := f.Write(&buffer); err != nil {
-			zap.L().Error("Failed to write spreadsheet to buffer", zap.Error(err))
-		}
+	updatedVal, err := calcFormattedValue(f, sheetName, cell, bs)
+	if err != nil {
+		return nil, err
+	}
+
+	zap.L().Info("Calculated new value", zap.String("val", updatedVal))
 
-		return bytes.NewReader(buffer.Bytes()), &updatedValue, nil
+	total, err := calcAmount(f, sheetName, cell)
+	if err != nil {
+		return nil, err
 	}
 
-	zap.L().Info("Setting cell formula", zap.String("formula", updatedFormula), zap.String("Sheet", sheetName), zap.String("cell", cell))
+	var budget *float64
+	if !add.Earning {
+		budget, err = budgetFor(f, sheetName, bs, *row, category)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	if err := f.SetCellFormula(sheetName, cell, updatedFormula); err != nil {
-		zap.L().Error("Failed to set the cell's formula", zap.Error(err), zap.String("formula", updatedFormula))
-		return nil, nil, fmt.Errorf("Failed to set cell formula")
+	after, err := readCellState(f, sheetName, cell)
+	if err != nil {
+		return nil, err
 	}
 
-	updatedVal, err := f.CalcCellValue(sheetName, cell)
+	// the category as the sheet has it rather than as typed
+	name, err := f.GetCellValue(sheetName, fmt.Sprintf("%s%d", nameColumn, *row))
 	if err != nil {
-		zap.L().Error("Failed to get updated cell value from formula", zap.Error(err))
-		return nil, nil, fmt.Errorf("Failed to get updated value from formula")
+		zap.L().Error("Failed to get category name", zap.Error(err))
+		return nil, fmt.Errorf("Failed to get category name")
 	}
 
-	zap.L().Info("Calculated new value", zap.String("val", updatedVal))
+	journalRow, err := appendJournalRow(f, bs, add.User, sheetName, name, float64(add.Value), journalNote)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := f.UpdateLinkedValue(); err != nil {
-		zap.L().Warn("Failed to updated linked values", zap.Error(err))
+	if !isValue {
+		if err := f.UpdateLinkedValue(); err != nil {
+			zap.L().Warn("Failed to updated linked values", zap.Error(err))
+		}
 	}
 
 	// return the spreadhseet as an io.Reader
@@ -217,11 +288,28 @@ func (s *ExcelerizeSpreadsheetService) AddValueForCategory(source model.Spreadsh
 		zap.L().Error("Failed to write spreadsheet to buffer", zap.Error(err))
 	}
 
-	return bytes.NewReader(buffer.Bytes()), &updatedVal, nil
+	return &AddedResult{
+		ModifiedSheet: bytes.NewReader(buffer.Bytes()),
+		NewValue:      updatedVal,
+		Change: model.CellChange{
+			Sheet:      sheetName,
+			Cell:       cell,
+			Before:     before,
+			After:      after,
+			JournalRow: journalRow,
+		},
+		PreviousTotal: previousTotal,
+		Total:         total,
+		Budget:        budget,
+	}, nil
 }
 
-func (s *ExcelerizeSpreadsheetService) ReadValueForCategory(source model.SpreadsheetSource, sheet io.Reader, category string, details bool) (*string, error) {
+func (s *ExcelerizeSpreadsheetService) ReadValueForCategory(source model.SpreadsheetSource, sheet io.Reader, month *time.Time, category string, details bool, earning bool) (*string, error) {
 	bs := getBaseSpreadsheetSource(source)
+	_, valueColumn, err := getColumns(bs, earning)
+	if err != nil {
+		return nil, err
+	}
 
 	f, err := excelize.OpenReader(sheet, excelize.Options{})
 	if err != nil {
@@ -236,17 +324,19 @@ func (s *ExcelerizeSpreadsheetService) ReadValueForCategory(source model.Spreads
 		}
 	}()
 
-	// currently default to last sheet
-	sheetName := f.GetSheetName(f.SheetCount - 1)
+	sheetName, err := targetSheetName(f, bs, month)
+	if err != nil {
+		return nil, err
+	}
 
 	// get correct row
-	row, err := getRowForCategory(bs, f, category, sheetName)
+	row, err := getRowForCategory(f, sheetName, bs, category, earning)
 	if err != nil {
 		return nil, err
 	}
 
 	// get the cell value
-	cell := fmt.Sprintf("%s%d", bs.CostValueColumn, *row)
+	cell := fmt.Sprintf("%s%d", valueColumn, *row)
 
 	if details {
 		// get the formula
@@ -256,21 +346,34 @@ func (s *ExcelerizeSpreadsheetService) ReadValueForCategory(source model.Spreads
 			return nil, fmt.Errorf("Failed to get cell formula")
 		}
 
+		// comments say where values came from e.g. 19.75 was 25.00 USD
+		comment, err := getCellComment(f, sheetName, cell)
+		if err != nil {
+			return nil, err
+		}
+		if len(form) > 0 && len(comment) > 0 {
+			withComment := fmt.Sprintf("%s\n%s", form, comment)
+			return &withComment, nil
+		}
 		if len(form) > 0 {
 			return &form, nil
 		}
-	}
 
-	val, err := f.CalcCellValue(sheetName, cell)
-	if err != nil {
-		zap.L().Error("Failed to get cell value", zap.Error(err))
-		return nil, fmt.Errorf("Failed to get cell value")
+		// there was no formula so show the plain number so it looks like a sum
+		val, err := f.CalcCellValue(sheetName, cell, excelize.Options{RawCellValue: true})
+		if err != nil {
+			zap.L().Error("Failed to get cell value", zap.Error(err))
+			return nil, fmt.Errorf("Failed to get cell value")
+		}
+		if len(comment) > 0 {
+			val = fmt.Sprintf("%s\n%s", val, comment)
+		}
+		return &val, nil
 	}
 
-	if details {
-		// there was no formula but strip £ so it looks like a sum
-		stripped := strings.ReplaceAll(val, "£", "")
-		return &stripped, nil
+	val, err := calcFormattedValue(f, sheetName, cell, bs)
+	if err != nil {
+		return nil, err
 	}
 
 	return &val, nil
@@ -281,10 +384,15 @@ type RemovedResult struct {
 	OldValue      string
 	RemovedValue  string
 	NewValue      string
+	Change        model.CellChange
 }
 
-func (s *ExcelerizeSpreadsheetService) RemoveLastValueForCategory(source model.SpreadsheetSource, sheet io.Reader, category string) (*RemovedResult, error) {
+func (s *ExcelerizeSpreadsheetService) RemoveLastValueForCategory(source model.SpreadsheetSource, sheet io.Reader, month *time.Time, category string, earning bool) (*RemovedResult, error) {
 	bs := getBaseSpreadsheetSource(source)
+	nameColumn, valueColumn, err := getColumns(bs, earning)
+	if err != nil {
+		return nil, err
+	}
 
 	f, err := excelize.OpenReader(sheet, excelize.Options{})
 	if err != nil {
@@ -299,29 +407,37 @@ func (s *ExcelerizeSpreadsheetService) RemoveLastValueForCategory(source model.S
 		}
 	}()
 
-	// currently default to last sheet
-	sheetName := f.GetSheetName(f.SheetCount - 1)
+	sheetName, err := targetSheetName(f, bs, month)
+	if err != nil {
+		return nil, err
+	}
 
 	// get correct row
-	row, err := getRowForCategory(bs, f, category, sheetName)
+	row, err := getRowForCategory(f, sheetName, bs, category, earning)
 	if err != nil {
 		return nil, err
 	}
 
 	// get the cell formula
-	cell := fmt.Sprintf("%s%d", bs.CostValueColumn, *row)
+	cell := fmt.Sprintf("%s%d", valueColumn, *row)
 	form, err := f.GetCellFormula(sheetName, cell)
 	if err != nil {
 		zap.L().Error("Failed to get cell formula", zap.Error(err))
 		return nil, fmt.Errorf("Failed to get cell formula")
 	}
 
-	if len(form) == 0 || !strings.Contains(form, "+") {
+	before, err := readCellState(f, sheetName, cell)
+	if err != nil {
+		return nil, err
+	}
+
+	// brackets hold a single sum e.g. 10+(12.50+3.20) so only look outside them
+	lastIdx := lastTermIndex(form)
+	if len(form) == 0 || lastIdx <= 0 {
 		// set the cell value to 0
-		val, err := f.CalcCellValue(sheetName, cell)
+		val, err := calcFormattedValue(f, sheetName, cell, bs)
 		if err != nil {
-			zap.L().Error("Failed to calc cell value", zap.Error(err))
-			return nil, fmt.Errorf("Failed to calc cell value")
+			return nil, err
 		}
 
 		if err := f.SetCellValue(sheetName, cell, 0); err != nil {
@@ -329,6 +445,20 @@ func (s *ExcelerizeSpreadsheetService) RemoveLastValueForCategory(source model.S
 			return nil, fmt.Errorf("Failed to set cell value")
 		}
 
+		if err := clearCellComment(f, sheetName, cell); err != nil {
+			return nil, err
+		}
+
+		after, err := readCellState(f, sheetName, cell)
+		if err != nil {
+			return nil, err
+		}
+
+		journalRow, err := removeJournalRowForCell(f, bs, sheetName, nameColumn, *row, before)
+		if err != nil {
+			return nil, err
+		}
+
 		// return the spreadhseet as an io.Reader
 		var buffer bytes.Buffer
 		if err := f.Write(&buffer); err != nil {
@@ -339,21 +469,32 @@ func (s *ExcelerizeSpreadsheetService) RemoveLastValueForCategory(source model.S
 			ModifiedSheet: bytes.NewBuffer(buffer.Bytes()),
 			OldValue:      val,
 			RemovedValue:  val,
-			NewValue:      "£0",
+			NewValue:      utils.FormatAmount(0, bs.GetCurrency(), bs.GetLocale()),
+			Change: model.CellChange{
+				Sheet:          sheetName,
+				Cell:           cell,
+				Before:         before,
+				After:          after,
+				JournalRow:     journalRow,
+				JournalRemoved: journalRow != nil,
+			},
 		}, nil
 	}
 
 	// get the current cell value
-	val, err := f.CalcCellValue(sheetName, cell)
+	val, err := calcFormattedValue(f, sheetName, cell, bs)
 	if err != nil {
-		zap.L().Error("Failed to calc cell value", zap.Error(err))
-		return nil, fmt.Errorf("Failed to calc cell value")
+		return nil, err
 	}
 
-	// remove after the last +
-	lastIdx := strings.LastIndex(form, "+")
-	toRemove := form[lastIdx+1:]
+	// remove from the last + or -
+	toRemove := form[lastIdx:]
 	newForm := form[:lastIdx]
+	removedValue := formatTerm(toRemove, bs)
+
+	if err := removeCellCommentFor(f, sheetName, cell, toRemove, removedValue); err != nil {
+		return nil, err
+	}
 
 	// set the new formula
 	if err := f.SetCellFormula(sheetName, cell, newForm); err != nil {
@@ -361,10 +502,25 @@ func (s *ExcelerizeSpreadsheetService) RemoveLastValueForCategory(source model.S
 		return nil, fmt.Errorf("Failed to set cell formula")
 	}
 
-	updatedVal, err := f.CalcCellValue(sheetName, cell)
+	updatedVal, err := calcFormattedValue(f, sheetName, cell, bs)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := readCellState(f, sheetName, cell)
 	if err != nil {
-		zap.L().Error("Failed to get updated cell value from formula", zap.Error(err))
-		return nil, fmt.Errorf("Failed to get updated value from formula")
+		return nil, err
+	}
+
+	var journalRow []string
+	if amount, err := utils.EvaluateExpression(strings.TrimLeft(toRemove, "+-")); err == nil {
+		if strings.HasPrefix(toRemove, "-") {
+			amount = -amount
+		}
+		journalRow, err = removeJournalRowFor(f, bs, sheetName, nameColumn, *row, amount)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if err := f.UpdateLinkedValue(); err != nil {
@@ -380,57 +536,961 @@ func (s *ExcelerizeSpreadsheetService) RemoveLastValueForCategory(source model.S
 	return &RemovedResult{
 		ModifiedSheet: bytes.NewBuffer(buffer.Bytes()),
 		OldValue:      val,
-		RemovedValue:  fmt.Sprintf("£%s", toRemove),
+		RemovedValue:  removedValue,
 		NewValue:      updatedVal,
+		Change: model.CellChange{
+			Sheet:          sheetName,
+			Cell:           cell,
+			Before:         before,
+			After:          after,
+			JournalRow:     journalRow,
+			JournalRemoved: journalRow != nil,
+		},
 	}, nil
 }
 
-// private
+func (s *ExcelerizeSpreadsheetService) CreateMonthSheet(source model.SpreadsheetSource, sheet io.Reader, month time.Time) (io.Reader, *string, error) {
+	bs := getBaseSpreadsheetSource(source)
+	name := month.Format(bs.GetSheetNameFormat())
 
-func getBaseSpreadsheetSource(source model.SpreadsheetSource) *model.BaseSpreadsheetSource {
-	switch s := source.(type) {
-	case *model.NextcloudSpreadsheetSource:
-		return &s.BaseSpreadsheetSource
-	case *model.BaseSpreadsheetSource:
-		return s
-	default:
-		zap.L().Panic("Spreadsheet source does not inherit from base spreadsheet source", zap.String("type", source.GetType()))
-		return nil
+	f, err := excelize.OpenReader(sheet, excelize.Options{})
+	if err != nil {
+		zap.L().Error("Failed to open spreadsheet", zap.Error(err))
+		return nil, nil, fmt.Errorf("Failed to open spreadsheet")
+	}
+
+	defer func() {
+		// Close the spreadsheet.
+		if err := f.Close(); err != nil {
+			zap.L().Error("Failed to close spreadsheet", zap.Error(err))
+		}
+	}()
+
+	if idx, err := f.GetSheetIndex(name); err != nil || idx != -1 {
+		zap.L().Warn("Sheet already exists or name is invalid", zap.String("name", name), zap.Error(err))
+		return nil, nil, fmt.Errorf("Sheet %s already exists", name)
+	}
+
+	// copy the current month into the new one, new sheets are always appended so it becomes the last tab. When
+	// sheets are picked by date this month doesn't have one yet so the last tab is copied
+	from, err := targetSheetName(f, bs, nil)
+	if err != nil {
+		from = currentSheetName(f, bs)
+	}
+	fromIdx, err := f.GetSheetIndex(from)
+	if err != nil {
+		zap.L().Error("Failed to get sheet to copy", zap.Error(err))
+		return nil, nil, fmt.Errorf("Failed to get sheet to copy")
+	}
+	toIdx, err := f.NewSheet(name)
+	if err != nil {
+		zap.L().Error("Failed to create new sheet", zap.String("name", name), zap.Error(err))
+		return nil, nil, fmt.Errorf("Failed to create new sheet")
+	}
+
+	if err := f.CopySheet(fromIdx, toIdx); err != nil {
+		zap.L().Error("Failed to copy sheet", zap.String("name", name), zap.Error(err))
+		return nil, nil, fmt.Errorf("Failed to copy sheet")
+	}
+
+	f.SetActiveSheet(toIdx)
+
+	if err := resetColumn(f, name, bs, false, bs.CostDefaults); err != nil {
+		return nil, nil, err
+	}
+
+	if len(bs.EarningNameColumn) > 0 && len(bs.EarningsValueColumn) > 0 {
+		if err := resetColumn(f, name, bs, true, bs.EarningDefaults); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := f.UpdateLinkedValue(); err != nil {
+		zap.L().Warn("Failed to updated linked values", zap.Error(err))
+	}
+
+	// return the spreadhseet as an io.Reader
+	var buffer bytes.Buffer
+	if err := f.Write(&buffer); err != nil {
+		zap.L().Error("Failed to write spreadsheet to buffer", zap.Error(err))
+		return nil, nil, fmt.Errorf("Failed to write spreadsheet")
+	}
+
+	// the copy shares the last sheet's comments, they are about last month's values anyway
+	detached, err := detachSheetComments(buffer.Bytes(), name)
+	if err != nil {
+		return nil, nil, err
 	}
+
+	return bytes.NewReader(detached), &name, nil
 }
 
-func getRowForCategory(source *model.BaseSpreadsheetSource, file *excelize.File, category string, sheetName string) (*uint, error) {
-	// iterate key column until find the category
-	currentRow := uint(1)
-	comparison := strings.ToLower(strings.ReplaceAll(category, " ", ""))
-	emptyCellCount := uint(0)
+func (s *ExcelerizeSpreadsheetService) RestoreCells(source model.SpreadsheetSource, sheet io.Reader, changes []model.CellChange) (io.Reader, error) {
+	bs := getBaseSpreadsheetSource(source)
 
-	for {
-		cell := fmt.Sprintf("%s%d", source.CostNameColumn, currentRow)
-		val, err := file.GetCellValue(sheetName, cell)
+	f, err := excelize.OpenReader(sheet, excelize.Options{})
+	if err != nil {
+		zap.L().Error("Failed to open spreadsheet", zap.Error(err))
+		return nil, fmt.Errorf("Failed to open spreadsheet")
+	}
+
+	defer func() {
+		// Close the spreadsheet.
+		if err := f.Close(); err != nil {
+			zap.L().Error("Failed to close spreadsheet", zap.Error(err))
+		}
+	}()
+
+	for _, change := range changes {
+		changed := &e.SpreadsheetError{
+			Type: e.SPREADSHEET_ERROR_TYPE_CHANGED,
+			Cell: fmt.Sprintf("%s!%s", change.Sheet, change.Cell),
+		}
+
+		// e.g. the tab was deleted
+		if index, err := f.GetSheetIndex(change.Sheet); err != nil || index == -1 {
+			return nil, changed
+		}
+
+		current, err := readCellState(f, change.Sheet, change.Cell)
 		if err != nil {
-			zap.L().Error("Failed to get value for cell", zap.String("cell", cell), zap.Error(err))
-			return nil, fmt.Errorf("Failed to get value for cell")
+			return nil, err
+		}
+
+		// don't overwrite edits made since
+		if current.Formula != change.After.Formula || current.Value != change.After.Value {
+			zap.L().Warn("Cell changed since, cannot restore", zap.String("cell", changed.Cell), zap.String("formula", current.Formula), zap.String("value", current.Value))
+			return nil, changed
+		}
+
+		if err := writeCellState(f, change.Sheet, change.Cell, current, change.Before); err != nil {
+			return nil, err
 		}
 
-		normVal := strings.ToLower(strings.ReplaceAll(val, " ", ""))
-		if normVal == comparison {
-			break
+		if change.JournalRow == nil {
+			continue
 		}
 
-		if len(normVal) == 0 {
-			emptyCellCount++
+		// an undone add takes its row with it, an undone remove puts it back
+		if change.JournalRemoved {
+			err = restoreJournalRow(f, bs, change.JournalRow)
 		} else {
-			emptyCellCount = 0
+			err = removeJournalRow(f, bs, change.JournalRow)
+		}
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	if err := f.UpdateLinkedValue(); err != nil {
+		zap.L().Warn("Failed to updated linked values", zap.Error(err))
+	}
+
+	// return the spreadhseet as an io.Reader
+	var buffer bytes.Buffer
+	if err := f.Write(&buffer); err != nil {
+		zap.L().Error("Failed to write spreadsheet to buffer", zap.Error(err))
+	}
+
+	return bytes.NewReader(buffer.Bytes()), nil
+}
+
+func (s *ExcelerizeSpreadsheetService) AddCategory(source model.SpreadsheetSource, sheet io.Reader, category string, earning bool) (io.Reader, error) {
+	bs := getBaseSpreadsheetSource(source)
+	nameColumn, valueColumn, err := getColumns(bs, earning)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := excelize.OpenReader(sheet, excelize.Options{})
+	if err != nil {
+		zap.L().Error("Failed to open spreadsheet", zap.Error(err))
+		return nil, fmt.Errorf("Failed to open spreadsheet")
+	}
 
-		if emptyCellCount >= MAX_EMPTY_CELL_COUNT {
-			zap.L().Warn("Category not found, quitting", zap.String("category", category))
-			return nil, fmt.Errorf("Category not found")
+	defer func() {
+		// Close the spreadsheet.
+		if err := f.Close(); err != nil {
+			zap.L().Error("Failed to close spreadsheet", zap.Error(err))
 		}
+	}()
+
+	sheetName, err := targetSheetName(f, bs, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := findCategoryBlock(f, sheetName, bs, earning)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.index(category) != -1 {
+		return nil, fmt.Errorf("%s already exists", category)
+	}
+
+	// new categories go under the last one in the last section, using the spare row above the totals if there is one
+	row := block.last() + 1
+	spare, err := isEmptyRow(f, sheetName, nameColumn, valueColumn, row)
+	if err != nil {
+		return nil, err
+	}
+
+	// inserting a row would leave configured rows pointing at the wrong place
+	fixedRows := bs.EndRow > 0 || (earning && bs.EarningsTotalRow > 0) || (!earning && bs.TotalRow > 0)
+	if (!spare && fixedRows) || (bs.EndRow > 0 && row > uint(bs.EndRow)) {
+		zap.L().Warn("No spare row for new category", zap.Uint("row", row))
+		return nil, fmt.Errorf("There is no spare row under the last category")
+	}
+
+	totals := block.Totals
+	if !spare {
+		if err := f.InsertRows(sheetName, int(row), 1); err != nil {
+			zap.L().Error("Failed to insert row", zap.Uint("row", row), zap.Error(err))
+			return nil, fmt.Errorf("Failed to insert row")
+		}
+		if totals > 0 {
+			totals++
+		}
+	}
+
+	// totals that stop at the last category need to include the new one
+	if totals > 0 {
+		if err := extendTotals(f, sheetName, valueColumn, totals, block.last()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := f.SetCellValue(sheetName, fmt.Sprintf("%s%d", nameColumn, row), category); err != nil {
+		zap.L().Error("Failed to set category name", zap.Error(err))
+		return nil, fmt.Errorf("Failed to set category name")
+	}
+
+	if err := f.SetCellValue(sheetName, fmt.Sprintf("%s%d", valueColumn, row), 0); err != nil {
+		zap.L().Error("Failed to set category value", zap.Error(err))
+		return nil, fmt.Errorf("Failed to set cell value")
+	}
+
+	if err := f.UpdateLinkedValue(); err != nil {
+		zap.L().Warn("Failed to updated linked values", zap.Error(err))
+	}
+
+	// return the spreadhseet as an io.Reader
+	var buffer bytes.Buffer
+	if err := f.Write(&buffer); err != nil {
+		zap.L().Error("Failed to write spreadsheet to buffer", zap.Error(err))
+	}
+
+	return bytes.NewReader(buffer.Bytes()), nil
+}
+
+func (s *ExcelerizeSpreadsheetService) RenameCategory(source model.SpreadsheetSource, sheet io.Reader, category string, newName string, earning bool) (io.Reader, error) {
+	bs := getBaseSpreadsheetSource(source)
+	nameColumn, _, err := getColumns(bs, earning)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := excelize.OpenReader(sheet, excelize.Options{})
+	if err != nil {
+		zap.L().Error("Failed to open spreadsheet", zap.Error(err))
+		return nil, fmt.Errorf("Failed to open spreadsheet")
+	}
+
+	defer func() {
+		// Close the spreadsheet.
+		if err := f.Close(); err != nil {
+			zap.L().Error("Failed to close spreadsheet", zap.Error(err))
+		}
+	}()
+
+	sheetName, err := targetSheetName(f, bs, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := findCategoryBlock(f, sheetName, bs, earning)
+	if err != nil {
+		return nil, err
+	}
+
+	index := block.index(category)
+	if index == -1 {
+		return nil, fmt.Errorf("Category not found")
+	}
+
+	// changing the case of the same category is fine
+	if existing := block.index(newName); existing != -1 && existing != index {
+		return nil, fmt.Errorf("%s already exists", newName)
+	}
+
+	if err := f.SetCellValue(sheetName, fmt.Sprintf("%s%d", nameColumn, block.Rows[index]), newName); err != nil {
+		zap.L().Error("Failed to set category name", zap.Error(err))
+		return nil, fmt.Errorf("Failed to set category name")
+	}
+
+	// return the spreadhseet as an io.Reader
+	var buffer bytes.Buffer
+	if err := f.Write(&buffer); err != nil {
+		zap.L().Error("Failed to write spreadsheet to buffer", zap.Error(err))
+	}
+
+	return bytes.NewReader(buffer.Bytes()), nil
+}
+
+func (s *ExcelerizeSpreadsheetService) DeleteCategory(source model.SpreadsheetSource, sheet io.Reader, category string, earning bool, force bool) (io.Reader, error) {
+	bs := getBaseSpreadsheetSource(source)
+	_, valueColumn, err := getColumns(bs, earning)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := excelize.OpenReader(sheet, excelize.Options{})
+	if err != nil {
+		zap.L().Error("Failed to open spreadsheet", zap.Error(err))
+		return nil, fmt.Errorf("Failed to open spreadsheet")
+	}
+
+	defer func() {
+		// Close the spreadsheet.
+		if err := f.Close(); err != nil {
+			zap.L().Error("Failed to close spreadsheet", zap.Error(err))
+		}
+	}()
+
+	sheetName, err := targetSheetName(f, bs, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := findCategoryBlock(f, sheetName, bs, earning)
+	if err != nil {
+		return nil, err
+	}
+
+	index := block.index(category)
+	if index == -1 {
+		return nil, fmt.Errorf("Category not found")
+	}
+
+	cell := fmt.Sprintf("%s%d", valueColumn, block.Rows[index])
+	if !force {
+		raw, err := f.CalcCellValue(sheetName, cell, excelize.Options{RawCellValue: true})
+		if err != nil {
+			zap.L().Error("Failed to calc cell value", zap.String("cell", cell), zap.Error(err))
+			return nil, fmt.Errorf("Failed to calc cell value")
+		}
+		if val, err := strconv.ParseFloat(raw, 64); err == nil && val != 0 {
+			return nil, &e.SpreadsheetError{
+				Type: e.SPREADSHEET_ERROR_TYPE_NOT_EMPTY,
+				Cell: fmt.Sprintf("%s!%s", sheetName, cell),
+			}
+		}
+	}
+
+	// move the categories below up one so the totals and anything beside the columns are left where they are. Only
+	// within the section so each subtotal keeps summing its own categories
+	columns, err := categoryColumns(bs, earning)
+	if err != nil {
+		return nil, err
+	}
+	_, end := block.section(index)
+	for i := index; i < end-1; i++ {
+		if err := moveCategory(f, sheetName, columns, block.Rows[i+1], block.Rows[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := clearCategory(f, sheetName, columns, block.Rows[end-1]); err != nil {
+		return nil, err
+	}
+
+	if err := f.UpdateLinkedValue(); err != nil {
+		zap.L().Warn("Failed to updated linked values", zap.Error(err))
+	}
+
+	// return the spreadhseet as an io.Reader
+	var buffer bytes.Buffer
+	if err := f.Write(&buffer); err != nil {
+		zap.L().Error("Failed to write spreadsheet to buffer", zap.Error(err))
+	}
+
+	return bytes.NewReader(buffer.Bytes()), nil
+}
+
+// private
+
+func getBaseSpreadsheetSource(source model.SpreadsheetSource) *model.BaseSpreadsheetSource {
+	switch s := source.(type) {
+	case *model.NextcloudSpreadsheetSource:
+		return &s.BaseSpreadsheetSource
+	case *model.GoogleSheetsSpreadsheetSource:
+		return &s.BaseSpreadsheetSource
+	case *model.FileSpreadsheetSource:
+		return &s.BaseSpreadsheetSource
+	case *model.BaseSpreadsheetSource:
+		return s
+	default:
+		zap.L().Panic("Spreadsheet source does not inherit from base spreadsheet source", zap.String("type", source.GetType()))
+		return nil
+	}
+}
+
+func getColumns(source *model.BaseSpreadsheetSource, earning bool) (string, string, error) {
+	if !earning {
+		return source.CostNameColumn, source.CostValueColumn, nil
+	}
+
+	if len(source.EarningNameColumn) == 0 || len(source.EarningsValueColumn) == 0 {
+		zap.L().Warn("Earnings requested but earnings columns are not configured")
+		return "", "", fmt.Errorf("Earnings columns not configured")
+	}
+
+	return source.EarningNameColumn, source.EarningsValueColumn, nil
+}
+
+func listEntries(file *excelize.File, sheetName string, source *model.BaseSpreadsheetSource, earning bool) ([]model.Entry, error) {
+	entries := []model.Entry{}
+
+	block, err := findCategoryBlock(file, sheetName, source, earning)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, row := range block.Rows {
+		category := block.Names[i]
+		valueCell := fmt.Sprintf("%s%d", block.ValueColumn, row)
+		value, err := calcFormattedValue(file, sheetName, valueCell, source)
+		if err != nil {
+			return nil, err
+		}
+
+		amount, err := calcAmount(file, sheetName, valueCell)
+		if err != nil {
+			return nil, err
+		}
+
+		var budget *float64
+		if !earning {
+			budget, err = budgetFor(file, sheetName, source, row, category)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, model.Entry{
+			Category: category,
+			Value:    value,
+			Earning:  earning,
+			Amount:   amount,
+			Budget:   budget,
+		})
+	}
+
+	if block.Totals == 0 {
+		return entries, nil
+	}
+
+	// the grand total goes last, named after its row e.g. Total
+	label, err := file.GetCellValue(sheetName, fmt.Sprintf("%s%d", block.NameColumn, block.Totals))
+	if err != nil {
+		zap.L().Error("Failed to get totals label", zap.Uint("row", block.Totals), zap.Error(err))
+		return nil, fmt.Errorf("Failed to get value for cell")
+	}
+	if len(strings.TrimSpace(label)) == 0 {
+		label = "Total"
+	}
+
+	totalCell := fmt.Sprintf("%s%d", block.ValueColumn, block.Totals)
+	value, err := calcFormattedValue(file, sheetName, totalCell, source)
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := calcAmount(file, sheetName, totalCell)
+	if err != nil {
+		return nil, err
+	}
+
+	entries = append(entries, model.Entry{
+		Category: label,
+		Value:    value,
+		Earning:  earning,
+		Amount:   amount,
+		Total:    true,
+	})
+
+	return entries, nil
+}
+
+func resetColumn(file *excelize.File, sheetName string, source *model.BaseSpreadsheetSource, earning bool, defaults map[string]float32) error {
+	// normalise the defaults the same way categories are compared
+	normDefaults := map[string]float32{}
+	for k, v := range defaults {
+		normDefaults[strings.ToLower(strings.ReplaceAll(k, " ", ""))] = v
+	}
+
+	block, err := findCategoryBlock(file, sheetName, source, earning)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range block.Rows {
+		valueCell := fmt.Sprintf("%s%d", block.ValueColumn, row)
+		form, err := file.GetCellFormula(sheetName, valueCell)
+		if err != nil {
+			zap.L().Error("Failed to get cell formula", zap.String("cell", valueCell), zap.Error(err))
+			return fmt.Errorf("Failed to get cell formula")
+		}
+
+		defaultValue, hasDefault := normDefaults[strings.ToLower(strings.ReplaceAll(block.Names[i], " ", ""))]
+		if len(form) == 0 && !hasDefault {
+			val, err := file.GetCellValue(sheetName, valueCell)
+			if err != nil {
+				zap.L().Error("Failed to get cell value", zap.String("cell", valueCell), zap.Error(err))
+				return fmt.Errorf("Failed to get cell value")
+			}
+
+			if !digitRegex.MatchString(val) {
+				// categories left blank stay blank
+				continue
+			}
+		}
+
+		// clear any formula before setting the value
+		if len(form) > 0 {
+			if err := file.SetCellFormula(sheetName, valueCell, ""); err != nil {
+				zap.L().Error("Failed to clear cell formula", zap.String("cell", valueCell), zap.Error(err))
+				return fmt.Errorf("Failed to clear cell formula")
+			}
+		}
+
+		if err := file.SetCellValue(sheetName, valueCell, defaultValue); err != nil {
+			zap.L().Error("Failed to reset cell value", zap.String("cell", valueCell), zap.Error(err))
+			return fmt.Errorf("Failed to reset cell value")
+		}
+	}
+
+	return nil
+}
+
+var (
+	legacyDrawingRegex        = regexp.MustCompile(`<legacyDrawing [^>]*(/>|>\s*</legacyDrawing>)`)
+	commentRelationshipRegex  = regexp.MustCompile(`<Relationship [^>]*Type="[^"]*/(comments|vmlDrawing)"[^>]*(/>|>\s*</Relationship>)`)
+	workbookSheetRegexFormat  = `<sheet [^>]*name="%s"[^>]*r:id="([^"]+)"`
+	workbookRelationshipRegex = `<Relationship [^>]*Id="%s"[^>]*Target="([^"]+)"`
+)
+
+// CopySheet points the copy at the original's comments so editing one edits both. This drops the comments
+// from the copy by removing its legacy drawing and the relationships to the comments.
+func detachSheetComments(data []byte, sheetName string) ([]byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		zap.L().Error("Failed to read spreadsheet zip", zap.Error(err))
+		return nil, fmt.Errorf("Failed to read spreadsheet")
+	}
+
+	files := map[string][]byte{}
+	for _, zf := range reader.File {
+		rc, err := zf.Open()
+		if err != nil {
+			zap.L().Error("Failed to open spreadsheet part", zap.String("part", zf.Name), zap.Error(err))
+			return nil, fmt.Errorf("Failed to read spreadsheet")
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			zap.L().Error("Failed to read spreadsheet part", zap.String("part", zf.Name), zap.Error(err))
+			return nil, fmt.Errorf("Failed to read spreadsheet")
+		}
+		files[zf.Name] = content
+	}
+
+	// find the sheet's xml from its name
+	sheetMatch := regexp.MustCompile(fmt.Sprintf(workbookSheetRegexFormat, regexp.QuoteMeta(escapeXml(sheetName)))).FindSubmatch(files["xl/workbook.xml"])
+	if sheetMatch == nil {
+		return data, nil
+	}
+	targetMatch := regexp.MustCompile(fmt.Sprintf(workbookRelationshipRegex, regexp.QuoteMeta(string(sheetMatch[1])))).FindSubmatch(files["xl/_rels/workbook.xml.rels"])
+	if targetMatch == nil {
+		return data, nil
+	}
+
+	sheetPath := strings.TrimPrefix(string(targetMatch[1]), "/")
+	if !strings.HasPrefix(sheetPath, "xl/") {
+		sheetPath = "xl/" + sheetPath
+	}
+	relsPath := path.Join(path.Dir(sheetPath), "_rels", path.Base(sheetPath)+".rels")
+
+	if _, ok := files[relsPath]; !ok {
+		// no relationships so no comments
+		return data, nil
+	}
+
+	files[sheetPath] = legacyDrawingRegex.ReplaceAll(files[sheetPath], nil)
+	files[relsPath] = commentRelationshipRegex.ReplaceAll(files[relsPath], nil)
+
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, zf := range reader.File {
+		w, err := writer.CreateHeader(&zip.FileHeader{Name: zf.Name, Method: zf.Method, Modified: zf.Modified})
+		if err != nil {
+			zap.L().Error("Failed to write spreadsheet part", zap.String("part", zf.Name), zap.Error(err))
+			return nil, fmt.Errorf("Failed to write spreadsheet")
+		}
+		if _, err := w.Write(files[zf.Name]); err != nil {
+			zap.L().Error("Failed to write spreadsheet part", zap.String("part", zf.Name), zap.Error(err))
+			return nil, fmt.Errorf("Failed to write spreadsheet")
+		}
+	}
+	if err := writer.Close(); err != nil {
+		zap.L().Error("Failed to close spreadsheet zip", zap.Error(err))
+		return nil, fmt.Errorf("Failed to write spreadsheet")
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func escapeXml(str string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(str))
+	return buffer.String()
+}
+
+// The cell comment and the journal note for the added term. The journal has a column for the amount so a converted
+// value only needs the comment, a note on its own starts with the term in the cell so REMOVE takes it off with it
+func (a AddValue) comments(term string) (string, string) {
+	switch {
+	case len(a.Note) == 0:
+		return a.Comment, a.Comment
+	case len(a.Comment) > 0:
+		comment := fmt.Sprintf("%s, %s", a.Comment, a.Note)
+		return comment, comment
+	default:
+		return fmt.Sprintf("%s %s", strings.TrimPrefix(term, "+"), a.Note), a.Note
+	}
+}
+
+// The text to append to a formula for a value, the sign comes from the value so refunds become e.g. 10-5.00.
+// Sums are only kept when asked for and are bracketed so they can be removed as one.
+func formulaTerm(value float32, expression string, writeExpression bool) string {
+	if writeExpression && len(expression) > 0 {
+		// a refund of a sum is the sum taken away
+		exprVal, err := utils.EvaluateExpression(expression)
+		if err == nil && (exprVal < 0) != (value < 0) {
+			return fmt.Sprintf("-(%s)", expression)
+		}
+		return fmt.Sprintf("+(%s)", expression)
+	}
+
+	if value < 0 {
+		return fmt.Sprintf("-%.2f", -value)
+	}
+	return fmt.Sprintf("+%.2f", value)
+}
+
+// Index of the last + or - joining two terms outside of brackets, -1 if there isn't one.
+// Signs straight after another operator or a bracket are negatives e.g. 10*-2 rather than a new term.
+func lastTermIndex(formula string) int {
+	depth := 0
+	for i := len(formula) - 1; i >= 0; i-- {
+		switch formula[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+		case '+', '-':
+			if depth == 0 && i > 0 && !strings.ContainsRune("+-*/(^&=<>,", rune(formula[i-1])) {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// Formats a removed term e.g. -5.00 as -£5.00 and +(12.50+3.20) as £15.70 (12.50+3.20)
+func formatTerm(term string, source *model.BaseSpreadsheetSource) string {
+	body := strings.TrimLeft(term, "+-")
+
+	val, err := utils.EvaluateExpression(body)
+	if err != nil {
+		// references to other cells or functions, show as is
+		return strings.TrimPrefix(term, "+")
+	}
+
+	if strings.HasPrefix(term, "-") {
+		val = -val
+	}
+	formatted := utils.FormatAmount(val, source.GetCurrency(), source.GetLocale())
+
+	if utils.IsExpression(body) {
+		if isBracketed(body) {
+			body = body[1 : len(body)-1]
+		}
+		return fmt.Sprintf("%s (%s)", formatted, body)
+	}
+	return formatted
+}
+
+// Calculates a cell and formats it in the sheet's currency, anything that isn't a number (e.g. headers) is left as is
+func calcFormattedValue(file *excelize.File, sheetName string, cell string, source *model.BaseSpreadsheetSource) (string, error) {
+	raw, err := file.CalcCellValue(sheetName, cell, excelize.Options{RawCellValue: true})
+	if err != nil {
+		zap.L().Error("Failed to calc cell value", zap.String("cell", cell), zap.Error(err))
+		return "", fmt.Errorf("Failed to calc cell value")
+	}
+
+	val, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return raw, nil
+	}
+
+	return utils.FormatAmount(val, source.GetCurrency(), source.GetLocale()), nil
+}
+
+// The cell's value as a number, 0 for anything that isn't one e.g. headers
+func calcAmount(file *excelize.File, sheetName string, cell string) (float64, error) {
+	raw, err := file.CalcCellValue(sheetName, cell, excelize.Options{RawCellValue: true})
+	if err != nil {
+		zap.L().Error("Failed to calc cell value", zap.String("cell", cell), zap.Error(err))
+		return 0, fmt.Errorf("Failed to calc cell value")
+	}
+
+	val, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, nil
+	}
+
+	return val, nil
+}
+
+// A cost category's budget from the budget column on its row, otherwise from the config. Nil when there isn't one
+func budgetFor(file *excelize.File, sheetName string, source *model.BaseSpreadsheetSource, row uint, category string) (*float64, error) {
+	if len(source.BudgetColumn) > 0 {
+		cell := fmt.Sprintf("%s%d", source.BudgetColumn, row)
+		raw, err := file.CalcCellValue(sheetName, cell, excelize.Options{RawCellValue: true})
+		if err != nil {
+			zap.L().Error("Failed to calc budget", zap.String("cell", cell), zap.Error(err))
+			return nil, fmt.Errorf("Failed to calc budget")
+		}
+		if budget, err := strconv.ParseFloat(raw, 64); err == nil && budget > 0 {
+			return &budget, nil
+		}
+	}
+
+	comparison := strings.ToLower(strings.ReplaceAll(category, " ", ""))
+	for name, value := range source.Budgets {
+		if strings.ToLower(strings.ReplaceAll(name, " ", "")) == comparison && value > 0 {
+			budget := float64(value)
+			return &budget, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Reads what a cell holds so the change to it can be undone
+func readCellState(file *excelize.File, sheetName string, cell string) (model.CellState, error) {
+	formula, err := file.GetCellFormula(sheetName, cell)
+	if err != nil {
+		zap.L().Error("Failed to get cell formula", zap.Error(err))
+		return model.CellState{}, fmt.Errorf("Failed to get cell formula")
+	}
+
+	state := model.CellState{
+		Formula: formula,
+	}
+
+	// a formula's value is only a cached result
+	if len(formula) == 0 {
+		value, err := file.GetCellValue(sheetName, cell, excelize.Options{RawCellValue: true})
+		if err != nil {
+			zap.L().Error("Failed to get cell value", zap.Error(err))
+			return model.CellState{}, fmt.Errorf("Failed to get cell value")
+		}
+		state.Value = value
+	}
+
+	comment, err := getCellComment(file, sheetName, cell)
+	if err != nil {
+		return model.CellState{}, err
+	}
+	state.Comment = comment
+
+	return state, nil
+}
+
+func writeCellState(file *excelize.File, sheetName string, cell string, current model.CellState, state model.CellState) error {
+	if len(state.Formula) > 0 {
+		if err := file.SetCellFormula(sheetName, cell, state.Formula); err != nil {
+			zap.L().Error("Failed to set the cell's formula", zap.Error(err), zap.String("formula", state.Formula))
+			return fmt.Errorf("Failed to set cell formula")
+		}
+	} else {
+		// an empty formula removes it
+		if len(current.Formula) > 0 {
+			if err := file.SetCellFormula(sheetName, cell, ""); err != nil {
+				zap.L().Error("Failed to remove the cell's formula", zap.Error(err))
+				return fmt.Errorf("Failed to set cell formula")
+			}
+		}
+
+		var value any
+		if f, err := strconv.ParseFloat(state.Value, 64); err == nil {
+			value = f
+		} else if len(state.Value) > 0 {
+			value = state.Value
+		}
+		if err := file.SetCellValue(sheetName, cell, value); err != nil {
+			zap.L().Error("Failed to set the cell's value", zap.Error(err))
+			return fmt.Errorf("Failed to set cell value")
+		}
+	}
+
+	if current.Comment != state.Comment {
+		return setCellComment(file, sheetName, cell, state.Comment)
+	}
+
+	return nil
+}
+
+func getCellComment(file *excelize.File, sheetName string, cell string) (string, error) {
+	comments, err := file.GetComments(sheetName)
+	if err != nil {
+		zap.L().Error("Failed to get comments", zap.Error(err))
+		return "", fmt.Errorf("Failed to get comments")
+	}
+
+	for _, c := range comments {
+		if c.Cell == cell {
+			return commentText(c), nil
+		}
+	}
+
+	return "", nil
+}
+
+func commentText(c excelize.Comment) string {
+	if len(c.Text) > 0 {
+		return c.Text
+	}
+
+	// comments read back from a file are in runs
+	var text strings.Builder
+	for _, run := range c.Paragraph {
+		text.WriteString(run.Text)
+	}
+	return text.String()
+}
+
+// Replaces the cell's comment, an empty comment removes it
+func setCellComment(file *excelize.File, sheetName string, cell string, comment string) error {
+	existing, err := getCellComment(file, sheetName, cell)
+	if err != nil {
+		return err
+	}
+
+	if len(existing) > 0 {
+		if err := file.DeleteComment(sheetName, cell); err != nil {
+			zap.L().Error("Failed to delete comment", zap.String("cell", cell), zap.Error(err))
+			return fmt.Errorf("Failed to delete comment")
+		}
+	}
+
+	if len(comment) == 0 {
+		return nil
+	}
+
+	if err := file.AddComment(sheetName, excelize.Comment{Cell: cell, Author: COMMENT_AUTHOR, Text: comment}); err != nil {
+		zap.L().Error("Failed to add comment", zap.String("cell", cell), zap.Error(err))
+		return fmt.Errorf("Failed to add comment")
+	}
+
+	return nil
+}
+
+// Removes the cell's comment if the bot wrote it, anyone else's are left alone
+func clearCellComment(file *excelize.File, sheetName string, cell string) error {
+	comments, err := file.GetComments(sheetName)
+	if err != nil {
+		zap.L().Error("Failed to get comments", zap.Error(err))
+		return fmt.Errorf("Failed to get comments")
+	}
+
+	for _, c := range comments {
+		if c.Cell == cell && c.Author == COMMENT_AUTHOR {
+			return setCellComment(file, sheetName, cell, "")
+		}
+	}
+
+	return nil
+}
+
+// Comments are one line per value so they can be removed with it
+func appendCellComment(file *excelize.File, sheetName string, cell string, line string) error {
+	existing, err := getCellComment(file, sheetName, cell)
+	if err != nil {
+		return err
+	}
+
+	if len(existing) > 0 {
+		line = existing + "\n" + line
+	}
+
+	return setCellComment(file, sheetName, cell, line)
+}
+
+// Removes the last comment line if it is about the removed term, either as written e.g. +12.50 and "12.50 lunch" or
+// formatted e.g. +19.75 and "£19.75 was $25.00"
+func removeCellCommentFor(file *excelize.File, sheetName string, cell string, term string, formatted string) error {
+	existing, err := getCellComment(file, sheetName, cell)
+	if err != nil || len(existing) == 0 {
+		return err
+	}
+
+	lines := strings.Split(existing, "\n")
+	last := lines[len(lines)-1]
+	if !strings.HasPrefix(last, strings.TrimPrefix(term, "+")+" ") && !strings.HasPrefix(last, formatted+" ") {
+		return nil
+	}
+
+	return setCellComment(file, sheetName, cell, strings.Join(lines[:len(lines)-1], "\n"))
+}
+
+// Whether the whole of a formula is in one pair of brackets e.g. (1+2) but not (1+2)*(3+4)
+func isBracketed(formula string) bool {
+	if !strings.HasPrefix(formula, "(") {
+		return false
+	}
+
+	depth := 0
+	for i, c := range formula {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i == len(formula)-1
+			}
+		}
+	}
+
+	return false
+}
+
+func getRowForCategory(file *excelize.File, sheetName string, source *model.BaseSpreadsheetSource, category string, earning bool) (*uint, error) {
+	block, err := findCategoryBlock(file, sheetName, source, earning)
+	if err != nil {
+		return nil, err
+	}
 
-		currentRow++
+	index := block.index(category)
+	if index == -1 {
+		zap.L().Warn("Category not found, quitting", zap.String("category", category))
+		return nil, fmt.Errorf("Category not found")
 	}
 
-	return &currentRow, nil
+	return &block.Rows[index], nil
 }
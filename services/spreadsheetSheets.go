@@ -0,0 +1,83 @@
+package services
+
+import (
+	e "telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/model"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/zap"
+)
+
+// The tab a command works on, the month's tab when one is given otherwise the one the source's sheetSelection picks
+func targetSheetName(file *excelize.File, source *model.BaseSpreadsheetSource, month *time.Time) (string, error) {
+	if month != nil {
+		return monthSheetName(file, source, *month)
+	}
+
+	switch source.GetSheetSelection() {
+	case model.SHEET_SELECTION_PATTERN:
+		// the newest month rather than the last tab so tabs can be reordered
+		newest := ""
+		var newestMonth time.Time
+		for _, name := range file.GetSheetList() {
+			parsed, err := time.Parse(source.GetSheetNameFormat(), name)
+			if err != nil || name == source.JournalSheet {
+				continue
+			}
+			if len(newest) == 0 || parsed.After(newestMonth) {
+				newest = name
+				newestMonth = parsed
+			}
+		}
+		if len(newest) == 0 {
+			zap.L().Warn("No sheet is named like the sheet name format", zap.String("format", source.GetSheetNameFormat()))
+			return "", &e.SpreadsheetError{
+				Type: e.SPREADSHEET_ERROR_TYPE_NO_SHEET,
+				Cell: source.GetSheetNameFormat(),
+			}
+		}
+		return newest, nil
+	case model.SHEET_SELECTION_DATE:
+		return monthSheetName(file, source, time.Now())
+	case model.SHEET_SELECTION_FIXED:
+		if idx, err := file.GetSheetIndex(source.SheetName); err != nil || idx == -1 {
+			zap.L().Warn("Fixed sheet not found", zap.String("name", source.SheetName), zap.Error(err))
+			return "", &e.SpreadsheetError{
+				Type: e.SPREADSHEET_ERROR_TYPE_NO_SHEET,
+				Cell: source.SheetName,
+			}
+		}
+		return source.SheetName, nil
+	default:
+		return currentSheetName(file, source), nil
+	}
+}
+
+// The last tab that isn't the journal, new months are always appended so this is the current month
+func currentSheetName(file *excelize.File, source *model.BaseSpreadsheetSource) string {
+	sheets := file.GetSheetList()
+	for i := len(sheets) - 1; i >= 0; i-- {
+		if sheets[i] != source.JournalSheet {
+			return sheets[i]
+		}
+	}
+
+	return sheets[len(sheets)-1]
+}
+
+// private
+
+// The tab named after the month with the source's sheetNameFormat e.g. Mar 2026
+func monthSheetName(file *excelize.File, source *model.BaseSpreadsheetSource, month time.Time) (string, error) {
+	name := month.Format(source.GetSheetNameFormat())
+	if idx, err := file.GetSheetIndex(name); err == nil && idx != -1 {
+		return name, nil
+	}
+
+	zap.L().Warn("No sheet for month", zap.String("name", name))
+	return "", &e.SpreadsheetError{
+		Type: e.SPREADSHEET_ERROR_TYPE_NO_SHEET,
+		Cell: name,
+	}
+}
@@ -14,9 +14,17 @@ import (
 	"go.uber.org/zap"
 )
 
+// Keeps the dialog (multi-step command) each user has in progress in a chat and the history of changes to each
+// spreadsheet, keyed by the source key
 type IStorageService interface {
-	StoreCommand(command *model.Command, userId int64) error
-	GetPreviousCommand(userId int64) (*model.Command, error)
+	StoreDialog(dialog *model.Dialog) error
+	GetDialog(chatId int64, userId int64) (*model.Dialog, error)
+	ClearDialog(chatId int64, userId int64) error
+	AddMutation(key string, mutation *model.Mutation) error
+	// newest first
+	GetMutations(key string, count int) ([]model.Mutation, error)
+	// removes these ones e.g. once they have been undone, wherever they are in the history
+	RemoveMutations(key string, mutations []model.Mutation) error
 }
 
 type ValkeyStorageService struct {
@@ -27,6 +35,15 @@ const (
 	VALKEY_HOST_KEY string = "VALKEY_HOST"
 )
 
+const (
+	DIALOG_KEY_PREFIX string = "dialog:"
+	// expired dialogs are kept this long so a late reply is told it expired rather than not understood
+	DIALOG_EXPIRED_GRACE time.Duration = time.Hour * 24
+	HISTORY_KEY_PREFIX   string        = "history:"
+	// older changes are forgotten
+	MAX_HISTORY int = 100
+)
+
 func NewValkeyStorageService() *ValkeyStorageService {
 	client, err := valkey.NewClient(valkey.ClientOption{
 		InitAddress: []string{
@@ -42,51 +59,145 @@ func NewValkeyStorageService() *ValkeyStorageService {
 	}
 }
 
-func (s *ValkeyStorageService) StoreCommand(command *model.Command, userId int64) error {
+func (s *ValkeyStorageService) StoreDialog(dialog *model.Dialog) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
-	json, err := json.Marshal(command)
+	json, err := json.Marshal(dialog)
 	if err != nil {
-		zap.L().DPanic("Failed to serialise command", zap.Error(err))
-		return fmt.Errorf("Failed to serialise command")
+		zap.L().DPanic("Failed to serialise dialog", zap.Error(err))
+		return fmt.Errorf("Failed to serialise dialog")
 	}
 
-	key := strconv.FormatInt(userId, 10)
-	if err := s.Client.Do(ctx, s.Client.B().Set().Key(key).Value(string(json)).Build()).Error(); err != nil {
-		zap.L().Error("Failed to set command for user", zap.Error(err))
-		return fmt.Errorf("Failed to set command for user")
+	ttl := time.Until(dialog.ExpiresAt) + DIALOG_EXPIRED_GRACE
+	if err := s.Client.Do(ctx, s.Client.B().Set().Key(dialogKey(dialog.ChatId, dialog.UserId)).Value(string(json)).Ex(ttl).Build()).Error(); err != nil {
+		zap.L().Error("Failed to set dialog for user", zap.Error(err))
+		return fmt.Errorf("Failed to set dialog for user")
 	}
 
 	return nil
 }
 
-func (s *ValkeyStorageService) GetPreviousCommand(userId int64) (*model.Command, error) {
+func (s *ValkeyStorageService) GetDialog(chatId int64, userId int64) (*model.Dialog, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
-	jsonStr, err := s.Client.Do(ctx, s.Client.B().Get().Key(strconv.FormatInt(userId, 10)).Build()).ToString()
+	jsonStr, err := s.Client.Do(ctx, s.Client.B().Get().Key(dialogKey(chatId, userId)).Build()).ToString()
 	if err != nil {
 		if err == valkey.Nil {
 			return nil, &errors.StorageError{
 				Type: errors.STORAGE_ERROR_TYPE_NOT_FOUND,
 			}
 		}
-		zap.L().Error("Failed to get command for user", zap.Error(err))
-		return nil, fmt.Errorf("Failed to get command for user")
+		zap.L().Error("Failed to get dialog for user", zap.Error(err))
+		return nil, fmt.Errorf("Failed to get dialog for user")
 	}
 
-	// don't actually need this as we are always overwriting anyway but leaving here as a good exampe
-	// if _, err := s.Client.Expire(ctx, strconv.FormatInt(userId, 10), time.Minute*15).Result(); err != nil {
-	// 	zap.L().Error("Failed to update expiry for command", zap.Error(err))
-	// 	return nil, fmt.Errorf("Failed to update expiry for command")
-	// }
+	var dialog model.Dialog
+	if err := json.Unmarshal([]byte(jsonStr), &dialog); err != nil {
+		zap.L().DPanic("Failed to unmarshal json dialog", zap.Error(err))
+		return nil, fmt.Errorf("Failed to unmarshal json dialog")
+	}
+
+	return &dialog, nil
+}
+
+func (s *ValkeyStorageService) ClearDialog(chatId int64, userId int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
 
-	var command model.Command
-	if err := json.Unmarshal([]byte(jsonStr), &command); err != nil {
-		zap.L().DPanic("Failed to unmarshal json command", zap.Error(err))
-		return nil, fmt.Errorf("Failed to unmarshal json command")
+	if err := s.Client.Do(ctx, s.Client.B().Del().Key(dialogKey(chatId, userId)).Build()).Error(); err != nil {
+		zap.L().Error("Failed to clear dialog for user", zap.Error(err))
+		return fmt.Errorf("Failed to clear dialog for user")
 	}
 
-	return &command, nil
+	return nil
+}
+
+func (s *ValkeyStorageService) AddMutation(key string, mutation *model.Mutation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	json, err := json.Marshal(mutation)
+	if err != nil {
+		zap.L().DPanic("Failed to serialise mutation", zap.Error(err))
+		return fmt.Errorf("Failed to serialise mutation")
+	}
+
+	historyKey := HISTORY_KEY_PREFIX + key
+	for _, res := range s.Client.DoMulti(ctx,
+		s.Client.B().Lpush().Key(historyKey).Element(string(json)).Build(),
+		s.Client.B().Ltrim().Key(historyKey).Start(0).Stop(int64(MAX_HISTORY-1)).Build(),
+	) {
+		if err := res.Error(); err != nil {
+			zap.L().Error("Failed to add mutation", zap.Error(err))
+			return fmt.Errorf("Failed to add mutation")
+		}
+	}
+
+	return nil
+}
+
+func (s *ValkeyStorageService) GetMutations(key string, count int) ([]model.Mutation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	values, err := s.Client.Do(ctx, s.Client.B().Lrange().Key(HISTORY_KEY_PREFIX+key).Start(0).Stop(int64(count-1)).Build()).AsStrSlice()
+	if err != nil {
+		zap.L().Error("Failed to get mutations", zap.Error(err))
+		return nil, fmt.Errorf("Failed to get mutations")
+	}
+
+	mutations := make([]model.Mutation, len(values))
+	for i, v := range values {
+		if err := json.Unmarshal([]byte(v), &mutations[i]); err != nil {
+			zap.L().DPanic("Failed to unmarshal json mutation", zap.Error(err))
+			return nil, fmt.Errorf("Failed to unmarshal json mutation")
+		}
+	}
+
+	return mutations, nil
+}
+
+func (s *ValkeyStorageService) RemoveMutations(key string, mutations []model.Mutation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	historyKey := HISTORY_KEY_PREFIX + key
+	values, err := s.Client.Do(ctx, s.Client.B().Lrange().Key(historyKey).Start(0).Stop(-1).Build()).AsStrSlice()
+	if err != nil {
+		zap.L().Error("Failed to get mutations", zap.Error(err))
+		return fmt.Errorf("Failed to remove mutations")
+	}
+
+	// list elements are removed by value so the stored json is used as is
+	commands := valkey.Commands{}
+	for _, v := range values {
+		var stored model.Mutation
+		if err := json.Unmarshal([]byte(v), &stored); err != nil {
+			zap.L().DPanic("Failed to unmarshal json mutation", zap.Error(err))
+			return fmt.Errorf("Failed to remove mutations")
+		}
+		if len(model.WithoutMutations([]model.Mutation{stored}, mutations)) == 0 {
+			commands = append(commands, s.Client.B().Lrem().Key(historyKey).Count(1).Element(v).Build())
+		}
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+
+	for _, res := range s.Client.DoMulti(ctx, commands...) {
+		if err := res.Error(); err != nil {
+			zap.L().Error("Failed to remove mutations", zap.Error(err))
+			return fmt.Errorf("Failed to remove mutations")
+		}
+	}
+
+	return nil
+}
+
+// private
+
+func dialogKey(chatId int64, userId int64) string {
+	return DIALOG_KEY_PREFIX + strconv.FormatInt(chatId, 10) + ":" + strconv.FormatInt(userId, 10)
 }
@@ -29,18 +29,20 @@ func (s *TelegramService) GetCommandFromMessage(message *model.Message) (*model.
 	update := message.TelegramMessage.Update
 	bot := message.TelegramMessage.Bot
 
-	var userId int64
-	if update.CallbackQuery != nil {
-		userId = update.CallbackQuery.From.ID
-	} else {
-		userId = update.Message.From.ID
+	userId := message.TelegramMessage.SenderId()
+	chat := message.TelegramMessage.Chat()
+	if chat == nil {
+		zap.L().Warn("Telegram update has no chat", zap.Int64("userId", userId))
+		return nil, &e.CommandError{Silent: true}
 	}
 
-	if message.TelegramMessage.UserId != userId {
-		zap.L().Warn("User not allowed", zap.Int64("userId", userId))
+	if _, ok := message.TelegramMessage.Members[userId]; !ok {
+		zap.L().Warn("User not allowed", zap.Int64("userId", userId), zap.Int64("chatId", chat.ID))
 		return nil, &e.CommandError{
-			Unauthorized:    true,
-			ChatId:          update.Message.Chat.ID,
+			Unauthorized: true,
+			// everyone else in a group chat is talking to each other rather than the bot
+			Silent:          !chat.IsPrivate(),
+			ChatId:          chat.ID,
 			ResponseMessage: "",
 		}
 	}
@@ -64,8 +66,13 @@ func (s *TelegramService) GetCommandFromMessage(message *model.Message) (*model.
 		return command, nil
 	}
 
-	command, err := model.CommandFromMessage(update.Message.Text, update.Message.Chat.ID, update.Message.MessageID, userId)
+	command, err := model.CommandFromMessage(update.Message.Text, update.Message.Chat.ID, update.Message.MessageID, userId, getLocale(message.UserName))
 	if err != nil {
+		// members of a group chat talk to each other too, and joins e.t.c. have no text, so only commands get a reply
+		if commandErr, ok := err.(*e.CommandError); ok && !chat.IsPrivate() {
+			zap.L().Debug("Ignoring group chat message that isn't a command", zap.Int64("chatId", chat.ID))
+			commandErr.Silent = true
+		}
 		return nil, err
 	}
 
@@ -83,12 +90,7 @@ func (s *TelegramService) SendTextMessage(m *model.Message, chatId int64, messag
 }
 
 func (s *TelegramService) SendEntryList(m *model.Message, chatId int64, entries *[]model.Entry) error {
-	var builder strings.Builder
-	for _, e := range *entries {
-		fmt.Fprintf(&builder, "%s %s\n", e.Category, e.Value)
-	}
-
-	msg := tgbotapi.NewMessage(chatId, builder.String())
+	msg := tgbotapi.NewMessage(chatId, formatEntryList(entries))
 	if _, err := m.TelegramMessage.Bot.Send(msg); err != nil {
 		zap.L().Error("Failed to send telegram entries message", zap.Error(err))
 		return fmt.Errorf("Failed to send bot entries message")
@@ -102,13 +104,18 @@ func (s *TelegramService) SendCategorySelectionKeyboard(m *model.Message, chatId
 	buttonRows := [][]tgbotapi.InlineKeyboardButton{}
 
 	for i, e := range *entries {
-		currentButtons = append(currentButtons, tgbotapi.NewInlineKeyboardButtonData(e.Category, fmt.Sprintf("%s:%s", command, e.Category)))
+		currentButtons = append(currentButtons, tgbotapi.NewInlineKeyboardButtonData(categoryLabel(e), model.CallbackData(command, e)))
 		if (i+1)%3 == 0 {
 			buttonRows = append(buttonRows, currentButtons)
 			currentButtons = make([]tgbotapi.InlineKeyboardButton, 0)
 		}
 	}
 
+	// don't drop a part filled last row
+	if len(currentButtons) > 0 {
+		buttonRows = append(buttonRows, currentButtons)
+	}
+
 	msg := tgbotapi.NewMessage(chatId, "Please choose a category:")
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(buttonRows...)
 
@@ -129,3 +136,60 @@ func (s *TelegramService) RemoveMarkupFromMessage(m *model.Message, chatId int64
 
 	return nil
 }
+
+// private
+
+// Costs then earnings, one per line
+func formatEntryList(entries *[]model.Entry) string {
+	var builder strings.Builder
+	hasEarnings := false
+	for _, e := range *entries {
+		if e.Earning {
+			hasEarnings = true
+			continue
+		}
+		writeEntryLine(&builder, e)
+	}
+
+	if hasEarnings {
+		builder.WriteString("\nEarnings\n")
+		for _, e := range *entries {
+			if e.Earning {
+				writeEntryLine(&builder, e)
+			}
+		}
+	}
+
+	return builder.String()
+}
+
+// earnings are marked so they can be told apart from costs with the same name
+func categoryLabel(e model.Entry) string {
+	if e.Earning {
+		return fmt.Sprintf("\U0001F4B0 %s", e.Category)
+	}
+	return e.Category
+}
+
+// totals are set apart from the categories above them
+func writeEntryLine(builder *strings.Builder, e model.Entry) {
+	if e.Total {
+		fmt.Fprintf(builder, "\n%s %s\n", e.Category, e.Value)
+		return
+	}
+	fmt.Fprintf(builder, "%s %s\n", e.Category, e.Value)
+}
+
+func getLocale(userName string) string {
+	config := model.GetConfig()
+	if config == nil {
+		return model.DEFAULT_LOCALE
+	}
+
+	user := config.GetUser(userName)
+	if user == nil || user.SpreadsheetSource == nil {
+		return model.DEFAULT_LOCALE
+	}
+
+	return user.SpreadsheetSource.GetLocale()
+}
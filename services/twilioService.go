@@ -0,0 +1,157 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	e "telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/utils"
+
+	"go.uber.org/zap"
+)
+
+// Sends WhatsApp and SMS messages
+type ITwilioClient interface {
+	SendMessage(from string, to string, body string) error
+}
+
+// Sends messages with the twilio REST API
+type TwilioRestClient struct {
+	Http       utils.IHttpClient
+	AccountSid string
+	AuthToken  string
+	// optional, defaults to the twilio API
+	BaseUrl string
+}
+
+type twilioMessageResponse struct {
+	Sid    string `json:"sid"`
+	Status string `json:"status"`
+}
+
+// Messaging for WhatsApp and SMS, there are no keyboards so categories are sent as a numbered menu and the
+// number sent back picks one
+type TwilioService struct {
+	Client ITwilioClient
+
+	mu sync.Mutex
+	// the callback data for each numbered choice of the last menu sent to a chat
+	menus map[int64][]string
+}
+
+const (
+	DEFAULT_TWILIO_BASE_URL string = "https://api.twilio.com"
+)
+
+func (c *TwilioRestClient) SendMessage(from string, to string, body string) error {
+	baseUrl := c.BaseUrl
+	if len(baseUrl) == 0 {
+		baseUrl = DEFAULT_TWILIO_BASE_URL
+	}
+
+	form := url.Values{
+		"From": {from},
+		"To":   {to},
+		"Body": {body},
+	}
+
+	var response twilioMessageResponse
+	res, err := c.Http.Post(fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", strings.TrimSuffix(baseUrl, "/"), url.PathEscape(c.AccountSid)), bytes.NewBufferString(form.Encode()), &response, &utils.HttpOptions{
+		BasicAuthUser:     &c.AccountSid,
+		BasicAuthPassword: &c.AuthToken,
+		ContentType:       "application/x-www-form-urlencoded",
+	})
+	if err != nil {
+		zap.L().Error("Failed to send twilio message", zap.Error(err))
+		return fmt.Errorf("Failed to send twilio message")
+	}
+
+	if res.StatusCode > 299 {
+		zap.L().Error("Twilio refused the message", zap.Int("status", res.StatusCode))
+		return fmt.Errorf("Failed to send twilio message")
+	}
+
+	return nil
+}
+
+func (s *TwilioService) GetCommandFromMessage(message *model.Message) (*model.Command, error) {
+	if message.TwilioMessage == nil {
+		zap.L().DPanic("Twilio service given a message from another input")
+		return nil, fmt.Errorf("Twilio service given a message from another input")
+	}
+
+	id := message.TwilioMessage.Id
+	body := strings.TrimSpace(message.TwilioMessage.Body)
+
+	// a number while a menu is waiting picks from it, anything else is a new command
+	menu := s.getMenu(id)
+	if choice, err := strconv.Atoi(body); err == nil && len(menu) > 0 {
+		if choice < 1 || choice > len(menu) {
+			return nil, &e.CommandError{
+				ResponseMessage: fmt.Sprintf("Please send a number from 1 to %d, or CANCEL.", len(menu)),
+				ChatId:          id,
+			}
+		}
+
+		s.clearMenu(id)
+		return model.CommandFromCallback(menu[choice-1], id, 0, id)
+	}
+	s.clearMenu(id)
+
+	return model.CommandFromMessage(body, id, 0, id, getLocale(message.UserName))
+}
+
+func (s *TwilioService) SendTextMessage(m *model.Message, chatId int64, message string) error {
+	return s.Client.SendMessage(m.TwilioMessage.To, m.TwilioMessage.From, message)
+}
+
+func (s *TwilioService) SendEntryList(m *model.Message, chatId int64, entries *[]model.Entry) error {
+	return s.Client.SendMessage(m.TwilioMessage.To, m.TwilioMessage.From, formatEntryList(entries))
+}
+
+func (s *TwilioService) SendCategorySelectionKeyboard(m *model.Message, chatId int64, entries *[]model.Entry, command string) error {
+	var builder strings.Builder
+	builder.WriteString("Please choose a category by sending its number:\n")
+
+	menu := make([]string, len(*entries))
+	for i, e := range *entries {
+		fmt.Fprintf(&builder, "%d. %s\n", i+1, categoryLabel(e))
+		menu[i] = model.CallbackData(command, e)
+	}
+
+	if err := s.Client.SendMessage(m.TwilioMessage.To, m.TwilioMessage.From, strings.TrimSuffix(builder.String(), "\n")); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.menus == nil {
+		s.menus = map[int64][]string{}
+	}
+	s.menus[chatId] = menu
+
+	return nil
+}
+
+func (s *TwilioService) RemoveMarkupFromMessage(m *model.Message, chatId int64, messageId int) error {
+	s.clearMenu(chatId)
+	return nil
+}
+
+// private
+
+func (s *TwilioService) getMenu(chatId int64) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.menus[chatId]
+}
+
+func (s *TwilioService) clearMenu(chatId int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.menus, chatId)
+}
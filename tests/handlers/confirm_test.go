@@ -0,0 +1,26 @@
+package tests
+
+import (
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ReadOnlyYesHasNothingToConfirm(t *testing.T) {
+	// given
+	handler, _, _ := newHandler(t)
+	user := model.GetConfig().GetUser("Rob")
+	user.Role = model.ROLE_READ_ONLY
+	t.Cleanup(func() {
+		user.Role = ""
+	})
+
+	// when
+	handler.HandleMessage(robMessage("y"))
+
+	// then
+	sent := handler.MessagingService.(*services.TwilioService).Client.(*fakeTwilioClient).sent
+	assert.Equal(t, []string{"Nothing to confirm."}, sent)
+}
@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"os"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fixedRateService struct {
+	rate float64
+}
+
+func (s *fixedRateService) GetRate(from string, to string) (float64, error) {
+	return s.rate, nil
+}
+
+func gymDetails(t *testing.T) string {
+	sheet, err := os.Open(sheetPath)
+	assert.Nil(t, err)
+	defer sheet.Close()
+
+	source := model.GetConfig().GetUser("Rob").SpreadsheetSource
+	value, err := (&services.ExcelerizeSpreadsheetService{}).ReadValueForCategory(source, sheet, nil, "Gym", true, false)
+	assert.Nil(t, err)
+	return *value
+}
+
+func Test_ConvertedAmountCommentIsInTheSheetsLocale(t *testing.T) {
+	// given
+	handler, _, _ := newHandler(t)
+	handler.RateService = &fixedRateService{rate: 0.9}
+	source := model.GetConfig().GetUser("Rob").SpreadsheetSource.(*model.FileSpreadsheetSource)
+	source.Currency = "EUR"
+	source.Locale = "de-DE"
+	t.Cleanup(func() {
+		source.Currency = ""
+		source.Locale = ""
+	})
+
+	// when
+	handler.HandleMessage(robMessage("u gym $25"))
+	added := gymDetails(t)
+	handler.HandleMessage(robMessage("remove gym"))
+	removed := gymDetails(t)
+
+	// then
+	assert.Equal(t, "109+166+22.50\n22,50 € was 25,00 $", added)
+	assert.Equal(t, "109+166", removed)
+}
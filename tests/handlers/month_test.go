@@ -0,0 +1,39 @@
+package tests
+
+import (
+	"io"
+	"os"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MonthRepliesUseTheSheetNameFormat(t *testing.T) {
+	// given
+	handler, _, _ := newHandler(t)
+	source := model.GetConfig().GetUser("Rob").SpreadsheetSource.(*model.FileSpreadsheetSource)
+	source.SheetNameFormat = "2006-01"
+	t.Cleanup(func() {
+		source.SheetNameFormat = ""
+	})
+
+	command, err := model.CommandFromMessage("r gym march", 1, 2, 3, "")
+	assert.Nil(t, err)
+	sheet, err := os.Open(sheetPath)
+	assert.Nil(t, err)
+	created, _, err := (&services.ExcelerizeSpreadsheetService{}).CreateMonthSheet(source, sheet, *command.Month)
+	sheet.Close()
+	assert.Nil(t, err)
+	data, err := io.ReadAll(created)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(sheetPath, data, 0644))
+
+	// when
+	handler.HandleMessage(robMessage("r gym march"))
+
+	// then
+	sent := handler.MessagingService.(*services.TwilioService).Client.(*fakeTwilioClient).sent
+	assert.Contains(t, sent, "Current total for Gym in "+command.Month.Format("2006-01")+": £0.00")
+}
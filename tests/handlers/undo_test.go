@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"telegram-spreadsheet-editor/handlers"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	sheetPath string
+)
+
+// one config for the package as it can only be registered once, each test copies the example into the sheet
+func init() {
+	dir, err := os.MkdirTemp("", "handlers")
+	if err != nil {
+		panic(err)
+	}
+	sheetPath = filepath.Join(dir, "Example.xlsx")
+
+	model.RegisterConfig(&model.Config{
+		Users: []model.User{
+			{
+				Name: "Rob",
+				SpreadsheetSource: &model.FileSpreadsheetSource{
+					BaseSpreadsheetSource: model.BaseSpreadsheetSource{
+						Type:                model.SOURCE_TYPE_FILE,
+						CostNameColumn:      "D",
+						CostValueColumn:     "E",
+						EarningNameColumn:   "A",
+						EarningsValueColumn: "B",
+						StartRow:            2,
+					},
+					FilePath: sheetPath,
+				},
+			},
+		},
+	})
+}
+
+type fakeTwilioClient struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (c *fakeTwilioClient) SendMessage(from string, to string, body string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, body)
+	return nil
+}
+
+// the file data service with a hook run on each write, before the sheet is written
+type hookedDataService struct {
+	services.FileDataService
+	onWrite func()
+}
+
+func (s *hookedDataService) WriteSpreadsheet(source model.SpreadsheetSource, sheet io.Reader, version string) error {
+	if s.onWrite != nil {
+		s.onWrite()
+	}
+	return s.FileDataService.WriteSpreadsheet(source, sheet, version)
+}
+
+func newHandler(t *testing.T) (*handlers.DataHandler, *hookedDataService, *services.MemoryStorageService) {
+	example, err := os.ReadFile("../../Example.xlsx")
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(sheetPath, example, 0644))
+
+	dataService := &hookedDataService{}
+	storage := &services.MemoryStorageService{}
+	handler := &handlers.DataHandler{
+		DataService:        dataService,
+		SpreadsheetService: &services.ExcelerizeSpreadsheetService{},
+		MessagingService:   &services.TwilioService{Client: &fakeTwilioClient{}},
+		StorageService:     storage,
+		LockService:        &services.LocalLockService{},
+	}
+	// the commands call this handler's services
+	handler.RegisterCommands()
+	return handler, dataService, storage
+}
+
+func robMessage(body string) *model.Message {
+	return &model.Message{
+		UserName: "Rob",
+		TwilioMessage: &model.TwilioMessage{
+			From: "+447700900123",
+			To:   "+14155238886",
+			Body: body,
+			Id:   447700900123,
+		},
+	}
+}
+
+func gymTotal(t *testing.T) string {
+	sheet, err := os.Open(sheetPath)
+	assert.Nil(t, err)
+	defer sheet.Close()
+
+	source := model.GetConfig().GetUser("Rob").SpreadsheetSource
+	value, err := (&services.ExcelerizeSpreadsheetService{}).ReadValueForCategory(source, sheet, nil, "Gym", false, false)
+	assert.Nil(t, err)
+	return *value
+}
+
+func Test_UndoWithConcurrentAddKeepsTheAdd(t *testing.T) {
+	// given
+	handler, dataService, storage := newHandler(t)
+	key := model.GetConfig().GetUser("Rob").SpreadsheetSource.GetKey()
+	handler.HandleMessage(robMessage("u gym 10"))
+	assert.Equal(t, "£285.00", gymTotal(t))
+
+	// an add is sent while the undo is writing, it waits for the sheet and lands straight after
+	var wg sync.WaitGroup
+	var once sync.Once
+	dataService.onWrite = func() {
+		once.Do(func() {
+			wg.Go(func() {
+				handler.HandleMessage(robMessage("u gym 5"))
+			})
+			time.Sleep(50 * time.Millisecond)
+		})
+	}
+
+	// when
+	handler.HandleMessage(robMessage("undo"))
+	wg.Wait()
+	history, err := storage.GetMutations(key, 10)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "£280.00", gymTotal(t))
+	assert.Len(t, history, 1)
+	assert.Equal(t, "Added £5.00 to Gym", history[0].Description)
+
+	// and the add can still be undone
+	handler.HandleMessage(robMessage("undo"))
+	history, err = storage.GetMutations(key, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, history)
+	assert.Equal(t, "£275.00", gymTotal(t))
+}
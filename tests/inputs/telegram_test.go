@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"telegram-spreadsheet-editor/inputs"
+	"telegram-spreadsheet-editor/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	telegramUpdate string = `{"update_id": 1, "message": {"message_id": 2, "text": "u groceries 12.50", "chat": {"id": 1234}, "from": {"id": 1234}}}`
+)
+
+func postTelegram(t *testing.T, url string, body string, secret string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(inputs.TELEGRAM_SECRET_HEADER, secret)
+
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	res.Body.Close()
+	return res
+}
+
+// bots on one server by path, as in main
+func telegramWebhooks(t *testing.T) (*httptest.Server, chan *model.Message) {
+	server := inputs.NewWebhookServer(":0")
+	received := make(chan *model.Message, 2)
+	for _, input := range []*inputs.TelegramInput{
+		{Members: map[int64]model.TelegramMember{1234: {UserId: 1234, Name: "Rob"}}, UserName: "Rob", Path: "/telegram/rob_bot", SecretToken: "rob-secret"},
+		{Members: map[int64]model.TelegramMember{5678: {UserId: 5678, Name: "Alice"}}, UserName: "Alice", Path: "/telegram/alice_bot", SecretToken: "alice-secret"},
+	} {
+		err := server.Handle(input.Path, input.Webhook(func(m *model.Message) {
+			received <- m
+		}))
+		assert.Nil(t, err)
+	}
+
+	test := httptest.NewServer(server.Server.Handler)
+	t.Cleanup(test.Close)
+	return test, received
+}
+
+func Test_TelegramWebhookRoutesByPath(t *testing.T) {
+	// given
+	server, received := telegramWebhooks(t)
+
+	// when
+	res := postTelegram(t, server.URL+"/telegram/alice_bot", telegramUpdate, "alice-secret")
+
+	// then
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	select {
+	case message := <-received:
+		assert.Equal(t, "Alice", message.UserName)
+		assert.Equal(t, "Alice", message.GetAuthorName())
+		assert.Equal(t, "u groceries 12.50", message.TelegramMessage.Update.Message.Text)
+	case <-time.After(time.Second):
+		t.Fatal("Update was not handled")
+	}
+}
+
+func Test_TelegramWebhookRejectsWrongSecret(t *testing.T) {
+	// given
+	server, received := telegramWebhooks(t)
+
+	// when
+	wrong := postTelegram(t, server.URL+"/telegram/alice_bot", telegramUpdate, "rob-secret")
+	missing := postTelegram(t, server.URL+"/telegram/rob_bot", telegramUpdate, "")
+
+	// then
+	assert.Equal(t, http.StatusForbidden, wrong.StatusCode)
+	assert.Equal(t, http.StatusForbidden, missing.StatusCode)
+	assert.Empty(t, received)
+}
+
+func Test_TelegramWebhookIgnoresOtherUpdates(t *testing.T) {
+	// given
+	server, received := telegramWebhooks(t)
+
+	// when
+	res := postTelegram(t, server.URL+"/telegram/rob_bot", `{"update_id": 3, "edited_message": {"message_id": 2}}`, "rob-secret")
+
+	// then
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, received)
+}
+
+func Test_TelegramWebhookUnknownPath(t *testing.T) {
+	// given
+	server, _ := telegramWebhooks(t)
+
+	// when
+	res := postTelegram(t, server.URL+"/telegram/other_bot", telegramUpdate, "rob-secret")
+
+	// then
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func Test_WebhookServerRefusesAPathTwice(t *testing.T) {
+	// given
+	server := inputs.NewWebhookServer(":0")
+	first := &inputs.TelegramInput{UserName: "Rob", Path: "/telegram/rob_bot", SecretToken: "rob-secret"}
+	second := &inputs.TelegramInput{UserName: "Alice", Path: "/telegram/rob_bot", SecretToken: "alice-secret"}
+	handler := func(m *model.Message) {}
+
+	// when
+	firstErr := server.Handle(first.Path, first.Webhook(handler))
+	secondErr := server.Handle(second.Path, second.Webhook(handler))
+	relativeErr := server.Handle("telegram/alice_bot", second.Webhook(handler))
+
+	// then
+	assert.Nil(t, firstErr)
+	assert.NotNil(t, secondErr)
+	assert.NotNil(t, relativeErr)
+}
+
+func Test_TelegramWebhookStoppedRefusesUpdates(t *testing.T) {
+	// given
+	input := &inputs.TelegramInput{UserName: "Rob", Server: inputs.NewWebhookServer(":0"), Path: "/telegram/rob_bot", SecretToken: "rob-secret"}
+	server := httptest.NewServer(input.Webhook(func(m *model.Message) {}))
+	defer server.Close()
+
+	// when
+	input.Stop()
+	res := postTelegram(t, server.URL+"/telegram/rob_bot", telegramUpdate, "rob-secret")
+
+	// then
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+}
+
+func Test_TelegramWebhookWithoutSecretFails(t *testing.T) {
+	// given
+	t.Setenv("TEST_TELEGRAM_TOKEN", "123:token")
+	input := &model.TelegramInput{
+		TokenEnv:       "TEST_TELEGRAM_TOKEN",
+		Mode:           model.TELEGRAM_MODE_WEBHOOK,
+		PublicUrl:      "https://bot.example.com",
+		SecretTokenEnv: "TEST_MISSING_TELEGRAM_SECRET",
+	}
+
+	// when
+	telegramInput, err := inputs.NewTelegramInput(input, &model.User{Name: "Rob"}, inputs.NewWebhookServer(":0"))
+
+	// then
+	assert.Nil(t, telegramInput)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "TEST_MISSING_TELEGRAM_SECRET")
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"telegram-spreadsheet-editor/inputs"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func twilioWebhook(t *testing.T) (*httptest.Server, chan *model.Message) {
+	t.Setenv("TEST_TWILIO_TOKEN", "token")
+	input, err := inputs.NewTwilioInput(&model.TwilioConfig{AuthTokenEnv: "TEST_TWILIO_TOKEN"}, map[string]string{
+		"whatsapp:+447700900123": "Rob",
+	}, inputs.NewWebhookServer(":0"))
+	assert.Nil(t, err)
+
+	received := make(chan *model.Message, 1)
+	server := httptest.NewServer(input.Webhook(func(m *model.Message) {
+		received <- m
+	}))
+	t.Cleanup(server.Close)
+
+	return server, received
+}
+
+func postTwilio(t *testing.T, server *httptest.Server, form url.Values, signature string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/twilio", strings.NewReader(form.Encode()))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Twilio-Signature", signature)
+
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	res.Body.Close()
+	return res
+}
+
+func Test_TwilioWebhookHandlesSignedMessage(t *testing.T) {
+	// given
+	server, received := twilioWebhook(t)
+	form := url.Values{
+		"From": {"whatsapp:+447700900123"},
+		"To":   {"whatsapp:+14155238886"},
+		"Body": {"u groceries 12.50"},
+	}
+
+	// when
+	res := postTwilio(t, server, form, utils.TwilioSignature("token", server.URL+"/twilio", form))
+
+	// then
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	select {
+	case message := <-received:
+		assert.Equal(t, "Rob", message.UserName)
+		assert.Equal(t, "u groceries 12.50", message.TwilioMessage.Body)
+		assert.Equal(t, "whatsapp:+14155238886", message.TwilioMessage.To)
+		assert.Equal(t, int64(447700900123), message.TwilioMessage.Id)
+	case <-time.After(time.Second):
+		t.Fatal("Message was not handled")
+	}
+}
+
+func Test_TwilioWebhookRejectsBadSignature(t *testing.T) {
+	// given
+	server, received := twilioWebhook(t)
+	form := url.Values{
+		"From": {"whatsapp:+447700900123"},
+		"Body": {"u groceries 12.50"},
+	}
+
+	// when
+	res := postTwilio(t, server, form, utils.TwilioSignature("wrong", server.URL+"/twilio", form))
+
+	// then
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.Empty(t, received)
+}
+
+func Test_TwilioWebhookIgnoresUnknownNumber(t *testing.T) {
+	// given
+	server, received := twilioWebhook(t)
+	form := url.Values{
+		"From": {"+15005550006"},
+		"Body": {"list"},
+	}
+
+	// when
+	res := postTwilio(t, server, form, utils.TwilioSignature("token", server.URL+"/twilio", form))
+
+	// then
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, received)
+}
+
+func Test_TwilioInputWithoutTokenFails(t *testing.T) {
+	// given
+	config := &model.TwilioConfig{AuthTokenEnv: "TEST_MISSING_TWILIO_TOKEN"}
+
+	// when
+	input, err := inputs.NewTwilioInput(config, map[string]string{"+447700900123": "Rob"}, inputs.NewWebhookServer(":0"))
+
+	// then
+	assert.Nil(t, input)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "TEST_MISSING_TWILIO_TOKEN")
+}
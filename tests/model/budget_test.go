@@ -0,0 +1,23 @@
+package tests
+
+import (
+	"telegram-spreadsheet-editor/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CrossedBudgetAlert(t *testing.T) {
+	// given
+	alerts := []int{80, 100}
+
+	// then
+	assert.Equal(t, 0, model.CrossedBudgetAlert(100, 200, 300, alerts))
+	assert.Equal(t, 80, model.CrossedBudgetAlert(200, 250, 300, alerts))
+	assert.Equal(t, 100, model.CrossedBudgetAlert(200, 320, 300, alerts))
+	// already past it
+	assert.Equal(t, 0, model.CrossedBudgetAlert(250, 260, 300, alerts))
+	// refunds never warn
+	assert.Equal(t, 0, model.CrossedBudgetAlert(320, 200, 300, alerts))
+	assert.Equal(t, 0, model.CrossedBudgetAlert(0, 500, 0, alerts))
+}
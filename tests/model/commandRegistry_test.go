@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"telegram-spreadsheet-editor/handlers"
+	"telegram-spreadsheet-editor/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// commands are parsed through the registry so it needs filling before any parsing test
+func init() {
+	(&handlers.DataHandler{}).RegisterCommands()
+}
+
+func Test_HelpListsRegisteredCommands(t *testing.T) {
+	// when
+	help := model.HelpText(model.ROLE_ADMIN)
+
+	// then
+	assert.Contains(t, help, "LIST | L - Lists all cost and earnings categories and their totals.")
+	assert.Contains(t, help, "NEW MONTH - ")
+	assert.NotContains(t, help, "DORIS")
+}
+
+func Test_RegisteredCommandIsParsed(t *testing.T) {
+	// given
+	model.RegisterCommand(model.CommandDefinition{
+		Type:     255,
+		Keywords: []string{"test thing", "tt"},
+		Inline:   true,
+		Help:     "Only for tests.",
+	})
+
+	// when
+	command, err := model.CommandFromMessage("Test Thing", 1, 2, 3, "")
+	inline, inlineErr := model.CommandFromMessage("tt groceries", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, byte(255), command.Type)
+	assert.Nil(t, inlineErr)
+	assert.Equal(t, byte(255), inline.Type)
+	assert.Equal(t, "groceries", inline.CategoryQuery)
+	assert.Contains(t, model.HelpText(model.ROLE_ADMIN), "TEST THING | TT - Only for tests.")
+}
+
+func Test_UnknownCallbackFails(t *testing.T) {
+	// when
+	_, err := model.CommandFromCallback("NOPE:Groceries", 1, 2, 3)
+
+	// then
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,250 @@
+package tests
+
+import (
+	"telegram-spreadsheet-editor/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_InlineUpdateCommand(t *testing.T) {
+	// when
+	command, err := model.CommandFromMessage("u mobile contract £12.50", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, model.COMMAND_TYPE_UPDATE, command.Type)
+	assert.Equal(t, "mobile contract", command.CategoryQuery)
+	assert.Equal(t, float32(12.5), *command.UpdateData.Value)
+	assert.False(t, command.UpdateData.Earning)
+}
+
+func Test_InlineEarnWithoutCategory(t *testing.T) {
+	// when
+	command, err := model.CommandFromMessage("E 100", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, model.COMMAND_TYPE_EARN, command.Type)
+	assert.Equal(t, "", command.CategoryQuery)
+	assert.Equal(t, float32(100), *command.UpdateData.Value)
+	assert.True(t, command.UpdateData.Earning)
+}
+
+func Test_InlineReadCommand(t *testing.T) {
+	// when
+	command, err := model.CommandFromMessage("r groceries", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, model.COMMAND_TYPE_READ, command.Type)
+	assert.Equal(t, "groceries", command.CategoryQuery)
+}
+
+func Test_PlainCommandsUnchanged(t *testing.T) {
+	// when
+	update, _ := model.CommandFromMessage("update", 1, 2, 3, "")
+	newMonth, _ := model.CommandFromMessage("new month", 1, 2, 3, "")
+
+	// then
+	assert.Equal(t, model.COMMAND_TYPE_UPDATE, update.Type)
+	assert.Equal(t, "", update.CategoryQuery)
+	assert.Equal(t, model.COMMAND_TYPE_NEW_MONTH, newMonth.Type)
+}
+
+func Test_InlineUpdateWithExpression(t *testing.T) {
+	// when
+	command, err := model.CommandFromMessage("u groceries 12.50 + 3.20", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "groceries", command.CategoryQuery)
+	assert.InDelta(t, 15.7, *command.UpdateData.Value, 0.001)
+	assert.Equal(t, "12.50+3.20", command.UpdateData.Expression)
+}
+
+func Test_ExpressionAmount(t *testing.T) {
+	// when
+	command, err := model.CommandFromMessage("45/3", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, model.COMMAND_TYPE_NUMERICAL_AMOUNT, command.Type)
+	assert.Equal(t, float32(15), *command.UpdateData.Value)
+	assert.Equal(t, "45/3", command.UpdateData.Expression)
+}
+
+func Test_InlineRefundCommand(t *testing.T) {
+	// when
+	command, err := model.CommandFromMessage("refund groceries 4.99", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, model.COMMAND_TYPE_REFUND, command.Type)
+	assert.Equal(t, "groceries", command.CategoryQuery)
+	assert.Equal(t, float32(4.99), *command.UpdateData.Value)
+	assert.True(t, command.UpdateData.Refund)
+}
+
+func Test_InlineNegativeAmount(t *testing.T) {
+	// when
+	command, err := model.CommandFromMessage("u groceries -5", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "groceries", command.CategoryQuery)
+	assert.Equal(t, float32(-5), *command.UpdateData.Value)
+}
+
+func Test_RefundCallbackKeepsRefund(t *testing.T) {
+	// when
+	command, err := model.CommandFromCallback("REFUND:Groceries", 1, 2, 3)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, model.COMMAND_TYPE_UPDATE_CATEGORY_CHOSEN, command.Type)
+	assert.True(t, command.UpdateData.Refund)
+}
+
+func Test_InlineUpdateWithCurrency(t *testing.T) {
+	// when
+	dollars, err := model.CommandFromMessage("u groceries $25", 1, 2, 3, "")
+	assert.Nil(t, err)
+	euros, err := model.CommandFromMessage("u groceries 25 EUR", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "groceries", dollars.CategoryQuery)
+	assert.Equal(t, "USD", dollars.UpdateData.Currency)
+	assert.Equal(t, float32(25), *dollars.UpdateData.Value)
+	assert.Equal(t, "groceries", euros.CategoryQuery)
+	assert.Equal(t, "EUR", euros.UpdateData.Currency)
+}
+
+func Test_CommaLocaleAmount(t *testing.T) {
+	// when
+	amount, err := model.CommandFromMessage("12,50", 1, 2, 3, "de-DE")
+	assert.Nil(t, err)
+	inline, err := model.CommandFromMessage("u lebensmittel 3,20 €", 1, 2, 3, "de-DE")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, float32(12.5), *amount.UpdateData.Value)
+	assert.Equal(t, "lebensmittel", inline.CategoryQuery)
+	assert.Equal(t, float32(3.2), *inline.UpdateData.Value)
+	assert.Equal(t, "EUR", inline.UpdateData.Currency)
+}
+
+func Test_AmountWithNote(t *testing.T) {
+	// when
+	command, err := model.CommandFromMessage("12.50 + 3.20 lunch with Sam", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, model.COMMAND_TYPE_NUMERICAL_AMOUNT, command.Type)
+	assert.Equal(t, float32(15.7), *command.UpdateData.Value)
+	assert.Equal(t, "12.50+3.20", command.UpdateData.Expression)
+	assert.Equal(t, "lunch with Sam", command.UpdateData.Note)
+}
+
+func Test_InlineUpdateWithNote(t *testing.T) {
+	// when
+	command, err := model.CommandFromMessage("u eating out $25 lunch with Sam", 1, 2, 3, "")
+	assert.Nil(t, err)
+	trailing, err := model.CommandFromMessage("u eating out 25", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "eating out", command.CategoryQuery)
+	assert.Equal(t, float32(25), *command.UpdateData.Value)
+	assert.Equal(t, "USD", command.UpdateData.Currency)
+	assert.Equal(t, "lunch with Sam", command.UpdateData.Note)
+	assert.Equal(t, "", trailing.UpdateData.Note)
+}
+
+func Test_WordsAloneAreNotAnAmount(t *testing.T) {
+	// when
+	_, err := model.CommandFromMessage("lunch with Sam", 1, 2, 3, "")
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func Test_InlineKeywordOfMoreThanOneWord(t *testing.T) {
+	// when
+	add, err := model.CommandFromMessage("add category Pet Food", 1, 2, 3, "")
+	assert.Nil(t, err)
+	earning, err := model.CommandFromMessage("add earning category Bonus", 1, 2, 3, "")
+	assert.Nil(t, err)
+	earn, err := model.CommandFromMessage("add earning 100", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, model.COMMAND_TYPE_ADD_CATEGORY, add.Type)
+	assert.Equal(t, "Pet Food", add.CategoryQuery)
+	assert.Equal(t, model.COMMAND_TYPE_ADD_EARNING_CATEGORY, earning.Type)
+	assert.Equal(t, "Bonus", earning.CategoryQuery)
+	assert.Equal(t, model.COMMAND_TYPE_EARN, earn.Type)
+	assert.Equal(t, float32(100), *earn.UpdateData.Value)
+}
+
+func Test_InlineRenameCategory(t *testing.T) {
+	// when
+	command, err := model.CommandFromMessage("rename category eating out TO Restaurants", 1, 2, 3, "")
+	assert.Nil(t, err)
+	missing, err := model.CommandFromMessage("rename category eating out", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, model.COMMAND_TYPE_RENAME_CATEGORY, command.Type)
+	assert.Equal(t, "eating out", command.CategoryQuery)
+	assert.Equal(t, "Restaurants", command.CategoryData.NewName)
+	assert.Equal(t, "", missing.CategoryData.NewName)
+}
+
+func Test_DeleteCategoryCallback(t *testing.T) {
+	// when
+	command, err := model.CommandFromCallback("DELETE:E:Bonus", 1, 2, 3)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, model.COMMAND_TYPE_DELETE_CATEGORY_CHOSEN, command.Type)
+	assert.Equal(t, "Bonus", command.CategoryData.Category)
+	assert.True(t, command.CategoryData.Earning)
+}
+
+func Test_InlineMonth(t *testing.T) {
+	// when
+	read, err := model.CommandFromMessage("r eating out march 2025", 1, 2, 3, "")
+	assert.Nil(t, err)
+	update, err := model.CommandFromMessage("u groceries march 2025 12.50 lunch", 1, 2, 3, "")
+	assert.Nil(t, err)
+	list, err := model.CommandFromMessage("list march 2025", 1, 2, 3, "")
+	assert.Nil(t, err)
+	current, err := model.CommandFromMessage("r groceries", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	march := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "eating out", read.CategoryQuery)
+	assert.Equal(t, march, *read.Month)
+	assert.Equal(t, "groceries", update.CategoryQuery)
+	assert.Equal(t, float32(12.5), *update.UpdateData.Value)
+	assert.Equal(t, "lunch", update.UpdateData.Note)
+	assert.Equal(t, march, *update.Month)
+	assert.Equal(t, model.COMMAND_TYPE_LIST, list.Type)
+	assert.Equal(t, march, *list.Month)
+	assert.Nil(t, current.Month)
+}
+
+func Test_YearOfMonthIsNotAnAmount(t *testing.T) {
+	// when
+	command, err := model.CommandFromMessage("u groceries march 2025", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Nil(t, command.UpdateData.Value)
+	assert.Equal(t, "groceries", command.CategoryQuery)
+	assert.Equal(t, time.March, command.Month.Month())
+}
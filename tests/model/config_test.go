@@ -1,8 +1,11 @@
 package tests
 
 import (
+	"os"
+	"path/filepath"
 	"telegram-spreadsheet-editor/model"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -42,4 +45,165 @@ func Test_InitBasicConfig(t *testing.T) {
 	assert.Equal(t, nextcloudSource.EarningNameColumn, "A")
 	assert.Equal(t, nextcloudSource.EarningsValueColumn, "B")
 	assert.Equal(t, nextcloudSource.StartRow, 2)
+	assert.Equal(t, nextcloudSource.EndRow, 20)
+	assert.Equal(t, nextcloudSource.TotalRow, 21)
+	assert.Equal(t, nextcloudSource.EarningsTotalRow, 9)
+	assert.Equal(t, model.SHEET_SELECTION_PATTERN, nextcloudSource.GetSheetSelection())
+	assert.Equal(t, nextcloudSource.JournalSheet, "Journal")
+	assert.Equal(t, float32(300), nextcloudSource.Budgets["Groceries"])
+	assert.Equal(t, []int{80, 100}, nextcloudSource.GetBudgetAlerts())
+
+	assert.Equal(t, model.TELEGRAM_MODE_POLLING, telegramInput.GetMode())
+	assert.Equal(t, model.ROLE_ADMIN, config.Users[0].GetRole())
+	assert.Equal(t, map[int64]model.TelegramMember{
+		1234: {UserId: 1234, Name: "Rob", Role: model.ROLE_ADMIN},
+		5678: {UserId: 5678, Name: "Sam", Role: model.ROLE_READ_ONLY},
+	}, telegramInput.GetMembers(&config.Users[0]))
+
+	assert.Len(t, config.Users[1].Inputs, 2)
+	webhookInput, ok := config.Users[1].Inputs[0].(*model.TelegramInput)
+	assert.True(t, ok)
+	assert.Equal(t, model.TELEGRAM_MODE_WEBHOOK, webhookInput.GetMode())
+	assert.Equal(t, ":8080", webhookInput.GetListenAddress())
+	assert.Equal(t, "https://bot.example.com", webhookInput.PublicUrl)
+	assert.Equal(t, "ALICE_TELEGRAM_SECRET", webhookInput.SecretTokenEnv)
+	twilioInput, ok := config.Users[1].Inputs[1].(*model.TwilioInput)
+	assert.True(t, ok)
+	assert.Equal(t, "whatsapp:+447700900123", twilioInput.Number)
+	assert.Equal(t, "TWILIO_AUTH_TOKEN", config.Twilio.AuthTokenEnv)
+	assert.Equal(t, "https://bot.example.com", config.Twilio.PublicUrl)
+	assert.Equal(t, ":8080", config.Twilio.GetListenAddress())
+	assert.Equal(t, "/twilio", config.Twilio.GetPath())
+
+	assert.Equal(t, 10*time.Minute, config.GetDialogTimeout())
+}
+
+func Test_InitFileSourceConfig(t *testing.T) {
+	// given
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(configPath, []byte(`users:
+  - name: Rob
+    inputs:
+      - type: telegram
+        userId: 1234
+        tokenEnv: ROB_TELEGRAM_TOKEN
+    spreadsheetSource:
+      type: file
+      filePath: /data/Budget.xlsx
+      costNameColumn: D
+      costValueColumn: E
+`), 0644)
+
+	// when
+	config, err := model.NewConfigFromFile(configPath)
+
+	// then
+	assert.Nil(t, err)
+
+	fileSource, ok := config.Users[0].SpreadsheetSource.(*model.FileSpreadsheetSource)
+	assert.True(t, ok)
+	assert.Equal(t, fileSource.FilePath, "/data/Budget.xlsx")
+	assert.Equal(t, fileSource.CostNameColumn, "D")
+}
+
+func Test_UnknownRoleFails(t *testing.T) {
+	// given
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(configPath, []byte(`users:
+  - name: Rob
+    inputs:
+      - type: telegram
+        userId: 1234
+        tokenEnv: ROB_TELEGRAM_TOKEN
+        members:
+          - userId: 5678
+            role: owner
+    spreadsheetSource:
+      type: file
+      filePath: /data/Budget.xlsx
+`), 0644)
+
+	// when
+	_, err := model.NewConfigFromFile(configPath)
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func Test_DuplicateWebhookPathFails(t *testing.T) {
+	// given
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(configPath, []byte(`users:
+  - name: Rob
+    inputs:
+      - type: telegram
+        userId: 1234
+        tokenEnv: ROB_TELEGRAM_TOKEN
+        mode: webhook
+        path: /twilio
+        publicUrl: https://bot.example.com
+      - type: twilio
+        number: "+447700900123"
+    spreadsheetSource:
+      type: file
+      filePath: /data/Budget.xlsx
+`), 0644)
+
+	// when
+	_, err := model.NewConfigFromFile(configPath)
+
+	// then
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "/twilio")
+}
+
+func Test_WebhookPathsOnDifferentAddressesLoad(t *testing.T) {
+	// given
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(configPath, []byte(`users:
+  - name: Rob
+    inputs:
+      - type: telegram
+        userId: 1234
+        tokenEnv: ROB_TELEGRAM_TOKEN
+        mode: webhook
+        listenAddress: ":8081"
+        path: /twilio
+        publicUrl: https://bot.example.com
+      - type: twilio
+        number: "+447700900123"
+    spreadsheetSource:
+      type: file
+      filePath: /data/Budget.xlsx
+`), 0644)
+
+	// when
+	_, err := model.NewConfigFromFile(configPath)
+
+	// then
+	assert.Nil(t, err)
+}
+
+func Test_BoltStorageWithoutFilePathFails(t *testing.T) {
+	// given
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(configPath, []byte(`storage:
+  type: bolt
+users:
+  - name: Rob
+    inputs:
+      - type: telegram
+        userId: 1234
+        tokenEnv: ROB_TELEGRAM_TOKEN
+    spreadsheetSource:
+      type: file
+      filePath: /data/Budget.xlsx
+`), 0644)
+
+	// when
+	_, err := model.NewConfigFromFile(configPath)
+
+	// then
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "filePath")
 }
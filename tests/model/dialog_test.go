@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"telegram-spreadsheet-editor/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DialogExpires(t *testing.T) {
+	// given
+	now := time.Now()
+	dialog := model.Dialog{
+		ExpiresAt: now.Add(time.Minute),
+	}
+
+	// then
+	assert.False(t, dialog.Expired(now))
+	assert.True(t, dialog.Expired(now.Add(time.Minute)))
+}
+
+func Test_AwaitingAmountContinuesWithAmountOnly(t *testing.T) {
+	// given
+	dialog := model.Dialog{
+		Step:     model.DIALOG_STEP_AWAITING_AMOUNT,
+		Name:     "UPDATE",
+		Category: "Groceries",
+	}
+
+	// then
+	assert.True(t, dialog.Continues(model.COMMAND_TYPE_NUMERICAL_AMOUNT))
+	assert.False(t, dialog.Continues(model.COMMAND_TYPE_LIST))
+	assert.False(t, dialog.Continues(model.COMMAND_TYPE_UPDATE_CATEGORY_CHOSEN))
+	assert.Equal(t, "UPDATE for Groceries", dialog.Describe())
+}
+
+func Test_ChoosingCategoryContinuesWithCallback(t *testing.T) {
+	// given
+	dialog := model.Dialog{
+		Step: model.DIALOG_STEP_CHOOSING_CATEGORY,
+		Name: "REMOVE",
+	}
+
+	// then
+	assert.True(t, dialog.Continues(model.COMMAND_TYPE_REMOVE_CATEGORY_CHOSEN))
+	assert.False(t, dialog.Continues(model.COMMAND_TYPE_NUMERICAL_AMOUNT))
+	assert.Equal(t, "REMOVE", dialog.Describe())
+}
+
+func Test_CancelCommand(t *testing.T) {
+	// when
+	command, err := model.CommandFromMessage("Cancel", 1, 2, 3, "")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, model.COMMAND_TYPE_CANCEL, command.Type)
+}
+
+func Test_AwaitingConfirmationContinuesWithYes(t *testing.T) {
+	// given
+	dialog := model.Dialog{
+		Step:     model.DIALOG_STEP_AWAITING_CONFIRMATION,
+		Name:     "DELETE CATEGORY",
+		Category: "Groceries",
+	}
+
+	// then
+	assert.True(t, dialog.Continues(model.COMMAND_TYPE_CONFIRM))
+	assert.False(t, dialog.Continues(model.COMMAND_TYPE_DELETE_CATEGORY_CHOSEN))
+	assert.Equal(t, "DELETE CATEGORY for Groceries", dialog.Describe())
+}
@@ -0,0 +1,42 @@
+package tests
+
+import (
+	"telegram-spreadsheet-editor/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RoleAllows(t *testing.T) {
+	// then
+	assert.True(t, model.RoleAllows(model.ROLE_READ_ONLY, ""))
+	assert.False(t, model.RoleAllows(model.ROLE_READ_ONLY, model.ROLE_EDITOR))
+	assert.True(t, model.RoleAllows(model.ROLE_EDITOR, model.ROLE_EDITOR))
+	assert.False(t, model.RoleAllows(model.ROLE_EDITOR, model.ROLE_ADMIN))
+	assert.True(t, model.RoleAllows(model.ROLE_ADMIN, model.ROLE_EDITOR))
+	assert.False(t, model.RoleAllows("owner", model.ROLE_EDITOR))
+}
+
+func Test_CommandsNeedRoles(t *testing.T) {
+	// then
+	assert.Empty(t, model.GetCommandDefinition(model.COMMAND_TYPE_LIST).Role)
+	assert.Empty(t, model.GetCommandDefinition(model.COMMAND_TYPE_READ_CATEGORY_CHOSEN).Role)
+	assert.Equal(t, model.ROLE_EDITOR, model.GetCommandDefinition(model.COMMAND_TYPE_UPDATE).Role)
+	assert.Equal(t, model.ROLE_EDITOR, model.GetCommandDefinition(model.COMMAND_TYPE_NUMERICAL_AMOUNT).Role)
+	assert.Equal(t, model.ROLE_EDITOR, model.GetCommandDefinition(model.COMMAND_TYPE_REMOVE_CATEGORY_CHOSEN).Role)
+	assert.Equal(t, model.ROLE_ADMIN, model.GetCommandDefinition(model.COMMAND_TYPE_NEW_MONTH).Role)
+	assert.Equal(t, model.ROLE_ADMIN, model.GetCommandDefinition(model.COMMAND_TYPE_DELETE_CATEGORY).Role)
+	assert.Empty(t, model.GetCommandDefinition(model.COMMAND_TYPE_CONFIRM).Role)
+}
+
+func Test_HelpOnlyListsAllowedCommands(t *testing.T) {
+	// when
+	readOnly := model.HelpText(model.ROLE_READ_ONLY)
+	editor := model.HelpText(model.ROLE_EDITOR)
+
+	// then
+	assert.Contains(t, readOnly, "LIST | L - ")
+	assert.NotContains(t, readOnly, "UPDATE | U - ")
+	assert.Contains(t, editor, "UPDATE | U - ")
+	assert.NotContains(t, editor, "NEW MONTH - ")
+}
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	e "telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"telegram-spreadsheet-editor/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Behaves like nextcloud's webdav for a single file, the etag changes on every write
+func fakeNextcloud(t *testing.T, file *[]byte, etag *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+			w.Header().Set("ETag", *etag)
+			w.Write(*file)
+		case "PUT":
+			if match := r.Header.Get("If-Match"); len(match) > 0 && match != *etag {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			b, err := io.ReadAll(r.Body)
+			assert.Nil(t, err)
+			*file = b
+			*etag = *etag + "1"
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+func Test_NextcloudSendsIfMatch(t *testing.T) {
+	// given
+	file := []byte("v1")
+	etag := `"abc"`
+	server := fakeNextcloud(t, &file, &etag)
+	defer server.Close()
+
+	t.Setenv("NC_PASSWORD", "")
+	source := &model.NextcloudSpreadsheetSource{
+		PasswordEnv: "NC_PASSWORD",
+		BaseUrl:     server.URL,
+		FilePath:    "Budget.xlsx",
+	}
+	dataService := services.NCDataService{Http: &utils.HttpClient{}}
+
+	// when
+	_, version, err := dataService.GetSpreadsheet(source)
+	assert.Nil(t, err)
+	err = dataService.WriteSpreadsheet(source, bytes.NewReader([]byte("v2")), version)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, `"abc"`, version)
+	assert.Equal(t, []byte("v2"), file)
+
+	// writing the stale version again conflicts
+	err = dataService.WriteSpreadsheet(source, bytes.NewReader([]byte("v3")), version)
+	dataErr, ok := err.(*e.DataError)
+	assert.True(t, ok)
+	assert.Equal(t, e.DATA_ERROR_TYPE_CONFLICT, dataErr.Type)
+	assert.Equal(t, []byte("v2"), file)
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	e "telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FileRoundTrip(t *testing.T) {
+	// given
+	example, err := os.ReadFile("../../Example.xlsx")
+	assert.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "Budget.xlsx")
+	assert.Nil(t, os.WriteFile(path, example, 0640))
+
+	source := &model.FileSpreadsheetSource{
+		BaseSpreadsheetSource: *exampleSource(),
+		FilePath:              path,
+	}
+	dataService := services.FileDataService{}
+	spreadsheetService := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	sheet, version, err := dataService.GetSpreadsheet(source)
+	assert.Nil(t, err)
+	added, err := spreadsheetService.AddValueForCategory(source, sheet, nil, "Gym", services.AddValue{Value: 10, User: "Rob"})
+	assert.Nil(t, err)
+	err = dataService.WriteSpreadsheet(source, added.ModifiedSheet, version)
+
+	// then
+	assert.Nil(t, err)
+
+	reread, _, err := dataService.GetSpreadsheet(source)
+	assert.Nil(t, err)
+	details, err := spreadsheetService.ReadValueForCategory(source, reread, nil, "Gym", true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "109+166+10.00", *details)
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	// only the file and its lock are left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func Test_FileWriteConflict(t *testing.T) {
+	// given
+	example, err := os.ReadFile("../../Example.xlsx")
+	assert.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "Budget.xlsx")
+	assert.Nil(t, os.WriteFile(path, example, 0640))
+
+	source := &model.FileSpreadsheetSource{
+		BaseSpreadsheetSource: *exampleSource(),
+		FilePath:              path,
+	}
+	dataService := services.FileDataService{}
+	sheet, version, err := dataService.GetSpreadsheet(source)
+	assert.Nil(t, err)
+
+	// someone else writes in the meantime
+	assert.Nil(t, os.WriteFile(path, append(example, 0), 0640))
+
+	// when
+	err = dataService.WriteSpreadsheet(source, sheet, version)
+
+	// then
+	dataErr, ok := err.(*e.DataError)
+	assert.True(t, ok)
+	assert.Equal(t, e.DATA_ERROR_TYPE_CONFLICT, dataErr.Type)
+}
@@ -0,0 +1,379 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"telegram-spreadsheet-editor/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+// A very small stand in for the google token and sheets endpoints the data service uses
+type fakeGoogleSheets struct {
+	mu     sync.Mutex
+	titles []string
+	rows   map[string][][]any
+	// notes by sheet then cell
+	notes   map[string]map[string]string
+	updates []map[string]any
+	// requests to add sheets
+	sheetRequests []map[string]any
+}
+
+func (g *fakeGoogleSheets) handler(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, r.ParseForm())
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.Form.Get("grant_type"))
+		assert.Len(t, strings.Split(r.Form.Get("assertion"), "."), 3)
+		writeJson(w, map[string]any{"access_token": "token", "expires_in": 3600})
+	})
+
+	mux.HandleFunc("/v4/spreadsheets/", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		switch {
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/values:batchGet"):
+			ranges := []map[string]any{}
+			for _, rng := range r.URL.Query()["ranges"] {
+				ranges = append(ranges, map[string]any{"range": rng, "values": g.valuesFor(rng, r.URL.Query().Get("majorDimension"))})
+			}
+			writeJson(w, map[string]any{"valueRanges": ranges})
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/values:batchUpdate"):
+			var body map[string]any
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+			g.updates = append(g.updates, body)
+			writeJson(w, map[string]any{})
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, ":batchUpdate"):
+			var body map[string]any
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+			g.sheetRequests = append(g.sheetRequests, body)
+			writeJson(w, map[string]any{})
+		case r.Method == "GET" && r.URL.Query().Has("ranges"):
+			writeJson(w, map[string]any{"sheets": g.notesFor(r.URL.Query()["ranges"])})
+		case r.Method == "GET":
+			sheets := []map[string]any{}
+			for i, title := range g.titles {
+				sheets = append(sheets, map[string]any{"properties": map[string]any{"sheetId": i, "title": title, "index": i}})
+			}
+			writeJson(w, map[string]any{"sheets": sheets})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	return mux
+}
+
+func (g *fakeGoogleSheets) valuesFor(rng string, dimension string) [][]any {
+	title, column, _ := strings.Cut(rng, "!")
+	rows := g.rows[strings.Trim(title, "'")]
+	if len(column) == 0 {
+		return rows
+	}
+
+	// only single column ranges e.g. E:E are used
+	index := int(column[0] - 'A')
+	values := []any{}
+	for _, row := range rows {
+		if index < len(row) {
+			values = append(values, row[index])
+		} else {
+			values = append(values, "")
+		}
+	}
+
+	return [][]any{values}
+}
+
+// The notes in single column ranges as google returns them, one grid per range starting at the top of the column
+func (g *fakeGoogleSheets) notesFor(ranges []string) []map[string]any {
+	sheets := []map[string]any{}
+	for _, rng := range ranges {
+		title, column, _ := strings.Cut(rng, "!")
+		title = strings.Trim(title, "'")
+		index := int(column[0] - 'A')
+
+		rows := []map[string]any{}
+		for cell, note := range g.notes[title] {
+			col, row, _ := excelize.SplitCellName(cell)
+			if col != column[:1] {
+				continue
+			}
+			for len(rows) < row {
+				rows = append(rows, map[string]any{"values": []any{map[string]any{}}})
+			}
+			rows[row-1] = map[string]any{"values": []any{map[string]any{"note": note}}}
+		}
+
+		data := map[string]any{"startColumn": index, "rowData": rows}
+		sheets = append(sheets, map[string]any{"properties": map[string]any{"title": title}, "data": []any{data}})
+	}
+
+	return sheets
+}
+
+func writeJson(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeGoogleCredentials(t *testing.T, tokenUri string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+
+	creds, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "bot@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    tokenUri,
+	})
+
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	assert.Nil(t, os.WriteFile(path, creds, 0600))
+
+	return path
+}
+
+func Test_GoogleSheetsRoundTrip(t *testing.T) {
+	// given
+	fake := &fakeGoogleSheets{
+		titles: []string{"Jan 2026"},
+		rows: map[string][][]any{
+			"Jan 2026": {
+				{"", "", "", "Expenses", ""},
+				{"", "", "", "Rent", float64(425)},
+				{"", "", "", "Groceries", "=10+20"},
+				{"", "", "", "Total", "=SUM(E2:E3)"},
+			},
+		},
+	}
+	server := httptest.NewServer(fake.handler(t))
+	defer server.Close()
+
+	source := &model.GoogleSheetsSpreadsheetSource{
+		BaseSpreadsheetSource: model.BaseSpreadsheetSource{
+			Type:            model.SOURCE_TYPE_GOOGLE_SHEETS,
+			CostNameColumn:  "D",
+			CostValueColumn: "E",
+		},
+		SpreadsheetId:   "sheet-id",
+		CredentialsFile: writeGoogleCredentials(t, server.URL+"/token"),
+		BaseUrl:         server.URL,
+	}
+	dataService := services.GoogleSheetsDataService{Http: &utils.HttpClient{}}
+	spreadsheetService := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	sheet, version, err := dataService.GetSpreadsheet(source)
+	assert.Nil(t, err)
+	added, err := spreadsheetService.AddValueForCategory(source, sheet, nil, "Groceries", services.AddValue{Value: 5, User: "Rob"})
+	assert.Nil(t, err)
+	err = dataService.WriteSpreadsheet(source, added.ModifiedSheet, version)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "£35.00", added.NewValue)
+	assert.Len(t, fake.updates, 1)
+
+	data := fake.updates[0]["data"].([]any)
+	assert.Len(t, data, 1)
+	update := data[0].(map[string]any)
+	assert.Equal(t, "'Jan 2026'!E:E", update["range"])
+	assert.Equal(t, []any{"", "425", "=10+20+5.00", "=SUM(E2:E3)"}, update["values"].([]any)[0])
+}
+
+func Test_GoogleSheetsAddsJournalAsBlankSheet(t *testing.T) {
+	// given
+	fake := &fakeGoogleSheets{
+		titles: []string{"Jan 2026"},
+		rows: map[string][][]any{
+			"Jan 2026": {
+				{"", "", "", "Expenses", ""},
+				{"", "", "", "Groceries", "=10+20"},
+			},
+		},
+	}
+	server := httptest.NewServer(fake.handler(t))
+	defer server.Close()
+
+	source := &model.GoogleSheetsSpreadsheetSource{
+		BaseSpreadsheetSource: model.BaseSpreadsheetSource{
+			Type:            model.SOURCE_TYPE_GOOGLE_SHEETS,
+			CostNameColumn:  "D",
+			CostValueColumn: "E",
+			JournalSheet:    "Journal",
+		},
+		SpreadsheetId:   "sheet-id",
+		CredentialsFile: writeGoogleCredentials(t, server.URL+"/token"),
+		BaseUrl:         server.URL,
+	}
+	dataService := services.GoogleSheetsDataService{Http: &utils.HttpClient{}}
+	spreadsheetService := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	sheet, version, err := dataService.GetSpreadsheet(source)
+	assert.Nil(t, err)
+	added, err := spreadsheetService.AddValueForCategory(source, sheet, nil, "Groceries", services.AddValue{Value: 5, User: "Rob"})
+	assert.Nil(t, err)
+	err = dataService.WriteSpreadsheet(source, added.ModifiedSheet, version)
+
+	// then
+	assert.Nil(t, err)
+	assert.Len(t, fake.sheetRequests, 1)
+	requests := fake.sheetRequests[0]["requests"].([]any)
+	assert.Len(t, requests, 1)
+	addSheet := requests[0].(map[string]any)["addSheet"].(map[string]any)
+	assert.Equal(t, "Journal", addSheet["properties"].(map[string]any)["title"])
+
+	ranges := []string{}
+	for _, d := range fake.updates[0]["data"].([]any) {
+		ranges = append(ranges, d.(map[string]any)["range"].(string))
+	}
+	assert.Equal(t, []string{"'Jan 2026'!E:E", "'Journal'!A:A", "'Journal'!B:B", "'Journal'!C:C", "'Journal'!D:D", "'Journal'!E:E", "'Journal'!F:F"}, ranges)
+}
+
+func Test_GoogleSheetsSyncsNotes(t *testing.T) {
+	// given
+	fake := &fakeGoogleSheets{
+		titles: []string{"Jan 2026"},
+		rows: map[string][][]any{
+			"Jan 2026": {
+				{"", "", "", "Expenses", ""},
+				{"", "", "", "Rent", float64(425)},
+				{"", "", "", "Groceries", "=10+20"},
+			},
+		},
+		notes: map[string]map[string]string{
+			"Jan 2026": {"E2": "paid early"},
+		},
+	}
+	server := httptest.NewServer(fake.handler(t))
+	defer server.Close()
+
+	source := &model.GoogleSheetsSpreadsheetSource{
+		BaseSpreadsheetSource: model.BaseSpreadsheetSource{
+			Type:            model.SOURCE_TYPE_GOOGLE_SHEETS,
+			CostNameColumn:  "D",
+			CostValueColumn: "E",
+		},
+		SpreadsheetId:   "sheet-id",
+		CredentialsFile: writeGoogleCredentials(t, server.URL+"/token"),
+		BaseUrl:         server.URL,
+	}
+	dataService := services.GoogleSheetsDataService{Http: &utils.HttpClient{}}
+	spreadsheetService := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	sheet, version, err := dataService.GetSpreadsheet(source)
+	assert.Nil(t, err)
+	added, err := spreadsheetService.AddValueForCategory(source, sheet, nil, "Groceries", services.AddValue{Value: 5, Comment: "5.00 was $6.50", User: "Rob"})
+	assert.Nil(t, err)
+	err = dataService.WriteSpreadsheet(source, added.ModifiedSheet, version)
+
+	// then
+	assert.Nil(t, err)
+	assert.Len(t, fake.updates, 1)
+	assert.Len(t, fake.sheetRequests, 1)
+
+	requests := fake.sheetRequests[0]["requests"].([]any)
+	assert.Len(t, requests, 1)
+	updateCells := requests[0].(map[string]any)["updateCells"].(map[string]any)
+	assert.Equal(t, "note", updateCells["fields"])
+	assert.Equal(t, map[string]any{
+		"sheetId":          float64(0),
+		"startRowIndex":    float64(0),
+		"endRowIndex":      float64(3),
+		"startColumnIndex": float64(4),
+		"endColumnIndex":   float64(5),
+	}, updateCells["range"])
+	assert.Equal(t, []any{
+		map[string]any{"values": []any{map[string]any{}}},
+		map[string]any{"values": []any{map[string]any{"note": "paid early"}}},
+		map[string]any{"values": []any{map[string]any{"note": "5.00 was $6.50"}}},
+	}, updateCells["rows"])
+}
+
+func Test_GoogleSheetsDeleteCategoryMovesBudgetsAndNotes(t *testing.T) {
+	// given
+	fake := &fakeGoogleSheets{
+		titles: []string{"Jan 2026"},
+		rows: map[string][][]any{
+			"Jan 2026": {
+				{"", "", "", "Expenses", "", "Budget"},
+				{"", "", "", "Rent", float64(425), float64(425)},
+				{"", "", "", "Gym", float64(0), float64(30)},
+				{"", "", "", "Groceries", "=10+20", float64(300)},
+			},
+		},
+		notes: map[string]map[string]string{
+			"Jan 2026": {"E4": "20 was $25.00"},
+		},
+	}
+	server := httptest.NewServer(fake.handler(t))
+	defer server.Close()
+
+	source := &model.GoogleSheetsSpreadsheetSource{
+		BaseSpreadsheetSource: model.BaseSpreadsheetSource{
+			Type:            model.SOURCE_TYPE_GOOGLE_SHEETS,
+			CostNameColumn:  "D",
+			CostValueColumn: "E",
+			BudgetColumn:    "F",
+		},
+		SpreadsheetId:   "sheet-id",
+		CredentialsFile: writeGoogleCredentials(t, server.URL+"/token"),
+		BaseUrl:         server.URL,
+	}
+	dataService := services.GoogleSheetsDataService{Http: &utils.HttpClient{}}
+	spreadsheetService := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	sheet, version, err := dataService.GetSpreadsheet(source)
+	assert.Nil(t, err)
+	deleted, err := spreadsheetService.DeleteCategory(source, sheet, "Gym", false, false)
+	assert.Nil(t, err)
+	err = dataService.WriteSpreadsheet(source, deleted, version)
+
+	// then
+	assert.Nil(t, err)
+	assert.Len(t, fake.updates, 1)
+	columns := map[string]any{}
+	for _, d := range fake.updates[0]["data"].([]any) {
+		update := d.(map[string]any)
+		columns[update["range"].(string)] = update["values"].([]any)[0]
+	}
+	assert.Equal(t, []any{"Expenses", "Rent", "Groceries", ""}, columns["'Jan 2026'!D:D"])
+	assert.Equal(t, []any{"", "425", "=10+20", ""}, columns["'Jan 2026'!E:E"])
+	assert.Equal(t, []any{"Budget", "425", "300", ""}, columns["'Jan 2026'!F:F"])
+
+	assert.Len(t, fake.sheetRequests, 1)
+	requests := fake.sheetRequests[0]["requests"].([]any)
+	assert.Len(t, requests, 1)
+	updateCells := requests[0].(map[string]any)["updateCells"].(map[string]any)
+	assert.Equal(t, float64(4), updateCells["range"].(map[string]any)["startColumnIndex"])
+	assert.Equal(t, []any{
+		map[string]any{"values": []any{map[string]any{}}},
+		map[string]any{"values": []any{map[string]any{}}},
+		map[string]any{"values": []any{map[string]any{"note": "20 was $25.00"}}},
+		map[string]any{"values": []any{map[string]any{}}},
+	}, updateCells["rows"])
+}
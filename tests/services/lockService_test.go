@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"sync"
+	"telegram-spreadsheet-editor/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LocalLockSerialisesSameKey(t *testing.T) {
+	// given
+	lockService := services.LocalLockService{}
+	active := 0
+	maxActive := 0
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	// when
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := lockService.Lock("sheet")
+			assert.Nil(t, err)
+			defer unlock()
+
+			mu.Lock()
+			active++
+			maxActive = max(maxActive, active)
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// then
+	assert.Equal(t, 1, maxActive)
+}
+
+func Test_LocalLockDifferentKeysDontBlock(t *testing.T) {
+	// given
+	lockService := services.LocalLockService{}
+	unlockA, err := lockService.Lock("a")
+	assert.Nil(t, err)
+	defer unlockA()
+
+	// when
+	done := make(chan bool)
+	go func() {
+		unlockB, _ := lockService.Lock("b")
+		unlockB()
+		done <- true
+	}()
+
+	// then
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("lock on a different key blocked")
+	}
+}
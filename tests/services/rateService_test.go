@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"telegram-spreadsheet-editor/services"
+	"telegram-spreadsheet-editor/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StaticRates(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "rates.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("base: GBP\nrates:\n  USD: 1.25\n  EUR: 1.2\n"), 0600))
+	rateService := services.StaticRateService{FilePath: path}
+
+	// when
+	usd, err := rateService.GetRate("USD", "GBP")
+	assert.Nil(t, err)
+	eur, err := rateService.GetRate("usd", "eur")
+	assert.Nil(t, err)
+	_, err = rateService.GetRate("JPY", "GBP")
+
+	// then
+	assert.InDelta(t, 0.8, usd, 0.0001)
+	assert.InDelta(t, 0.96, eur, 0.0001)
+	assert.NotNil(t, err)
+}
+
+func Test_HttpRatesCached(t *testing.T) {
+	// given
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, "/latest", r.URL.Path)
+		assert.Equal(t, "USD", r.URL.Query().Get("from"))
+		assert.Equal(t, "GBP", r.URL.Query().Get("to"))
+		writeJson(w, map[string]any{"amount": 1, "base": "USD", "rates": map[string]float64{"GBP": 0.79}})
+	}))
+	defer server.Close()
+	rateService := services.HttpRateService{Http: &utils.HttpClient{}, BaseUrl: server.URL}
+
+	// when
+	first, err := rateService.GetRate("USD", "GBP")
+	assert.Nil(t, err)
+	second, err := rateService.GetRate("USD", "GBP")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, 0.79, first)
+	assert.Equal(t, 0.79, second)
+	assert.Equal(t, 1, calls)
+}
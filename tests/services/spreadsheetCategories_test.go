@@ -0,0 +1,323 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+func Test_AddCategoryUsesSpareRow(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	updated, err := service.AddCategory(exampleSource(), sheet, "Pets", false)
+
+	// then
+	assert.Nil(t, err)
+	f, err := excelize.OpenReader(updated)
+	assert.Nil(t, err)
+	name, _ := f.GetCellValue("Sheet1", "D20")
+	assert.Equal(t, "Pets", name)
+	value, _ := f.GetCellValue("Sheet1", "E20", excelize.Options{RawCellValue: true})
+	assert.Equal(t, "0", value)
+	total, _ := f.GetCellFormula("Sheet1", "E21")
+	assert.Equal(t, "SUM(E2:E20)", total)
+}
+
+func Test_AddCategoryInsertsRowAboveTotals(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	first, err := service.AddCategory(source, sheet, "Pets", false)
+	assert.Nil(t, err)
+
+	// when
+	second, err := service.AddCategory(source, first, "Fuel", false)
+	assert.Nil(t, err)
+	added, err := service.AddValueForCategory(source, second, nil, "Fuel", services.AddValue{Value: 40, User: "Rob"})
+
+	// then
+	assert.Nil(t, err)
+	f, err := excelize.OpenReader(added.ModifiedSheet)
+	assert.Nil(t, err)
+	name, _ := f.GetCellValue("Sheet1", "D21")
+	assert.Equal(t, "Fuel", name)
+	total, _ := f.GetCellFormula("Sheet1", "E22")
+	assert.Equal(t, "SUM(E2:E21)", total)
+	totalValue, _ := f.CalcCellValue("Sheet1", "E22", excelize.Options{RawCellValue: true})
+	assert.Equal(t, "2249.61", totalValue)
+}
+
+func Test_AddEarningCategoryStretchesTotals(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	updated, err := service.AddCategory(exampleSource(), sheet, "Bonus", true)
+
+	// then
+	assert.Nil(t, err)
+	f, err := excelize.OpenReader(updated)
+	assert.Nil(t, err)
+	name, _ := f.GetCellValue("Sheet1", "A8")
+	assert.Equal(t, "Bonus", name)
+	total, _ := f.GetCellFormula("Sheet1", "B9")
+	assert.Equal(t, "SUM(B2:B8)", total)
+}
+
+func Test_AddExistingCategoryFails(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	_, err = service.AddCategory(exampleSource(), sheet, "mobile contract", false)
+
+	// then
+	assert.EqualError(t, err, "mobile contract already exists")
+}
+
+func Test_RenameCategory(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+
+	// when
+	updated, err := service.RenameCategory(source, sheet, "gym", "Fitness", false)
+	assert.Nil(t, err)
+	_, clashErr := service.RenameCategory(source, updated, "Fitness", "Rent", false)
+
+	// then
+	assert.EqualError(t, clashErr, "Rent already exists")
+}
+
+func Test_DeleteCategoryNeedsForceWhenNotEmpty(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	_, err = service.DeleteCategory(exampleSource(), sheet, "Gym", false, false)
+
+	// then
+	sheetErr, ok := err.(*errors.SpreadsheetError)
+	assert.True(t, ok)
+	assert.Equal(t, errors.SPREADSHEET_ERROR_TYPE_NOT_EMPTY, sheetErr.Type)
+	assert.Equal(t, "Sheet1!E5", sheetErr.Cell)
+}
+
+func Test_DeleteCategoryMovesTheRestUp(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	updated, err := service.DeleteCategory(exampleSource(), sheet, "Gym", false, true)
+
+	// then
+	assert.Nil(t, err)
+	f, err := excelize.OpenReader(updated)
+	assert.Nil(t, err)
+	name, _ := f.GetCellValue("Sheet1", "D5")
+	assert.Equal(t, "Mobile Contract", name)
+	shopping, _ := f.GetCellFormula("Sheet1", "E9")
+	assert.Equal(t, "34.94+2", shopping)
+	last, _ := f.GetCellValue("Sheet1", "D19")
+	assert.Equal(t, "", last)
+	lastValue, _ := f.GetCellValue("Sheet1", "E19")
+	assert.Equal(t, "", lastValue)
+	total, _ := f.CalcCellValue("Sheet1", "E21", excelize.Options{RawCellValue: true})
+	assert.Equal(t, "1934.61", total)
+	// the earnings beside it are untouched
+	earning, _ := f.GetCellValue("Sheet1", "A5")
+	assert.Equal(t, "Gifts", earning)
+}
+
+func Test_DeleteEmptyCategory(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	_, err = service.DeleteCategory(exampleSource(), sheet, "Mobile Contract", false, false)
+
+	// then
+	assert.Nil(t, err)
+}
+
+func Test_AddCategoryWithoutSpareRowBeforeEndRowFails(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	source.EndRow = 19
+
+	// when
+	_, err = service.AddCategory(source, sheet, "Pets", false)
+
+	// then
+	assert.NotNil(t, err)
+}
+
+// bills and fun sections, each with a subtotal, and a grand total of the subtotals
+func sectionsSheet(t *testing.T) io.Reader {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	rows := [][]any{
+		{"Category", "Amount"},
+		{"Rent", 425},
+		{"Energy", 100},
+		{"Bills", "=SUM(E2:E3)"},
+		{"Eating Out", 50},
+		{"Cinema", 20},
+		{"Fun", "=SUM(E5:E6)"},
+		{"Total", "=E4+E7"},
+	}
+	for i, row := range rows {
+		assert.Nil(t, f.SetCellValue("Sheet1", fmt.Sprintf("D%d", i+1), row[0]))
+		if formula, ok := row[1].(string); ok && strings.HasPrefix(formula, "=") {
+			assert.Nil(t, f.SetCellFormula("Sheet1", fmt.Sprintf("E%d", i+1), formula[1:]))
+		} else {
+			assert.Nil(t, f.SetCellValue("Sheet1", fmt.Sprintf("E%d", i+1), row[1]))
+		}
+	}
+
+	var buffer bytes.Buffer
+	assert.Nil(t, f.Write(&buffer))
+	return bytes.NewReader(buffer.Bytes())
+}
+
+func Test_DeleteCategoryStaysInItsSection(t *testing.T) {
+	// given
+	service := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	updated, err := service.DeleteCategory(exampleSource(), sectionsSheet(t), "Rent", false, true)
+
+	// then
+	assert.Nil(t, err)
+	f, err := excelize.OpenReader(updated)
+	assert.Nil(t, err)
+	first, _ := f.GetCellValue("Sheet1", "D2")
+	assert.Equal(t, "Energy", first)
+	emptied, _ := f.GetCellValue("Sheet1", "D3")
+	assert.Equal(t, "", emptied)
+	// the next section hasn't moved up into this one
+	next, _ := f.GetCellValue("Sheet1", "D5")
+	assert.Equal(t, "Eating Out", next)
+	bills, _ := f.CalcCellValue("Sheet1", "E4", excelize.Options{RawCellValue: true})
+	assert.Equal(t, "100", bills)
+	fun, _ := f.CalcCellValue("Sheet1", "E7", excelize.Options{RawCellValue: true})
+	assert.Equal(t, "70", fun)
+}
+
+func Test_DeleteLastCategoryOfFirstSection(t *testing.T) {
+	// given
+	service := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	updated, err := service.DeleteCategory(exampleSource(), sectionsSheet(t), "Energy", false, true)
+
+	// then
+	assert.Nil(t, err)
+	f, err := excelize.OpenReader(updated)
+	assert.Nil(t, err)
+	emptied, _ := f.GetCellValue("Sheet1", "D3")
+	assert.Equal(t, "", emptied)
+	subtotal, _ := f.GetCellFormula("Sheet1", "E4")
+	assert.Equal(t, "SUM(E2:E3)", subtotal)
+	next, _ := f.GetCellValue("Sheet1", "D5")
+	assert.Equal(t, "Eating Out", next)
+}
+
+func Test_AddCategoryGoesInLastSection(t *testing.T) {
+	// given
+	service := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	updated, err := service.AddCategory(exampleSource(), sectionsSheet(t), "Pets", false)
+
+	// then
+	assert.Nil(t, err)
+	f, err := excelize.OpenReader(updated)
+	assert.Nil(t, err)
+	name, _ := f.GetCellValue("Sheet1", "D7")
+	assert.Equal(t, "Pets", name)
+	bills, _ := f.GetCellFormula("Sheet1", "E4")
+	assert.Equal(t, "SUM(E2:E3)", bills)
+	fun, _ := f.GetCellFormula("Sheet1", "E8")
+	assert.Equal(t, "SUM(E5:E7)", fun)
+	total, _ := f.CalcCellValue("Sheet1", "E9", excelize.Options{RawCellValue: true})
+	assert.Equal(t, "595", total)
+}
+
+func Test_DeleteCategoryMovesBudgetsAndNotes(t *testing.T) {
+	// given
+	f, err := excelize.OpenFile("../../Example.xlsx")
+	assert.Nil(t, err)
+	assert.Nil(t, f.SetCellValue("Sheet1", "F5", 300))
+	assert.Nil(t, f.SetCellValue("Sheet1", "F6", 40))
+	var buffer bytes.Buffer
+	assert.Nil(t, f.Write(&buffer))
+	f.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	source.BudgetColumn = "F"
+	noted, err := service.AddValueForCategory(source, bytes.NewReader(buffer.Bytes()), nil, "Mobile Contract", services.AddValue{Value: 5, Note: "roaming", User: "Rob"})
+	assert.Nil(t, err)
+
+	// when
+	updated, err := service.DeleteCategory(source, noted.ModifiedSheet, "Gym", false, true)
+	assert.Nil(t, err)
+	added, err := service.AddValueForCategory(source, updated, nil, "Mobile Contract", services.AddValue{Value: 1, User: "Rob"})
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, float64(40), *added.Budget)
+	moved, err := excelize.OpenReader(added.ModifiedSheet)
+	assert.Nil(t, err)
+	budget, _ := moved.GetCellValue("Sheet1", "F5")
+	assert.Equal(t, "40", budget)
+	last, _ := moved.GetCellValue("Sheet1", "F19")
+	assert.Equal(t, "", last)
+	comments, err := moved.GetComments("Sheet1")
+	assert.Nil(t, err)
+	cells := []string{}
+	for _, c := range comments {
+		cells = append(cells, c.Cell)
+	}
+	assert.Contains(t, cells, "E5")
+	assert.NotContains(t, cells, "E6")
+}
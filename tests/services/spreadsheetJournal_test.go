@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"io"
+	"os"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+func journalSource() *model.BaseSpreadsheetSource {
+	source := exampleSource()
+	source.JournalSheet = "Journal"
+	return source
+}
+
+// the journal rows without the header or the date
+func journalEntries(t *testing.T, sheet io.Reader) [][]string {
+	f, err := excelize.OpenReader(sheet)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	rows, err := f.GetRows("Journal", excelize.Options{RawCellValue: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Date", "User", "Sheet", "Category", "Amount", "Note"}, rows[0])
+
+	entries := [][]string{}
+	for _, row := range rows[1:] {
+		entries = append(entries, row[1:])
+	}
+	return entries
+}
+
+func Test_AddWritesJournalRow(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := journalSource()
+
+	// when
+	first, err := service.AddValueForCategory(source, sheet, nil, "gym", services.AddValue{Value: 10, User: "Rob"})
+	assert.Nil(t, err)
+	second, err := service.AddValueForCategory(source, first.ModifiedSheet, nil, "Gym", services.AddValue{Value: 5.25, Comment: "£5.25 was $6.00", User: "Alice"})
+	assert.Nil(t, err)
+
+	// then
+	assert.Equal(t, "£290.25", second.NewValue)
+	assert.Equal(t, [][]string{
+		{"Rob", "Sheet1", "Gym", "10"},
+		{"Alice", "Sheet1", "Gym", "5.25", "£5.25 was $6.00"},
+	}, journalEntries(t, second.ModifiedSheet))
+}
+
+func Test_WithoutJournalSheetNoJournalIsWritten(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	added, err := service.AddValueForCategory(exampleSource(), sheet, nil, "Gym", services.AddValue{Value: 10, User: "Rob"})
+
+	// then
+	assert.Nil(t, err)
+	assert.Nil(t, added.Change.JournalRow)
+	f, err := excelize.OpenReader(added.ModifiedSheet)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Sheet1"}, f.GetSheetList())
+}
+
+func Test_RemoveDeletesJournalRow(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := journalSource()
+	first, err := service.AddValueForCategory(source, sheet, nil, "Gym", services.AddValue{Value: 10, User: "Rob"})
+	assert.Nil(t, err)
+	second, err := service.AddValueForCategory(source, first.ModifiedSheet, nil, "Gym", services.AddValue{Value: 5, User: "Rob"})
+	assert.Nil(t, err)
+
+	// when
+	removed, err := service.RemoveLastValueForCategory(source, second.ModifiedSheet, nil, "Gym", false)
+
+	// then
+	assert.Nil(t, err)
+	assert.True(t, removed.Change.JournalRemoved)
+	assert.Equal(t, [][]string{
+		{"Rob", "Sheet1", "Gym", "10"},
+	}, journalEntries(t, removed.ModifiedSheet))
+}
+
+func Test_UndoAddDeletesJournalRow(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := journalSource()
+	added, err := service.AddValueForCategory(source, sheet, nil, "Gym", services.AddValue{Value: 10, User: "Rob"})
+	assert.Nil(t, err)
+
+	// when
+	restored, err := service.RestoreCells(source, added.ModifiedSheet, []model.CellChange{added.Change})
+
+	// then
+	assert.Nil(t, err)
+	assert.Empty(t, journalEntries(t, restored))
+}
+
+func Test_UndoRemovePutsJournalRowBack(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := journalSource()
+	added, err := service.AddValueForCategory(source, sheet, nil, "Gym", services.AddValue{Value: 10, User: "Rob"})
+	assert.Nil(t, err)
+	removed, err := service.RemoveLastValueForCategory(source, added.ModifiedSheet, nil, "Gym", false)
+	assert.Nil(t, err)
+
+	// when
+	restored, err := service.RestoreCells(source, removed.ModifiedSheet, []model.CellChange{removed.Change})
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{
+		{"Rob", "Sheet1", "Gym", "10"},
+	}, journalEntries(t, restored))
+}
+
+func Test_CreateMonthSheetSkipsJournal(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := journalSource()
+	added, err := service.AddValueForCategory(source, sheet, nil, "Gym", services.AddValue{Value: 10, User: "Rob"})
+	assert.Nil(t, err)
+
+	// when
+	updated, name, err := service.CreateMonthSheet(source, added.ModifiedSheet, time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	next, err := service.AddValueForCategory(source, updated, nil, "Gym", services.AddValue{Value: 5, User: "Rob"})
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "Feb 2026", next.Change.Sheet)
+	assert.Equal(t, [][]string{
+		{"Rob", "Sheet1", "Gym", "10"},
+		{"Rob", *name, "Gym", "5"},
+	}, journalEntries(t, next.ModifiedSheet))
+}
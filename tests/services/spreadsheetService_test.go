@@ -0,0 +1,525 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+func exampleSource() *model.BaseSpreadsheetSource {
+	return &model.BaseSpreadsheetSource{
+		CostNameColumn:      "D",
+		CostValueColumn:     "E",
+		EarningNameColumn:   "A",
+		EarningsValueColumn: "B",
+		StartRow:            2,
+	}
+}
+
+// the category names of costs or earnings, without the totals
+func entryNames(entries *[]model.Entry, earning bool) []string {
+	names := []string{}
+	for _, e := range *entries {
+		if e.Earning == earning && !e.Total {
+			names = append(names, e.Category)
+		}
+	}
+	return names
+}
+
+func Test_ListIncludesEarnings(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	entries, err := service.ListCategoriesAndValues(exampleSource(), sheet, nil)
+
+	// then
+	assert.Nil(t, err)
+
+	var salary *model.Entry
+	for _, e := range *entries {
+		if e.Category == "Freelance Work" {
+			salary = &e
+		}
+	}
+	assert.NotNil(t, salary)
+	assert.True(t, salary.Earning)
+}
+
+func Test_AddAndRemoveEarning(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+
+	// when
+	added, err := service.AddValueForCategory(source, sheet, nil, "Freelance Work", services.AddValue{Value: 120.5, Earning: true, User: "Rob"})
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "£120.50", added.NewValue)
+
+	removed, err := service.RemoveLastValueForCategory(source, added.ModifiedSheet, nil, "Freelance Work", true)
+	assert.Nil(t, err)
+	assert.Equal(t, "£120.50", removed.OldValue)
+	assert.Equal(t, "£0.00", removed.NewValue)
+}
+
+func Test_AddAndRemoveExpression(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	source.WriteExpressions = true
+
+	// when
+	added, err := service.AddValueForCategory(source, sheet, nil, "Gym", services.AddValue{Value: 15.7, Expression: "12.50+3.20", User: "Rob"})
+	assert.Nil(t, err)
+	var buffer bytes.Buffer
+	details, err := service.ReadValueForCategory(source, io.TeeReader(added.ModifiedSheet, &buffer), nil, "Gym", true, false)
+	assert.Nil(t, err)
+	removed, err := service.RemoveLastValueForCategory(source, &buffer, nil, "Gym", false)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "£290.70", added.NewValue)
+	assert.Equal(t, "109+166+(12.50+3.20)", *details)
+	assert.Equal(t, "£15.70 (12.50+3.20)", removed.RemovedValue)
+	assert.Equal(t, "£275.00", removed.NewValue)
+}
+
+func Test_AddAndRemoveExpressionInEmptyCategory(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	source.WriteExpressions = true
+
+	// when
+	added, err := service.AddValueForCategory(source, sheet, nil, "Medical", services.AddValue{Value: 15.7, Expression: "12.50+3.20", Note: "lunch", User: "Rob"})
+	assert.Nil(t, err)
+	var buffer bytes.Buffer
+	details, err := service.ReadValueForCategory(source, io.TeeReader(added.ModifiedSheet, &buffer), nil, "Medical", true, false)
+	assert.Nil(t, err)
+	removed, err := service.RemoveLastValueForCategory(source, &buffer, nil, "Medical", false)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "£15.70", added.NewValue)
+	assert.Equal(t, "(12.50+3.20)\n(12.50+3.20) lunch", *details)
+	assert.Equal(t, "£0.00", removed.NewValue)
+}
+
+func Test_AddAndRemoveRefund(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	source.WriteExpressions = true
+
+	// when
+	added, err := service.AddValueForCategory(source, sheet, nil, "Gym", services.AddValue{Value: -25, User: "Rob"})
+	assert.Nil(t, err)
+	added, err = service.AddValueForCategory(source, added.ModifiedSheet, nil, "Gym", services.AddValue{Value: -15.7, Expression: "12.50+3.20", User: "Rob"})
+	assert.Nil(t, err)
+	var buffer bytes.Buffer
+	details, err := service.ReadValueForCategory(source, io.TeeReader(added.ModifiedSheet, &buffer), nil, "Gym", true, false)
+	assert.Nil(t, err)
+	removed, err := service.RemoveLastValueForCategory(source, &buffer, nil, "Gym", false)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "£234.30", added.NewValue)
+	assert.Equal(t, "109+166-25.00-(12.50+3.20)", *details)
+	assert.Equal(t, "-£15.70 (12.50+3.20)", removed.RemovedValue)
+	assert.Equal(t, "£250.00", removed.NewValue)
+}
+
+func Test_AddAndRemoveWithComment(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+
+	// when
+	added, err := service.AddValueForCategory(source, sheet, nil, "Gym", services.AddValue{Value: 19.75, Comment: "£19.75 was $25.00", User: "Rob"})
+	assert.Nil(t, err)
+	var buffer bytes.Buffer
+	details, err := service.ReadValueForCategory(source, io.TeeReader(added.ModifiedSheet, &buffer), nil, "Gym", true, false)
+	assert.Nil(t, err)
+	removed, err := service.RemoveLastValueForCategory(source, &buffer, nil, "Gym", false)
+	assert.Nil(t, err)
+	detailsAfter, err := service.ReadValueForCategory(source, removed.ModifiedSheet, nil, "Gym", true, false)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "109+166+19.75\n£19.75 was $25.00", *details)
+	assert.Equal(t, "109+166", *detailsAfter)
+}
+
+func Test_ListFormattedInLocale(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	source.Currency = "EUR"
+	source.Locale = "de-DE"
+
+	// when
+	entries, err := service.ListCategoriesAndValues(source, sheet, nil)
+
+	// then
+	assert.Nil(t, err)
+
+	values := map[string]string{}
+	for _, e := range *entries {
+		values[e.Category] = e.Value
+	}
+	assert.Equal(t, "275,00 €", values["Gym"])
+	assert.NotContains(t, values, "Expenses")
+}
+
+func Test_ListEndsWithTotals(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	entries, err := service.ListCategoriesAndValues(exampleSource(), sheet, nil)
+
+	// then
+	assert.Nil(t, err)
+
+	totals := []model.Entry{}
+	for _, e := range *entries {
+		if e.Total {
+			totals = append(totals, e)
+		}
+	}
+	assert.Equal(t, []model.Entry{
+		{Category: "Total", Value: "£2,209.61", Amount: 2209.61, Total: true},
+		{Category: "Total", Value: "£3,000.00", Earning: true, Amount: 3000, Total: true},
+	}, totals)
+	assert.Equal(t, "Rent", (*entries)[0].Category)
+	assert.Equal(t, "Other", (*entries)[17].Category)
+}
+
+func Test_EndAndTotalRowsFromConfig(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	source.EndRow = 5
+	source.TotalRow = 21
+	source.EarningsTotalRow = 9
+
+	// when
+	entries, err := service.ListCategoriesAndValues(source, sheet, nil)
+
+	// then
+	assert.Nil(t, err)
+
+	categories := []string{}
+	for _, e := range *entries {
+		if !e.Earning {
+			categories = append(categories, e.Category)
+		}
+	}
+	assert.Equal(t, []string{"Rent", "Bills", "Housekeeping", "Gym", "Total"}, categories)
+}
+
+func Test_EndRowReachesPastGaps(t *testing.T) {
+	// given
+	f, err := excelize.OpenFile("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer f.Close()
+	// a gap longer than the usual empty rows allowed between two sections
+	assert.Nil(t, f.InsertRows("Sheet1", 10, 8))
+	var buffer bytes.Buffer
+	assert.Nil(t, f.Write(&buffer))
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+
+	// when
+	before, err := service.ListCategoriesAndValues(source, bytes.NewReader(buffer.Bytes()), nil)
+	assert.Nil(t, err)
+	source.EndRow = 27
+	after, err := service.ListCategoriesAndValues(source, bytes.NewReader(buffer.Bytes()), nil)
+	assert.Nil(t, err)
+	added, err := service.AddValueForCategory(source, bytes.NewReader(buffer.Bytes()), nil, "Other", services.AddValue{Value: 5, User: "Rob"})
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "£80.19", added.NewValue)
+	assert.NotContains(t, entryNames(before, false), "Other")
+	assert.Equal(t, 18, len(entryNames(after, false)))
+}
+
+func Test_TotalsAreNotCategories(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+
+	// when
+	_, err = service.AddValueForCategory(exampleSource(), sheet, nil, "Total", services.AddValue{Value: 10, User: "Rob"})
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func Test_EarningWithoutColumnsFails(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := &model.BaseSpreadsheetSource{
+		CostNameColumn:  "D",
+		CostValueColumn: "E",
+	}
+
+	// when
+	_, err = service.AddValueForCategory(source, sheet, nil, "Income", services.AddValue{Value: 10, Earning: true, User: "Rob"})
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func Test_CreateMonthSheet(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	source.CostDefaults = map[string]float32{
+		"Rent": 425,
+	}
+	month := time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	// when
+	updated, name, err := service.CreateMonthSheet(source, sheet, month)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "Feb 2026", *name)
+
+	f, err := excelize.OpenReader(updated)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Sheet1", "Feb 2026"}, f.GetSheetList())
+
+	rent, _ := f.GetCellValue("Feb 2026", "E2")
+	assert.Equal(t, "£425.00", rent)
+	groceries, _ := f.GetCellFormula("Feb 2026", "E5")
+	assert.Equal(t, "", groceries)
+	total, _ := f.GetCellFormula("Feb 2026", "E21")
+	assert.Equal(t, "SUM(E2:E20)", total)
+	header, _ := f.GetCellValue("Feb 2026", "E1")
+	assert.Equal(t, "Expenses", header)
+
+	// the old month is untouched
+	old, _ := f.GetCellFormula("Sheet1", "E5")
+	assert.Equal(t, "109+166", old)
+}
+
+func Test_CreateMonthSheetClearsComments(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	added, err := service.AddValueForCategory(source, sheet, nil, "Gym", services.AddValue{Value: 19.75, Comment: "£19.75 was $25.00", User: "Rob"})
+	assert.Nil(t, err)
+
+	// when
+	updated, _, err := service.CreateMonthSheet(source, added.ModifiedSheet, time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC))
+
+	// then
+	assert.Nil(t, err)
+	f, err := excelize.OpenReader(updated)
+	assert.Nil(t, err)
+	comments, err := f.GetComments("Feb 2026")
+	assert.Nil(t, err)
+	assert.Empty(t, comments)
+	old, err := f.GetComments("Sheet1")
+	assert.Nil(t, err)
+	assert.Len(t, old, 1)
+}
+
+func Test_RestoreCellsUndoesChanges(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	added, err := service.AddValueForCategory(source, sheet, nil, "Gym", services.AddValue{Value: 19.75, Comment: "£19.75 was $25.00", User: "Rob"})
+	assert.Nil(t, err)
+	earned, err := service.AddValueForCategory(source, added.ModifiedSheet, nil, "Freelance Work", services.AddValue{Value: 100, Earning: true, User: "Rob"})
+	assert.Nil(t, err)
+	removed, err := service.RemoveLastValueForCategory(source, earned.ModifiedSheet, nil, "Freelance Work", true)
+	assert.Nil(t, err)
+
+	// when
+	restored, err := service.RestoreCells(source, removed.ModifiedSheet, []model.CellChange{removed.Change, earned.Change, added.Change})
+
+	// then
+	assert.Nil(t, err)
+	f, err := excelize.OpenReader(restored)
+	assert.Nil(t, err)
+	gym, _ := f.GetCellFormula("Sheet1", "E5")
+	assert.Equal(t, "109+166", gym)
+	comments, _ := f.GetComments("Sheet1")
+	assert.Empty(t, comments)
+	assert.Equal(t, "E5", added.Change.Cell)
+	assert.Equal(t, "109+166+19.75", added.Change.After.Formula)
+}
+
+func Test_RestoreCellsPutsBackRemovedValue(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	removed, err := service.RemoveLastValueForCategory(source, sheet, nil, "Gym", false)
+	assert.Nil(t, err)
+
+	// when
+	restored, err := service.RestoreCells(source, removed.ModifiedSheet, []model.CellChange{removed.Change})
+
+	// then
+	assert.Nil(t, err)
+	value, err := service.ReadValueForCategory(source, restored, nil, "Gym", false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "£275.00", *value)
+}
+
+func Test_RestoreCellsRefusesWhenCellChangedSince(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	first, err := service.AddValueForCategory(source, sheet, nil, "Gym", services.AddValue{Value: 10, User: "Rob"})
+	assert.Nil(t, err)
+	second, err := service.AddValueForCategory(source, first.ModifiedSheet, nil, "Gym", services.AddValue{Value: 5, User: "Rob"})
+	assert.Nil(t, err)
+
+	// when
+	_, err = service.RestoreCells(source, second.ModifiedSheet, []model.CellChange{first.Change})
+
+	// then
+	sheetErr, ok := err.(*errors.SpreadsheetError)
+	assert.True(t, ok)
+	assert.Equal(t, errors.SPREADSHEET_ERROR_TYPE_CHANGED, sheetErr.Type)
+	assert.Equal(t, "Sheet1!E5", sheetErr.Cell)
+}
+
+func Test_AddWithNoteShowsInDetails(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	source.JournalSheet = "Journal"
+
+	// when
+	added, err := service.AddValueForCategory(source, sheet, nil, "Gym", services.AddValue{Value: 12.5, Note: "lunch with Sam", User: "Rob"})
+	assert.Nil(t, err)
+	var buffer bytes.Buffer
+	details, err := service.ReadValueForCategory(source, io.TeeReader(added.ModifiedSheet, &buffer), nil, "Gym", true, false)
+	assert.Nil(t, err)
+	removed, err := service.RemoveLastValueForCategory(source, &buffer, nil, "Gym", false)
+	assert.Nil(t, err)
+	detailsAfter, err := service.ReadValueForCategory(source, removed.ModifiedSheet, nil, "Gym", true, false)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "109+166+12.50\n12.50 lunch with Sam", *details)
+	assert.Equal(t, []string{"Rob", "Sheet1", "Gym", "12.5", "lunch with Sam"}, added.Change.JournalRow[1:])
+	assert.Equal(t, "109+166", *detailsAfter)
+}
+
+func Test_BudgetFromConfig(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	source.Budgets = map[string]float32{
+		"gym": 300,
+	}
+
+	// when
+	added, err := service.AddValueForCategory(source, sheet, nil, "Gym", services.AddValue{Value: 10, User: "Rob"})
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, 275.0, added.PreviousTotal)
+	assert.Equal(t, 285.0, added.Total)
+	assert.Equal(t, 300.0, *added.Budget)
+}
+
+func Test_BudgetColumnWinsOverConfig(t *testing.T) {
+	// given
+	f, err := excelize.OpenFile("../../Example.xlsx")
+	assert.Nil(t, err)
+	assert.Nil(t, f.SetCellValue("Sheet1", "F5", 250))
+	var buffer bytes.Buffer
+	assert.Nil(t, f.Write(&buffer))
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	source.BudgetColumn = "F"
+	source.Budgets = map[string]float32{
+		"Gym":  300,
+		"Rent": 425,
+	}
+
+	// when
+	entries, err := service.ListCategoriesAndValues(source, &buffer, nil)
+
+	// then
+	assert.Nil(t, err)
+	budgets := map[string]float64{}
+	for _, e := range *entries {
+		if e.Budget != nil {
+			budgets[e.Category] = *e.Budget
+		}
+	}
+	assert.Equal(t, map[string]float64{"Gym": 250, "Rent": 425}, budgets)
+}
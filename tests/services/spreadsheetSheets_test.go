@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+// the example with Feb and Mar 2026 tabs after Sheet1 and a Notes tab at the end
+func monthsSheet(t *testing.T) io.Reader {
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+
+	feb, _, err := service.CreateMonthSheet(source, sheet, time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	mar, _, err := service.CreateMonthSheet(source, feb, time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+
+	f, err := excelize.OpenReader(mar)
+	assert.Nil(t, err)
+	defer f.Close()
+	_, err = f.NewSheet("Notes")
+	assert.Nil(t, err)
+
+	var buffer bytes.Buffer
+	assert.Nil(t, f.Write(&buffer))
+	return bytes.NewReader(buffer.Bytes())
+}
+
+func Test_MonthGivenEditsItsSheet(t *testing.T) {
+	// given
+	service := services.ExcelerizeSpreadsheetService{}
+	feb := time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	// when
+	added, err := service.AddValueForCategory(exampleSource(), monthsSheet(t), &feb, "Gym", services.AddValue{Value: 10, User: "Rob"})
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "Feb 2026", added.Change.Sheet)
+	assert.Equal(t, "£10.00", added.NewValue)
+}
+
+func Test_MissingMonthIsNoSheetError(t *testing.T) {
+	// given
+	service := services.ExcelerizeSpreadsheetService{}
+	month := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// when
+	_, err := service.ReadValueForCategory(exampleSource(), monthsSheet(t), &month, "Gym", false, false)
+
+	// then
+	sheetErr, ok := err.(*errors.SpreadsheetError)
+	assert.True(t, ok)
+	assert.Equal(t, errors.SPREADSHEET_ERROR_TYPE_NO_SHEET, sheetErr.Type)
+	assert.Equal(t, "Jan 2020", sheetErr.Cell)
+}
+
+func Test_PatternSelectionPicksNewestMonth(t *testing.T) {
+	// given
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	source.SheetSelection = model.SHEET_SELECTION_PATTERN
+
+	// when
+	added, err := service.AddValueForCategory(source, monthsSheet(t), nil, "Gym", services.AddValue{Value: 10, User: "Rob"})
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "Mar 2026", added.Change.Sheet)
+}
+
+func Test_FixedSelection(t *testing.T) {
+	// given
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	source.SheetSelection = model.SHEET_SELECTION_FIXED
+	source.SheetName = "Sheet1"
+
+	// when
+	added, err := service.AddValueForCategory(source, monthsSheet(t), nil, "Gym", services.AddValue{Value: 10, User: "Rob"})
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "Sheet1", added.Change.Sheet)
+	assert.Equal(t, "£285.00", added.NewValue)
+}
+
+func Test_DateSelectionPicksCurrentMonth(t *testing.T) {
+	// given
+	sheet, err := os.Open("../../Example.xlsx")
+	assert.Nil(t, err)
+	defer sheet.Close()
+	service := services.ExcelerizeSpreadsheetService{}
+	source := exampleSource()
+	now := time.Now()
+	current, _, err := service.CreateMonthSheet(source, sheet, now)
+	assert.Nil(t, err)
+	next, _, err := service.CreateMonthSheet(source, current, now.AddDate(0, 1, 0))
+	assert.Nil(t, err)
+	source.SheetSelection = model.SHEET_SELECTION_DATE
+
+	// when
+	added, err := service.AddValueForCategory(source, next, nil, "Gym", services.AddValue{Value: 10, User: "Rob"})
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, now.Format("Jan 2006"), added.Change.Sheet)
+}
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"path/filepath"
+	"telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func exampleDialog() *model.Dialog {
+	return &model.Dialog{
+		Step:     model.DIALOG_STEP_AWAITING_AMOUNT,
+		ChatId:   1,
+		UserId:   2,
+		Name:     "UPDATE",
+		Category: "Groceries",
+		Command: &model.Command{
+			Type: model.COMMAND_TYPE_UPDATE_CATEGORY_CHOSEN,
+		},
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+}
+
+func assertStoresDialogs(t *testing.T, storage services.IStorageService) {
+	// when
+	err := storage.StoreDialog(exampleDialog())
+	assert.Nil(t, err)
+	dialog, err := storage.GetDialog(1, 2)
+	_, otherErr := storage.GetDialog(1, 3)
+	clearErr := storage.ClearDialog(1, 2)
+	_, clearedErr := storage.GetDialog(1, 2)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "Groceries", dialog.Category)
+	assert.Equal(t, model.COMMAND_TYPE_UPDATE_CATEGORY_CHOSEN, dialog.Command.Type)
+	assert.Nil(t, clearErr)
+
+	for _, err := range []error{otherErr, clearedErr} {
+		storageErr, ok := err.(*errors.StorageError)
+		assert.True(t, ok)
+		assert.Equal(t, errors.STORAGE_ERROR_TYPE_NOT_FOUND, storageErr.Type)
+	}
+}
+
+func Test_MemoryStorageStoresDialogs(t *testing.T) {
+	assertStoresDialogs(t, &services.MemoryStorageService{})
+}
+
+func Test_BoltStorageStoresDialogs(t *testing.T) {
+	// given
+	storage := services.NewBoltStorageService(filepath.Join(t.TempDir(), "editor.db"))
+	defer storage.DB.Close()
+
+	assertStoresDialogs(t, storage)
+}
+
+func Test_BoltStorageSurvivesRestart(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "editor.db")
+	storage := services.NewBoltStorageService(path)
+	storage.StoreDialog(exampleDialog())
+	storage.DB.Close()
+
+	// when
+	reopened := services.NewBoltStorageService(path)
+	defer reopened.DB.Close()
+	dialog, err := reopened.GetDialog(1, 2)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "UPDATE for Groceries", dialog.Describe())
+}
+
+func Test_ExpiredDialogIsKeptForGrace(t *testing.T) {
+	// given
+	storage := &services.MemoryStorageService{}
+	expired := exampleDialog()
+	expired.ExpiresAt = time.Now().Add(-time.Hour)
+	forgotten := exampleDialog()
+	forgotten.UserId = 3
+	forgotten.ExpiresAt = time.Now().Add(-services.DIALOG_EXPIRED_GRACE - time.Minute)
+
+	// when
+	storage.StoreDialog(expired)
+	storage.StoreDialog(forgotten)
+	dialog, err := storage.GetDialog(1, 2)
+	_, forgottenErr := storage.GetDialog(1, 3)
+
+	// then
+	assert.Nil(t, err)
+	assert.True(t, dialog.Expired(time.Now()))
+	assert.NotNil(t, forgottenErr)
+}
+
+func assertKeepsHistory(t *testing.T, storage services.IStorageService) {
+	// given
+	for _, description := range []string{"first", "second", "third"} {
+		assert.Nil(t, storage.AddMutation("sheet", &model.Mutation{Id: description, Description: description}))
+	}
+
+	// when
+	latest, err := storage.GetMutations("sheet", 2)
+	assert.Nil(t, err)
+	// one added since they were read is kept
+	assert.Nil(t, storage.AddMutation("sheet", &model.Mutation{Id: "fourth", Description: "fourth"}))
+	removeErr := storage.RemoveMutations("sheet", latest)
+	remaining, remainingErr := storage.GetMutations("sheet", 10)
+	other, otherErr := storage.GetMutations("other", 10)
+
+	// then
+	assert.Equal(t, "third", latest[0].Description)
+	assert.Equal(t, "second", latest[1].Description)
+	assert.Nil(t, removeErr)
+	assert.Nil(t, remainingErr)
+	assert.Len(t, remaining, 2)
+	assert.Equal(t, "fourth", remaining[0].Description)
+	assert.Equal(t, "first", remaining[1].Description)
+	assert.Nil(t, otherErr)
+	assert.Empty(t, other)
+}
+
+func Test_MemoryStorageKeepsHistory(t *testing.T) {
+	assertKeepsHistory(t, &services.MemoryStorageService{})
+}
+
+func Test_BoltStorageKeepsHistory(t *testing.T) {
+	// given
+	storage := services.NewBoltStorageService(filepath.Join(t.TempDir(), "editor.db"))
+	defer storage.DB.Close()
+
+	assertKeepsHistory(t, storage)
+}
+
+func Test_HistoryIsCapped(t *testing.T) {
+	// given
+	storage := &services.MemoryStorageService{}
+
+	// when
+	for range services.MAX_HISTORY + 5 {
+		storage.AddMutation("sheet", &model.Mutation{})
+	}
+	mutations, err := storage.GetMutations("sheet", services.MAX_HISTORY*2)
+
+	// then
+	assert.Nil(t, err)
+	assert.Len(t, mutations, services.MAX_HISTORY)
+}
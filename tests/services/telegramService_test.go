@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func telegramMessage(chatType string, from int64, text string) *model.Message {
+	return &model.Message{
+		UserName: "Rob",
+		TelegramMessage: &model.TelegramMessage{
+			Update: &tgbotapi.Update{
+				Message: &tgbotapi.Message{
+					MessageID: 7,
+					From:      &tgbotapi.User{ID: from},
+					Chat:      &tgbotapi.Chat{ID: -100, Type: chatType},
+					Text:      text,
+				},
+			},
+			Members: map[int64]model.TelegramMember{
+				1234: {UserId: 1234, Name: "Rob", Role: model.ROLE_ADMIN},
+				5678: {UserId: 5678, Name: "Sam", Role: model.ROLE_READ_ONLY},
+			},
+		},
+	}
+}
+
+func Test_TelegramMemberInGroupChat(t *testing.T) {
+	// given
+	service := services.TelegramService{}
+	message := telegramMessage("group", 5678, "12.50")
+
+	// when
+	command, err := service.GetCommandFromMessage(message)
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, model.COMMAND_TYPE_NUMERICAL_AMOUNT, command.Type)
+	assert.Equal(t, int64(-100), command.ChatId)
+	assert.Equal(t, int64(5678), command.UserId)
+	assert.Equal(t, "Sam", message.GetAuthorName())
+	assert.Equal(t, model.ROLE_READ_ONLY, message.GetRole())
+}
+
+func Test_TelegramStrangerInGroupChatIsIgnored(t *testing.T) {
+	// given
+	service := services.TelegramService{}
+
+	// when
+	_, err := service.GetCommandFromMessage(telegramMessage("supergroup", 9999, "list"))
+
+	// then
+	commandErr, ok := err.(*errors.CommandError)
+	assert.True(t, ok)
+	assert.True(t, commandErr.Unauthorized)
+	assert.True(t, commandErr.Silent)
+}
+
+func Test_TelegramStrangerInPrivateChatIsRefused(t *testing.T) {
+	// given
+	service := services.TelegramService{}
+
+	// when
+	_, err := service.GetCommandFromMessage(telegramMessage("private", 9999, "list"))
+
+	// then
+	commandErr, ok := err.(*errors.CommandError)
+	assert.True(t, ok)
+	assert.True(t, commandErr.Unauthorized)
+	assert.False(t, commandErr.Silent)
+	assert.Equal(t, int64(-100), commandErr.ChatId)
+}
+
+func Test_TelegramChatterInGroupChatIsIgnored(t *testing.T) {
+	// given
+	service := services.TelegramService{}
+
+	for _, text := range []string{"see you at the cinema", ""} {
+		// when
+		_, err := service.GetCommandFromMessage(telegramMessage("group", 1234, text))
+
+		// then
+		commandErr, ok := err.(*errors.CommandError)
+		assert.True(t, ok)
+		assert.False(t, commandErr.Unauthorized)
+		assert.True(t, commandErr.Silent)
+	}
+}
+
+func Test_TelegramUnrecognisedInPrivateChatIsAnswered(t *testing.T) {
+	// given
+	service := services.TelegramService{}
+
+	// when
+	_, err := service.GetCommandFromMessage(telegramMessage("private", 1234, "see you at the cinema"))
+
+	// then
+	commandErr, ok := err.(*errors.CommandError)
+	assert.True(t, ok)
+	assert.False(t, commandErr.Silent)
+	assert.Equal(t, "see you at the cinema not a recognised command", commandErr.ResponseMessage)
+}
@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"telegram-spreadsheet-editor/errors"
+	"telegram-spreadsheet-editor/model"
+	"telegram-spreadsheet-editor/services"
+	"telegram-spreadsheet-editor/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sentTwilioMessage struct {
+	From string
+	To   string
+	Body string
+}
+
+type fakeTwilioClient struct {
+	sent []sentTwilioMessage
+}
+
+func (c *fakeTwilioClient) SendMessage(from string, to string, body string) error {
+	c.sent = append(c.sent, sentTwilioMessage{From: from, To: to, Body: body})
+	return nil
+}
+
+func twilioMessage(body string) *model.Message {
+	return &model.Message{
+		UserName: "Rob",
+		TwilioMessage: &model.TwilioMessage{
+			From: "whatsapp:+447700900123",
+			To:   "whatsapp:+14155238886",
+			Body: body,
+			Id:   447700900123,
+		},
+	}
+}
+
+func Test_TwilioRestClientSendsMessage(t *testing.T) {
+	// given
+	var path, user, password, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		user, password, _ = r.BasicAuth()
+		r.ParseForm()
+		body = r.PostForm.Encode()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sid": "SM123", "status": "queued"}`))
+	}))
+	defer server.Close()
+	client := services.TwilioRestClient{
+		Http:       &utils.HttpClient{},
+		AccountSid: "AC123",
+		AuthToken:  "token",
+		BaseUrl:    server.URL,
+	}
+
+	// when
+	err := client.SendMessage("whatsapp:+14155238886", "whatsapp:+447700900123", "Pong")
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, "/2010-04-01/Accounts/AC123/Messages.json", path)
+	assert.Equal(t, "AC123", user)
+	assert.Equal(t, "token", password)
+	assert.Equal(t, "Body=Pong&From=whatsapp%3A%2B14155238886&To=whatsapp%3A%2B447700900123", body)
+}
+
+func Test_TwilioRestClientFailure(t *testing.T) {
+	// given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"code": 20003}`))
+	}))
+	defer server.Close()
+	client := services.TwilioRestClient{
+		Http:    &utils.HttpClient{},
+		BaseUrl: server.URL,
+	}
+
+	// when
+	err := client.SendMessage("+14155238886", "+447700900123", "Pong")
+
+	// then
+	assert.NotNil(t, err)
+}
+
+func Test_TwilioNumberedMenu(t *testing.T) {
+	// given
+	model.RegisterCommand(model.CommandDefinition{
+		Type:           250,
+		CallbackPrefix: "PICK",
+		FromCallback: func(command *model.Command, category string, earning bool) {
+			command.Type = 250
+			command.CategoryQuery = category
+			command.ReadData = &model.ReadData{Category: category, Earning: earning}
+		},
+	})
+	client := &fakeTwilioClient{}
+	service := services.TwilioService{Client: client}
+	entries := []model.Entry{
+		{Category: "Groceries"},
+		{Category: "Salary", Earning: true},
+	}
+
+	// when
+	err := service.SendCategorySelectionKeyboard(twilioMessage("r"), 447700900123, &entries, "PICK")
+	assert.Nil(t, err)
+	_, outOfRange := service.GetCommandFromMessage(twilioMessage("3"))
+	chosen, chosenErr := service.GetCommandFromMessage(twilioMessage("2"))
+	amount, amountErr := service.GetCommandFromMessage(twilioMessage("2"))
+
+	// then
+	assert.Equal(t, []sentTwilioMessage{
+		{From: "whatsapp:+14155238886", To: "whatsapp:+447700900123", Body: "Please choose a category by sending its number:\n1. Groceries\n2. \U0001F4B0 Salary"},
+	}, client.sent)
+	commandErr, ok := outOfRange.(*errors.CommandError)
+	assert.True(t, ok)
+	assert.Equal(t, "Please send a number from 1 to 2, or CANCEL.", commandErr.ResponseMessage)
+	assert.Nil(t, chosenErr)
+	assert.Equal(t, byte(250), chosen.Type)
+	assert.Equal(t, "Salary", chosen.ReadData.Category)
+	assert.True(t, chosen.ReadData.Earning)
+	assert.Equal(t, int64(447700900123), chosen.ChatId)
+	// the menu is used up so a number is an amount again
+	assert.Nil(t, amountErr)
+	assert.Equal(t, model.COMMAND_TYPE_NUMERICAL_AMOUNT, amount.Type)
+}
+
+func Test_TwilioOtherMessageClearsMenu(t *testing.T) {
+	// given
+	client := &fakeTwilioClient{}
+	service := services.TwilioService{Client: client}
+	entries := []model.Entry{{Category: "Groceries"}}
+	assert.Nil(t, service.SendCategorySelectionKeyboard(twilioMessage("r"), 447700900123, &entries, "PICK"))
+
+	// when
+	_, err := service.GetCommandFromMessage(twilioMessage("12.50"))
+	assert.Nil(t, err)
+	command, err := service.GetCommandFromMessage(twilioMessage("1"))
+
+	// then
+	assert.Nil(t, err)
+	assert.Equal(t, model.COMMAND_TYPE_NUMERICAL_AMOUNT, command.Type)
+}
@@ -0,0 +1,38 @@
+package tests
+
+import (
+	"telegram-spreadsheet-editor/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SplitCurrency(t *testing.T) {
+	cases := map[string][2]string{
+		"12.50":     {"12.50", ""},
+		"£12.50":    {"12.50", "GBP"},
+		"$25":       {"25", "USD"},
+		"25 EUR":    {"25", "EUR"},
+		"eur25":     {"25", "EUR"},
+		"¥3000":     {"3000", "JPY"},
+		"$10+$2.50": {"10+2.50", "USD"},
+	}
+
+	for input, expected := range cases {
+		// when
+		amount, currency := utils.SplitCurrency(input)
+
+		// then
+		assert.Equal(t, expected[0], amount, input)
+		assert.Equal(t, expected[1], currency, input)
+	}
+}
+
+func Test_SplitCurrencyMixed(t *testing.T) {
+	// when
+	_, currency := utils.SplitCurrency("$25 EUR")
+
+	// then
+	assert.Equal(t, "", currency)
+	assert.False(t, utils.IsFinancial("$25 EUR"))
+}
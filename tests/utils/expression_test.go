@@ -0,0 +1,47 @@
+package tests
+
+import (
+	"telegram-spreadsheet-editor/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EvaluateExpression(t *testing.T) {
+	cases := map[string]float64{
+		"12.50":           12.5,
+		"12.50+3.20":      15.7,
+		"45/3":            15,
+		"10-2*3":          4,
+		"(10-2)*3":        24,
+		"(45 + 12.5) / 2": 28.75,
+		"-(2+3)+10":       5,
+	}
+
+	for expression, expected := range cases {
+		// when
+		val, err := utils.EvaluateExpression(expression)
+
+		// then
+		assert.Nil(t, err, expression)
+		assert.InDelta(t, expected, val, 0.0001, expression)
+	}
+}
+
+func Test_EvaluateExpressionInvalid(t *testing.T) {
+	for _, expression := range []string{"", "12+", "(12+3", "12)", "4/0", "2^3", "1e5", "SUM(E2:E5)", "1..2"} {
+		// when
+		_, err := utils.EvaluateExpression(expression)
+
+		// then
+		assert.NotNil(t, err, expression)
+	}
+}
+
+func Test_IsFinancialExpressions(t *testing.T) {
+	assert.True(t, utils.IsFinancial("£12.50"))
+	assert.True(t, utils.IsFinancial("12.50+3.20"))
+	assert.True(t, utils.IsFinancial("(45+12)/3"))
+	assert.False(t, utils.IsFinancial("12+"))
+	assert.False(t, utils.IsFinancial("groceries"))
+}
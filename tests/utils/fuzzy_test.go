@@ -0,0 +1,30 @@
+package tests
+
+import (
+	"telegram-spreadsheet-editor/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var categories = []string{"Rent", "Bills", "Housekeeping", "Gym", "Mobile Contract", "Shopping", "Subscriptions", "Gifts"}
+
+func Test_MatchCategoriesExact(t *testing.T) {
+	assert.Equal(t, []int{4}, utils.MatchCategories("mobile contract", categories))
+}
+
+func Test_MatchCategoriesPrefix(t *testing.T) {
+	assert.Equal(t, []int{2}, utils.MatchCategories("house", categories))
+}
+
+func Test_MatchCategoriesAmbiguous(t *testing.T) {
+	assert.Equal(t, []int{5, 6}, utils.MatchCategories("s", categories))
+}
+
+func Test_MatchCategoriesTypo(t *testing.T) {
+	assert.Equal(t, []int{5}, utils.MatchCategories("shoping", categories))
+}
+
+func Test_MatchCategoriesNone(t *testing.T) {
+	assert.Empty(t, utils.MatchCategories("holidays", categories))
+}
@@ -0,0 +1,25 @@
+package tests
+
+import (
+	"telegram-spreadsheet-editor/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FormatAmount(t *testing.T) {
+	assert.Equal(t, "£1,234.50", utils.FormatAmount(1234.5, "GBP", "en-GB"))
+	assert.Equal(t, "-£5.00", utils.FormatAmount(-5, "GBP", ""))
+	assert.Equal(t, "1.234,50 €", utils.FormatAmount(1234.5, "EUR", "de-DE"))
+	assert.Equal(t, "1 234,50 €", utils.FormatAmount(1234.5, "eur", "fr_FR"))
+	assert.Equal(t, "¥3,000", utils.FormatAmount(3000, "JPY", "en-GB"))
+	assert.Equal(t, "CHF 12.50", utils.FormatAmount(12.5, "CHF", "en-GB"))
+}
+
+func Test_NormaliseAmount(t *testing.T) {
+	assert.Equal(t, "12.50", utils.NormaliseAmount("12,50", "de-DE"))
+	assert.Equal(t, "1234.50", utils.NormaliseAmount("1.234,50", "de-DE"))
+	assert.Equal(t, "12.50", utils.NormaliseAmount("12.50", "de-DE"))
+	assert.Equal(t, "1234.50", utils.NormaliseAmount("1,234.50", "en-GB"))
+	assert.Equal(t, "12,50", utils.NormaliseAmount("12,50", "en-GB"))
+}
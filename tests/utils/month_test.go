@@ -0,0 +1,44 @@
+package tests
+
+import (
+	"strings"
+	"telegram-spreadsheet-editor/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TrailingMonth(t *testing.T) {
+	// given
+	now := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	cases := map[string]struct {
+		month time.Time
+		taken int
+	}{
+		"groceries march":      {time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC), 1},
+		"groceries Sept":       {time.Date(2026, time.September, 1, 0, 0, 0, 0, time.UTC), 1},
+		"groceries december":   {time.Date(2025, time.December, 1, 0, 0, 0, 0, time.UTC), 1},
+		"groceries march 2024": {time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC), 2},
+		"october":              {time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC), 1},
+	}
+
+	for input, expected := range cases {
+		// when
+		month, taken := utils.TrailingMonth(strings.Fields(input), now)
+
+		// then
+		assert.NotNil(t, month, input)
+		assert.Equal(t, expected.month, *month, input)
+		assert.Equal(t, expected.taken, taken, input)
+	}
+}
+
+func Test_TrailingMonthNotAMonth(t *testing.T) {
+	// when
+	month, taken := utils.TrailingMonth([]string{"eating", "out"}, time.Now())
+
+	// then
+	assert.Nil(t, month)
+	assert.Equal(t, 0, taken)
+}
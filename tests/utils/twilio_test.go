@@ -0,0 +1,42 @@
+package tests
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/url"
+	"telegram-spreadsheet-editor/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TwilioSignature(t *testing.T) {
+	// given
+	params := url.Values{
+		"From": {"whatsapp:+447700900123"},
+		"Body": {"u groceries 12.50"},
+		"To":   {"whatsapp:+14155238886"},
+	}
+	mac := hmac.New(sha1.New, []byte("token"))
+	mac.Write([]byte("https://bot.example.com/twilio?a=1" + "Bodyu groceries 12.50" + "Fromwhatsapp:+447700900123" + "Towhatsapp:+14155238886"))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	// when
+	signature := utils.TwilioSignature("token", "https://bot.example.com/twilio?a=1", params)
+
+	// then
+	assert.Equal(t, expected, signature)
+	assert.True(t, utils.ValidTwilioSignature("token", "https://bot.example.com/twilio?a=1", params, signature))
+	assert.False(t, utils.ValidTwilioSignature("other", "https://bot.example.com/twilio?a=1", params, signature))
+	params.Set("Body", "u groceries 1250")
+	assert.False(t, utils.ValidTwilioSignature("token", "https://bot.example.com/twilio?a=1", params, signature))
+}
+
+func Test_PhoneNumbers(t *testing.T) {
+	// then
+	assert.Equal(t, "+447700900123", utils.NormalisePhoneNumber("whatsapp:+44 7700 900123"))
+	assert.Equal(t, "+447700900123", utils.NormalisePhoneNumber("+447700900123"))
+	assert.Equal(t, int64(447700900123), utils.PhoneNumberId("whatsapp:+447700900123"))
+	assert.Equal(t, int64(0), utils.PhoneNumberId("unknown"))
+}
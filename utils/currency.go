@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var currencySymbols = map[string]string{
+	"£": "GBP",
+	"$": "USD",
+	"€": "EUR",
+	"¥": "JPY",
+}
+
+// the currencies the ECB publishes rates for, limited so words like "box" aren't mistaken for a currency
+var currencyCodes = map[string]bool{
+	"AUD": true, "BGN": true, "BRL": true, "CAD": true, "CHF": true, "CNY": true, "CZK": true, "DKK": true,
+	"EUR": true, "GBP": true, "HKD": true, "HUF": true, "IDR": true, "ILS": true, "INR": true, "ISK": true,
+	"JPY": true, "KRW": true, "MXN": true, "MYR": true, "NOK": true, "NZD": true, "PHP": true, "PLN": true,
+	"RON": true, "SEK": true, "SGD": true, "THB": true, "TRY": true, "USD": true, "ZAR": true,
+}
+
+var (
+	currencyPrefixRegex = regexp.MustCompile(`^([A-Za-z]{3})\s*(.+)$`)
+	currencySuffixRegex = regexp.MustCompile(`^(.+?)\s*([A-Za-z]{3})$`)
+)
+
+// Splits an amount like $25, 25 EUR or ¥3000 into the amount and the currency code.
+// The code is empty when no currency is given.
+func SplitCurrency(str string) (string, string) {
+	amount := strings.TrimSpace(str)
+	currency := ""
+
+	for symbol, code := range currencySymbols {
+		if !strings.Contains(amount, symbol) {
+			continue
+		}
+		if len(currency) > 0 && currency != code {
+			// mixed currencies, leave it to fail parsing
+			return str, ""
+		}
+		currency = code
+		amount = strings.ReplaceAll(amount, symbol, "")
+	}
+
+	if m := currencyPrefixRegex.FindStringSubmatch(amount); m != nil && IsCurrencyCode(m[1]) {
+		code := strings.ToUpper(m[1])
+		if len(currency) > 0 && currency != code {
+			return str, ""
+		}
+		return strings.TrimSpace(m[2]), code
+	}
+
+	if m := currencySuffixRegex.FindStringSubmatch(amount); m != nil && IsCurrencyCode(m[2]) {
+		code := strings.ToUpper(m[2])
+		if len(currency) > 0 && currency != code {
+			return str, ""
+		}
+		return strings.TrimSpace(m[1]), code
+	}
+
+	return strings.TrimSpace(amount), currency
+}
+
+// Whether the string is a currency code we have rates for e.g. USD
+func IsCurrencyCode(str string) bool {
+	return currencyCodes[strings.ToUpper(str)]
+}
+
+// Whether the string is a currency code or symbol e.g. the EUR in "25 EUR"
+func IsCurrency(str string) bool {
+	_, ok := currencySymbols[str]
+	return ok || IsCurrencyCode(str)
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A small recursive descent parser for amounts like 12.50+3.20 or (45+12)/3.
+// Only numbers, + - * / and parentheses are allowed so it is safe to run on anything a user sends.
+//
+//	expression = term { ("+" | "-") term }
+//	term       = factor { ("*" | "/") factor }
+//	factor     = ["+" | "-"] ( number | "(" expression ")" )
+type expressionParser struct {
+	input []rune
+	pos   int
+}
+
+const (
+	MAX_EXPRESSION_LENGTH int = 100
+)
+
+func EvaluateExpression(expression string) (float64, error) {
+	stripped := strings.ReplaceAll(expression, " ", "")
+	if len(stripped) == 0 {
+		return 0, fmt.Errorf("Empty expression")
+	}
+	if len(stripped) > MAX_EXPRESSION_LENGTH {
+		return 0, fmt.Errorf("Expression too long")
+	}
+
+	p := expressionParser{input: []rune(stripped)}
+	val, err := p.parseExpression()
+	if err != nil {
+		return 0, err
+	}
+
+	if p.pos != len(p.input) {
+		return 0, fmt.Errorf("Unexpected %q in expression", string(p.input[p.pos]))
+	}
+
+	return val, nil
+}
+
+// Whether there is more to the expression than a single number
+func IsExpression(expression string) bool {
+	return strings.ContainsAny(strings.TrimPrefix(strings.TrimSpace(expression), "-"), "+-*/()")
+}
+
+// private
+
+func (p *expressionParser) peek() rune {
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *expressionParser) parseExpression() (float64, error) {
+	val, err := p.parseTerm()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return val, nil
+		}
+		p.pos++
+
+		right, err := p.parseTerm()
+		if err != nil {
+			return 0, err
+		}
+
+		if op == '+' {
+			val += right
+		} else {
+			val -= right
+		}
+	}
+}
+
+func (p *expressionParser) parseTerm() (float64, error) {
+	val, err := p.parseFactor()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return val, nil
+		}
+		p.pos++
+
+		right, err := p.parseFactor()
+		if err != nil {
+			return 0, err
+		}
+
+		if op == '*' {
+			val *= right
+		} else {
+			if right == 0 {
+				return 0, fmt.Errorf("Cannot divide by zero")
+			}
+			val /= right
+		}
+	}
+}
+
+func (p *expressionParser) parseFactor() (float64, error) {
+	switch p.peek() {
+	case '+':
+		p.pos++
+		return p.parseFactor()
+	case '-':
+		p.pos++
+		val, err := p.parseFactor()
+		return -val, err
+	case '(':
+		p.pos++
+		val, err := p.parseExpression()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("Missing closing bracket")
+		}
+		p.pos++
+		return val, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] == '.' || (p.input[p.pos] >= '0' && p.input[p.pos] <= '9')) {
+		p.pos++
+	}
+
+	if start == p.pos {
+		if p.pos >= len(p.input) {
+			return 0, fmt.Errorf("Unexpected end of expression")
+		}
+		return 0, fmt.Errorf("Unexpected %q in expression", string(p.input[p.pos]))
+	}
+
+	return strconv.ParseFloat(string(p.input[start:p.pos]), 64)
+}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Advisory lock held on a sidecar lock file so it survives the data file being replaced by a rename
+type FileLock struct {
+	file *os.File
+}
+
+func LockFile(path string, exclusive bool) (*FileLock, error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open lock file: %w", err)
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Failed to lock file: %w", err)
+	}
+
+	return &FileLock{file: f}, nil
+}
+
+func (l *FileLock) Unlock() error {
+	defer l.file.Close()
+	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,43 @@
+//go:build !unix
+
+package utils
+
+import "sync"
+
+// No flock outside of unix so only lock within the process
+type FileLock struct {
+	mu *sync.RWMutex
+	ex bool
+}
+
+var (
+	fileLocksMu sync.Mutex
+	fileLocks   = map[string]*sync.RWMutex{}
+)
+
+func LockFile(path string, exclusive bool) (*FileLock, error) {
+	fileLocksMu.Lock()
+	mu, ok := fileLocks[path]
+	if !ok {
+		mu = &sync.RWMutex{}
+		fileLocks[path] = mu
+	}
+	fileLocksMu.Unlock()
+
+	if exclusive {
+		mu.Lock()
+	} else {
+		mu.RLock()
+	}
+
+	return &FileLock{mu: mu, ex: exclusive}, nil
+}
+
+func (l *FileLock) Unlock() error {
+	if l.ex {
+		l.mu.Unlock()
+	} else {
+		l.mu.RUnlock()
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package utils
+
+import "strings"
+
+// Returns the indexes of the categories that best match the query. Exact matches win, then prefixes, then
+// anything containing the query and finally anything within a couple of typos.
+func MatchCategories(query string, categories []string) []int {
+	q := normaliseCategory(query)
+	if len(q) == 0 {
+		return []int{}
+	}
+
+	exact := []int{}
+	prefix := []int{}
+	contains := []int{}
+	for i, c := range categories {
+		norm := normaliseCategory(c)
+		switch {
+		case norm == q:
+			exact = append(exact, i)
+		case strings.HasPrefix(norm, q):
+			prefix = append(prefix, i)
+		case strings.Contains(norm, q):
+			contains = append(contains, i)
+		}
+	}
+
+	switch {
+	case len(exact) > 0:
+		return exact
+	case len(prefix) > 0:
+		return prefix
+	case len(contains) > 0:
+		return contains
+	}
+
+	// allow roughly one typo per four characters
+	maxDistance := max(1, len([]rune(q))/4)
+	best := []int{}
+	bestDistance := maxDistance + 1
+	for i, c := range categories {
+		d := levenshtein(q, normaliseCategory(c))
+		if d < bestDistance {
+			best = []int{i}
+			bestDistance = d
+		} else if d == bestDistance {
+			best = append(best, i)
+		}
+	}
+
+	return best
+}
+
+// private
+
+func normaliseCategory(category string) string {
+	return strings.ToLower(strings.ReplaceAll(category, " ", ""))
+}
+
+func levenshtein(a string, b string) int {
+	ar := []rune(a)
+	br := []rune(b)
+
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(br)]
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+type numberFormat struct {
+	decimal string
+	group   string
+	// e.g. 12,50 € rather than €12.50
+	symbolAfter bool
+}
+
+// currencies without minor units
+var currencyDecimals = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"ISK": 0,
+}
+
+// Formats an amount for a currency and locale e.g. £1,234.50 for GBP in en-GB or 1.234,50 € for EUR in de-DE
+func FormatAmount(value float64, currency string, locale string) string {
+	format := getNumberFormat(locale)
+	currency = strings.ToUpper(currency)
+
+	decimals, ok := currencyDecimals[currency]
+	if !ok {
+		decimals = 2
+	}
+
+	sign := ""
+	if value < 0 && math.Round(value*math.Pow10(decimals)) != 0 {
+		sign = "-"
+	}
+
+	digits := strconv.FormatFloat(math.Abs(value), 'f', decimals, 64)
+	whole, fraction, _ := strings.Cut(digits, ".")
+
+	// group the thousands
+	var grouped strings.Builder
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteString(format.group)
+		}
+		grouped.WriteRune(d)
+	}
+
+	number := grouped.String()
+	if len(fraction) > 0 {
+		number += format.decimal + fraction
+	}
+
+	symbol, ok := currencySymbol(currency)
+	switch {
+	case format.symbolAfter:
+		return sign + number + " " + symbol
+	case ok:
+		return sign + symbol + number
+	default:
+		// codes need a space e.g. CHF 12.50
+		return sign + symbol + " " + number
+	}
+}
+
+// Converts an amount typed in a locale to the form the parser expects e.g. 1.234,50 in de-DE becomes 1234.50
+func NormaliseAmount(str string, locale string) string {
+	format := getNumberFormat(locale)
+
+	// drop thousand separators, only where followed by exactly 3 digits so 12.50 in a comma locale is left alone
+	runes := []rune(str)
+	var builder strings.Builder
+	for i, r := range runes {
+		if string(r) == format.group && i > 0 && isDigit(runes[i-1]) && isGroupOfThree(runes[i+1:]) {
+			continue
+		}
+		builder.WriteRune(r)
+	}
+
+	normalised := builder.String()
+	if format.decimal != "." {
+		normalised = strings.ReplaceAll(normalised, format.decimal, ".")
+	}
+
+	return normalised
+}
+
+// private
+
+func getNumberFormat(locale string) numberFormat {
+	// anything unknown (or empty) formats like en-GB
+	language, _, _ := strings.Cut(strings.ToLower(strings.ReplaceAll(locale, "_", "-")), "-")
+	switch language {
+	case "de", "es", "it", "pt", "nl", "da", "id", "tr", "el", "ro", "hr", "sl":
+		return numberFormat{decimal: ",", group: ".", symbolAfter: true}
+	case "fr", "pl", "cs", "sk", "sv", "nb", "no", "fi", "hu", "bg", "ru", "uk", "lt", "lv", "et":
+		return numberFormat{decimal: ",", group: " ", symbolAfter: true}
+	default:
+		return numberFormat{decimal: ".", group: ",", symbolAfter: false}
+	}
+}
+
+func currencySymbol(currency string) (string, bool) {
+	for symbol, code := range currencySymbols {
+		if code == currency {
+			return symbol, true
+		}
+	}
+
+	return currency, false
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isGroupOfThree(runes []rune) bool {
+	if len(runes) < 3 {
+		return false
+	}
+	for _, r := range runes[:3] {
+		if !isDigit(r) {
+			return false
+		}
+	}
+
+	return len(runes) == 3 || !isDigit(runes[3])
+}
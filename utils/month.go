@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+var monthNames = map[string]time.Month{
+	"january": time.January, "jan": time.January,
+	"february": time.February, "feb": time.February,
+	"march": time.March, "mar": time.March,
+	"april": time.April, "apr": time.April,
+	"may":  time.May,
+	"june": time.June, "jun": time.June,
+	"july": time.July, "jul": time.July,
+	"august": time.August, "aug": time.August,
+	"september": time.September, "sep": time.September, "sept": time.September,
+	"october": time.October, "oct": time.October,
+	"november": time.November, "nov": time.November,
+	"december": time.December, "dec": time.December,
+}
+
+// Finds a month at the end of the tokens e.g. "groceries march" or "groceries march 2025" and returns the first
+// of that month and how many tokens it took up. Without a year it is the latest one up to now so in January
+// "december" is last December. Nil when the tokens don't end in a month
+func TrailingMonth(tokens []string, now time.Time) (*time.Time, int) {
+	if len(tokens) == 0 {
+		return nil, 0
+	}
+
+	last := len(tokens) - 1
+	if len(tokens) > 1 && len(tokens[last]) == 4 {
+		if year, err := strconv.Atoi(tokens[last]); err == nil {
+			if month, ok := monthNames[strings.ToLower(tokens[last-1])]; ok {
+				date := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+				return &date, 2
+			}
+		}
+	}
+
+	month, ok := monthNames[strings.ToLower(tokens[last])]
+	if !ok {
+		return nil, 0
+	}
+
+	year := now.Year()
+	if month > now.Month() {
+		year--
+	}
+	date := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+
+	return &date, 1
+}
@@ -2,7 +2,16 @@ package utils
 
 import "regexp"
 
+// a number or a sum of numbers e.g. 12.50, -12.50 or 12.50+3.20
+var financialRegex = regexp.MustCompile(`^-?[\d.(][\d.+\-*/()]*$`)
+
+// Whether the string is an amount, optionally in a currency e.g. £12.50, $25 or 25 EUR
 func IsFinancial(str string) bool {
-	re := regexp.MustCompile(`^£?\d+(\.\d+)?$`)
-	return re.MatchString(str)
+	amount, _ := SplitCurrency(str)
+	if !financialRegex.MatchString(amount) {
+		return false
+	}
+
+	_, err := EvaluateExpression(amount)
+	return err == nil
 }
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// whatsapp numbers are sent as whatsapp:+447700900123, sms ones as plain numbers
+	TWILIO_WHATSAPP_PREFIX string = "whatsapp:"
+)
+
+// The X-Twilio-Signature for a request, a base64 HMAC-SHA1 of the url followed by each posted name and value
+// sorted by name, keyed with the auth token
+func TwilioSignature(authToken string, requestUrl string, params url.Values) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	builder.WriteString(requestUrl)
+	for _, name := range names {
+		values := append([]string{}, params[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			builder.WriteString(name)
+			builder.WriteString(value)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(builder.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func ValidTwilioSignature(authToken string, requestUrl string, params url.Values, signature string) bool {
+	expected := TwilioSignature(authToken, requestUrl, params)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// The number without the channel or spaces e.g. whatsapp:+44 7700 900123 is +447700900123
+func NormalisePhoneNumber(number string) string {
+	number = strings.TrimPrefix(strings.TrimSpace(number), TWILIO_WHATSAPP_PREFIX)
+	return strings.ReplaceAll(number, " ", "")
+}
+
+// The digits of a number as an id e.g. for dialogs, 0 if there are none
+func PhoneNumberId(number string) int64 {
+	digits := strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, number)
+
+	id, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
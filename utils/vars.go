@@ -15,13 +15,15 @@ func AssertEnvVars() error {
 	if len(os.Getenv(CONFIG_PATH_KEY)) == 0 {
 		return fmt.Errorf("Missing env var %s", CONFIG_PATH_KEY)
 	}
-	if len(os.Getenv(VALKEY_HOST_KEY)) == 0 {
-		return fmt.Errorf("Missing env var %s", VALKEY_HOST_KEY)
-	}
 
 	return nil
 }
 
+// Whether a valkey host is set, without one the storage defaults to memory
+func HasValkey() bool {
+	return len(os.Getenv(VALKEY_HOST_KEY)) > 0
+}
+
 func IsDevelopment() bool {
 	return os.Getenv(EVIRONMENT_KEY) == "development"
 }